@@ -11,7 +11,10 @@ func registerPodman(s *provider.Store) {
 			cfg.ConfigPath,
 			cfg.NodeName,
 			cfg.OperatingSystem,
+			cfg.InternalIP,
+			cfg.DaemonPort,
 			cfg.ResourceManager,
+			cfg.EventRecorder,
 		)
 	})
 }
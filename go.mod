@@ -39,8 +39,10 @@ require (
 	github.com/varlink/go v0.0.0-20191018142704-4ecdbb8a36c2
 	github.com/virtual-kubelet/virtual-kubelet v1.1.0
 	github.com/zmb3/gogetdoc v0.0.0-20190228002656-b37376c5da6a // indirect
+	go.etcd.io/bbolt v1.3.5
 	go.opencensus.io v0.22.0
 	go.uber.org/zap v1.12.0
+	golang.org/x/crypto v0.0.0-20191029031824-8986dd9e96cf
 	google.golang.org/api v0.6.0 // indirect
 	google.golang.org/appengine v1.6.1 // indirect
 	google.golang.org/genproto v0.0.0-20190620144150-6af8c5fc6601 // indirect
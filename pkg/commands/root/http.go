@@ -0,0 +1,241 @@
+// Copyright © 2017 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/virtual-kubelet/podman/pkg/provider"
+)
+
+// AcceptedCiphers is the list of accepted TLS ciphers, with known weak ciphers elided
+// Note this list should be a moving target.
+var AcceptedCiphers = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+}
+
+func loadTLSConfig(certPath, keyPath string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading tls certs")
+	}
+
+	return &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: true,
+		CipherSuites:             AcceptedCiphers,
+	}, nil
+}
+
+type apiServerConfig struct {
+	CertPath string
+	KeyPath  string
+	Addr     string
+}
+
+func getAPIConfig(c Opts) *apiServerConfig {
+	return &apiServerConfig{
+		CertPath: os.Getenv("APISERVER_CERT_LOCATION"),
+		KeyPath:  os.Getenv("APISERVER_KEY_LOCATION"),
+		Addr:     fmt.Sprintf(":%d", c.ListenPort),
+	}
+}
+
+// setupHTTPServer serves logs and exec routes of pods of nodes in routes
+// until the returned function is called. All nodes run by the process share
+// the listen port, so a single server is set up for them
+func setupHTTPServer(ctx context.Context, cfg *apiServerConfig, routes *nodeRoutes) (func(), error) {
+	if cfg.CertPath == "" || cfg.KeyPath == "" {
+		log.G(ctx).
+			WithField("certPath", cfg.CertPath).
+			WithField("keyPath", cfg.KeyPath).
+			Error("TLS certificates not provided, not setting up pod http server")
+		return func() {}, nil
+	}
+
+	tlsCfg, err := loadTLSConfig(cfg.CertPath, cfg.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+	l, err := tls.Listen("tcp", cfg.Addr, tlsCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "error setting up listener for pod http server")
+	}
+
+	s := &http.Server{
+		Handler:   podHandler(routes),
+		TLSConfig: tlsCfg,
+	}
+	go serveHTTP(ctx, s, l, "pods")
+	return func() { s.Close() }, nil
+}
+
+// podHandler returns the handler of kubelet pod routes served by routes
+func podHandler(routes *nodeRoutes) http.Handler {
+	mux := http.NewServeMux()
+	api.AttachPodRoutes(api.PodHandlerConfig{
+		RunInContainer:   routes.RunInContainer,
+		GetContainerLogs: routes.GetContainerLogs,
+		GetPods:          routes.GetPods,
+	}, mux, true)
+	return rejectLogOptions(mux)
+}
+
+// rejectLogOptions fails logs requests with options that api.ContainerLogOpts
+// can't carry, rather than return logs ignoring them
+func rejectLogOptions(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/containerLogs/") {
+			q := req.URL.Query()
+			for _, name := range []string{"follow", "previous"} {
+				if v, _ := strconv.ParseBool(q.Get(name)); v {
+					http.Error(w, fmt.Sprintf("%s option of logs is not supported", name), http.StatusBadRequest)
+					return
+				}
+			}
+			if q.Get("sinceTime") != "" {
+				http.Error(w, "sinceTime option of logs is not supported, use sinceSeconds", http.StatusBadRequest)
+				return
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+func serveHTTP(ctx context.Context, s *http.Server, l net.Listener, name string) {
+	if err := s.Serve(l); err != nil && err != http.ErrServerClosed {
+		select {
+		case <-ctx.Done():
+		default:
+			log.G(ctx).WithError(err).Errorf("Error setting up %s http server", name)
+		}
+	}
+	l.Close()
+}
+
+// nodeRoutes dispatches pod routes to the provider of the node running the
+// pod. Nodes add their provider once it is initialized and remove it when they
+// stop
+type nodeRoutes struct {
+	mu        sync.Mutex
+	providers map[string]provider.Provider
+}
+
+func newNodeRoutes() *nodeRoutes {
+	return &nodeRoutes{providers: map[string]provider.Provider{}}
+}
+
+func (r *nodeRoutes) add(nodeName string, p provider.Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[nodeName] = p
+}
+
+func (r *nodeRoutes) remove(nodeName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.providers, nodeName)
+}
+
+// list returns providers of running nodes ordered by node name
+func (r *nodeRoutes) list() []provider.Provider {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	providers := make([]provider.Provider, 0, len(names))
+	for _, name := range names {
+		providers = append(providers, r.providers[name])
+	}
+	return providers
+}
+
+// provider returns provider of the node running the pod. A single node is
+// not asked for the pod: its provider reports missing pods itself
+func (r *nodeRoutes) provider(ctx context.Context, namespace, podName string) (provider.Provider, error) {
+	providers := r.list()
+	if len(providers) == 1 {
+		return providers[0], nil
+	}
+	for _, p := range providers {
+		pod, err := p.GetPod(ctx, namespace, podName)
+		if err != nil && !errdefs.IsNotFound(err) {
+			return nil, err
+		}
+		if pod != nil {
+			return p, nil
+		}
+	}
+	return nil, errdefs.NotFoundf("pod %s/%s is not found", namespace, podName)
+}
+
+// GetPods returns pods of all running nodes
+func (r *nodeRoutes) GetPods(ctx context.Context) ([]*corev1.Pod, error) {
+	var pods []*corev1.Pod
+	for _, p := range r.list() {
+		nodePods, err := p.GetPods(ctx)
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, nodePods...)
+	}
+	return pods, nil
+}
+
+// GetContainerLogs returns logs of the container from the node running the pod
+func (r *nodeRoutes) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts api.ContainerLogOpts) (io.ReadCloser, error) {
+	p, err := r.provider(ctx, namespace, podName)
+	if err != nil {
+		return nil, err
+	}
+	return p.GetContainerLogs(ctx, namespace, podName, containerName, opts)
+}
+
+// RunInContainer runs the command in the container on the node running the pod
+func (r *nodeRoutes) RunInContainer(ctx context.Context, namespace, podName, containerName string, cmd []string, attach api.AttachIO) error {
+	p, err := r.provider(ctx, namespace, podName)
+	if err != nil {
+		return err
+	}
+	return p.RunInContainer(ctx, namespace, podName, containerName, cmd, attach)
+}
@@ -0,0 +1,137 @@
+package root
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/virtual-kubelet/podman/pkg/provider"
+)
+
+// fakeProvider runs pods of names in namespace default and returns logs and
+// exec output naming the node
+type fakeProvider struct {
+	provider.Provider
+	node string
+	pods []string
+}
+
+func (p *fakeProvider) GetPod(ctx context.Context, namespace, name string) (*corev1.Pod, error) {
+	for _, pod := range p.pods {
+		if namespace == "default" && name == pod {
+			return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}, nil
+		}
+	}
+	return nil, errdefs.NotFoundf("pod %s/%s is not found", namespace, name)
+}
+
+func (p *fakeProvider) GetPods(ctx context.Context) ([]*corev1.Pod, error) {
+	var pods []*corev1.Pod
+	for _, name := range p.pods {
+		pod, _ := p.GetPod(ctx, "default", name)
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+func (p *fakeProvider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts api.ContainerLogOpts) (io.ReadCloser, error) {
+	if _, err := p.GetPod(ctx, namespace, podName); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(strings.NewReader(p.node + "/" + podName + "/" + containerName)), nil
+}
+
+func (p *fakeProvider) RunInContainer(ctx context.Context, namespace, podName, containerName string, cmd []string, attach api.AttachIO) error {
+	if _, err := p.GetPod(ctx, namespace, podName); err != nil {
+		return err
+	}
+	_, err := io.WriteString(attach.Stdout(), p.node+"/"+podName+"/"+containerName+" "+strings.Join(cmd, " "))
+	return err
+}
+
+// testAttach is exec attach with stdout only
+type testAttach struct {
+	stdout strings.Builder
+}
+
+func (a *testAttach) Stdin() io.Reader            { return nil }
+func (a *testAttach) Stdout() io.WriteCloser      { return nopCloser{&a.stdout} }
+func (a *testAttach) Stderr() io.WriteCloser      { return nil }
+func (a *testAttach) TTY() bool                   { return false }
+func (a *testAttach) Resize() <-chan api.TermSize { return nil }
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestNodeRoutes(t *testing.T) {
+	routes := newNodeRoutes()
+	routes.add("node-1", &fakeProvider{node: "node-1", pods: []string{"a"}})
+	routes.add("node-2", &fakeProvider{node: "node-2", pods: []string{"b", "c"}})
+	routes.add("node-3", &fakeProvider{node: "node-3"})
+	routes.remove("node-3")
+
+	server := httptest.NewServer(podHandler(routes))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{path: "/containerLogs/default/a/app", status: http.StatusOK, body: "node-1/a/app"},
+		{path: "/containerLogs/default/c/app", status: http.StatusOK, body: "node-2/c/app"},
+		{path: "/containerLogs/default/d/app", status: http.StatusNotFound},
+		{path: "/containerLogs/other/a/app", status: http.StatusNotFound},
+		{path: "/containerLogs/default/a/app?follow=false&tailLines=5", status: http.StatusOK, body: "node-1/a/app"},
+		{path: "/containerLogs/default/a/app?follow=true", status: http.StatusBadRequest},
+		{path: "/containerLogs/default/a/app?previous=1", status: http.StatusBadRequest},
+		{path: "/containerLogs/default/a/app?sinceTime=2019-01-01T00:00:00Z", status: http.StatusBadRequest},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tc.path)
+			assert.NilError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, resp.StatusCode, tc.status)
+			if tc.status == http.StatusOK {
+				body, err := ioutil.ReadAll(resp.Body)
+				assert.NilError(t, err)
+				assert.Equal(t, string(body), tc.body)
+			}
+		})
+	}
+
+	pods, err := routes.GetPods(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, len(pods), 3)
+
+	attach := &testAttach{}
+	assert.NilError(t, routes.RunInContainer(context.Background(), "default", "b", "app", []string{"ls", "/"}, attach))
+	assert.Equal(t, attach.stdout.String(), "node-2/b/app ls /")
+	err = routes.RunInContainer(context.Background(), "default", "d", "app", []string{"ls"}, &testAttach{})
+	assert.Assert(t, errdefs.IsNotFound(err))
+}
+
+func TestNodeRoutesSingleNode(t *testing.T) {
+	routes := newNodeRoutes()
+	routes.add("node-1", &fakeProvider{node: "node-1", pods: []string{"a"}})
+
+	_, err := routes.GetContainerLogs(context.Background(), "default", "b", "app", api.ContainerLogOpts{})
+	assert.Assert(t, errdefs.IsNotFound(err))
+
+	routes.remove("node-1")
+	_, err = routes.GetContainerLogs(context.Background(), "default", "a", "app", api.ContainerLogOpts{})
+	assert.Assert(t, errdefs.IsNotFound(err))
+}
@@ -0,0 +1,145 @@
+// Copyright © 2017 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// configPollInterval is how often the provider config file is checked for
+// added, removed and changed nodes in multi-node mode
+const configPollInterval = 10 * time.Second
+
+// runningNode is a node started by runNodes
+type runningNode struct {
+	config json.RawMessage
+	cancel context.CancelFunc
+	// done is closed once the node stops
+	done chan struct{}
+}
+
+func (n *runningNode) stop() {
+	n.cancel()
+	<-n.done
+}
+
+func (n *runningNode) stopped() bool {
+	select {
+	case <-n.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// runNodes runs a node for every entry of the provider config file until ctx
+// is done. The file is polled for changes: nodes of new entries are started,
+// nodes of removed entries are stopped and deleted from Kubernetes and nodes
+// which entry changed are restarted. Nodes which fail are started again on
+// the next poll
+func runNodes(ctx context.Context, c Opts, env *nodeEnv) error {
+	running := map[string]*runningNode{}
+	defer func() {
+		for _, n := range running {
+			n.stop()
+		}
+	}()
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for {
+		configs, err := readNodeConfigs(c.ProviderConfigPath)
+		if err != nil {
+			log.G(ctx).Errorf("error reading provider config %s: %v", c.ProviderConfigPath, err)
+		} else {
+			syncNodes(ctx, c, env, running, configs, runNode)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncNodes starts and stops nodes so running nodes match configs. Nodes are
+// run with run
+func syncNodes(ctx context.Context, c Opts, env *nodeEnv, running map[string]*runningNode, configs map[string]json.RawMessage, run func(context.Context, Opts, *nodeEnv) error) {
+	for name, n := range running {
+		config, exists := configs[name]
+		switch {
+		case !exists:
+			log.G(ctx).Infof("node %s removed from provider config, stopping", name)
+			n.stop()
+			delete(running, name)
+			err := env.client.CoreV1().Nodes().Delete(name, &metav1.DeleteOptions{})
+			if err != nil && !k8serrors.IsNotFound(err) {
+				log.G(ctx).Errorf("error deleting node %s: %v", name, err)
+			}
+		case !bytes.Equal(config, n.config):
+			log.G(ctx).Infof("node %s changed in provider config, restarting", name)
+			n.stop()
+			delete(running, name)
+		case n.stopped():
+			delete(running, name)
+		}
+	}
+
+	for name, config := range configs {
+		if _, exists := running[name]; exists {
+			continue
+		}
+		nodeCtx, cancel := context.WithCancel(ctx)
+		n := &runningNode{
+			config: config,
+			cancel: cancel,
+			done:   make(chan struct{}),
+		}
+		running[name] = n
+
+		nodeOpts := c
+		nodeOpts.NodeName = name
+		go func() {
+			defer close(n.done)
+			log.G(ctx).Infof("starting node %s", nodeOpts.NodeName)
+			if err := run(nodeCtx, nodeOpts, env); err != nil {
+				log.G(ctx).Errorf("node %s failed, restarting on next config poll: %v", nodeOpts.NodeName, err)
+			}
+		}()
+	}
+}
+
+// readNodeConfigs returns entries of the provider config file keyed by node
+// name
+func readNodeConfigs(path string) (map[string]json.RawMessage, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	configs := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
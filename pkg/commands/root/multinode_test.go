@@ -0,0 +1,155 @@
+package root
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeNodes runs nodes until their context is done, nodes in fail fail
+// right away
+type fakeNodes struct {
+	mu     sync.Mutex
+	starts map[string]int
+	fail   map[string]bool
+}
+
+func (f *fakeNodes) run(ctx context.Context, c Opts, env *nodeEnv) error {
+	f.mu.Lock()
+	f.starts[c.NodeName]++
+	fail := f.fail[c.NodeName]
+	f.mu.Unlock()
+	if fail {
+		return fmt.Errorf("node %s failed", c.NodeName)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (f *fakeNodes) started(name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.starts[name]
+}
+
+// waitFor waits until cond is true, failing the test after 5 seconds
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func runningNames(running map[string]*runningNode) []string {
+	var names []string
+	for name := range running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestReadNodeConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multinode")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+
+	_, err = readNodeConfigs(path)
+	assert.Assert(t, os.IsNotExist(err))
+
+	assert.NilError(t, ioutil.WriteFile(path, []byte(`{"a": {"cpu": "1"}, "b": {}}`), 0644))
+	configs, err := readNodeConfigs(path)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, configs, map[string]json.RawMessage{
+		"a": json.RawMessage(`{"cpu": "1"}`),
+		"b": json.RawMessage(`{}`),
+	})
+
+	assert.NilError(t, ioutil.WriteFile(path, []byte(`{"a": `), 0644))
+	_, err = readNodeConfigs(path)
+	assert.ErrorContains(t, err, "unexpected end of JSON input")
+}
+
+func TestSyncNodes(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "a"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "b"}},
+	)
+	env := &nodeEnv{client: client}
+	nodes := &fakeNodes{starts: map[string]int{}, fail: map[string]bool{"c": true}}
+	running := map[string]*runningNode{}
+	defer func() {
+		for _, n := range running {
+			n.stop()
+		}
+	}()
+	apply := func(configs map[string]json.RawMessage) {
+		syncNodes(ctx, Opts{NodeName: "ignored"}, env, running, configs, nodes.run)
+	}
+
+	apply(map[string]json.RawMessage{
+		"a": json.RawMessage(`{"cpu": "1"}`),
+		"b": json.RawMessage(`{}`),
+	})
+	waitFor(t, func() bool { return nodes.started("a") == 1 && nodes.started("b") == 1 })
+	assert.DeepEqual(t, runningNames(running), []string{"a", "b"})
+
+	// unchanged nodes keep running
+	apply(map[string]json.RawMessage{
+		"a": json.RawMessage(`{"cpu": "1"}`),
+		"b": json.RawMessage(`{}`),
+	})
+	assert.Equal(t, nodes.started("a"), 1)
+	assert.Equal(t, nodes.started("b"), 1)
+
+	// changed node is restarted, removed node is stopped and deleted
+	b := running["b"]
+	apply(map[string]json.RawMessage{
+		"a": json.RawMessage(`{"cpu": "2"}`),
+	})
+	assert.Assert(t, b.stopped())
+	waitFor(t, func() bool { return nodes.started("a") == 2 })
+	assert.DeepEqual(t, runningNames(running), []string{"a"})
+	_, err := client.CoreV1().Nodes().Get("b", metav1.GetOptions{})
+	assert.Assert(t, k8serrors.IsNotFound(err))
+	_, err = client.CoreV1().Nodes().Get("a", metav1.GetOptions{})
+	assert.NilError(t, err)
+
+	// failed node is started again on next sync
+	apply(map[string]json.RawMessage{
+		"a": json.RawMessage(`{"cpu": "2"}`),
+		"c": json.RawMessage(`{}`),
+	})
+	waitFor(t, func() bool { return running["c"].stopped() })
+	apply(map[string]json.RawMessage{
+		"a": json.RawMessage(`{"cpu": "2"}`),
+		"c": json.RawMessage(`{}`),
+	})
+	waitFor(t, func() bool { return nodes.started("c") == 2 })
+	assert.Equal(t, nodes.started("a"), 2)
+
+	// removing node which is not registered doesn't fail
+	apply(map[string]json.RawMessage{
+		"a": json.RawMessage(`{"cpu": "2"}`),
+	})
+	assert.DeepEqual(t, runningNames(running), []string{"a"})
+}
@@ -65,6 +65,10 @@ type Opts struct {
 	Provider           string
 	ProviderConfigPath string
 
+	// Run a node for every entry of the provider config file instead of
+	// only for NodeName
+	MultiNode bool
+
 	TaintKey     string
 	TaintEffect  string
 	DisableTaint bool
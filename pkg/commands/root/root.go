@@ -16,6 +16,7 @@ package root
 
 import (
 	"context"
+	"io"
 	"os"
 	"path"
 
@@ -30,6 +31,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	kubeinformers "k8s.io/client-go/informers"
+	corev1informers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/kubernetes/typed/coordination/v1beta1"
@@ -86,6 +88,68 @@ func runRootCommand(ctx context.Context, s *provider.Store, c Opts) error {
 		return err
 	}
 
+	pInit := s.Get(c.Provider)
+	if pInit == nil {
+		return errors.Errorf("provider %q not found", c.Provider)
+	}
+
+	// Create a shared informer factory for Kubernetes secrets and configmaps (not subject to any selectors).
+	scmInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(client, c.InformerResyncPeriod)
+
+	eb := record.NewBroadcaster()
+	eb.StartLogging(log.G(ctx).Infof)
+	eb.StartRecordingToSink(&corev1client.EventSinkImpl{Interface: client.CoreV1().Events(c.KubeNamespace)})
+
+	env := &nodeEnv{
+		client: client,
+		taint:  taint,
+		pInit:  pInit,
+		eb:     eb,
+		// Create a secret informer and a config map informer so we can pass their listers to the resource manager.
+		secretInformer:         scmInformerFactory.Core().V1().Secrets(),
+		configMapInformer:      scmInformerFactory.Core().V1().ConfigMaps(),
+		serviceInformer:        scmInformerFactory.Core().V1().Services(),
+		serviceAccountInformer: scmInformerFactory.Core().V1().ServiceAccounts(),
+		routes:                 newNodeRoutes(),
+	}
+	go scmInformerFactory.Start(ctx.Done())
+
+	cancelHTTP, err := setupHTTPServer(ctx, getAPIConfig(c), env.routes)
+	if err != nil {
+		return err
+	}
+	defer cancelHTTP()
+
+	if c.MultiNode {
+		return runNodes(ctx, c, env)
+	}
+	return runNode(ctx, c, env)
+}
+
+// nodeEnv holds clients and informers shared by all nodes run by the process
+type nodeEnv struct {
+	client kubernetes.Interface
+	taint  *corev1.Taint
+	pInit  provider.InitFunc
+	eb     record.EventBroadcaster
+
+	secretInformer         corev1informers.SecretInformer
+	configMapInformer      corev1informers.ConfigMapInformer
+	serviceInformer        corev1informers.ServiceInformer
+	serviceAccountInformer corev1informers.ServiceAccountInformer
+
+	// routes serves logs and exec requests of pods of running nodes
+	routes *nodeRoutes
+}
+
+// runNode runs provider, node controller and pod controller of node
+// c.NodeName until ctx is done
+func runNode(ctx context.Context, c Opts, env *nodeEnv) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	client := env.client
+
 	// Create a shared informer factory for Kubernetes pods in the current namespace (if specified) and scheduled to the current node.
 	podInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(
 		client,
@@ -96,14 +160,7 @@ func runRootCommand(ctx context.Context, s *provider.Store, c Opts) error {
 		}))
 	podInformer := podInformerFactory.Core().V1().Pods()
 
-	// Create another shared informer factory for Kubernetes secrets and configmaps (not subject to any selectors).
-	scmInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(client, c.InformerResyncPeriod)
-	// Create a secret informer and a config map informer so we can pass their listers to the resource manager.
-	secretInformer := scmInformerFactory.Core().V1().Secrets()
-	configMapInformer := scmInformerFactory.Core().V1().ConfigMaps()
-	serviceInformer := scmInformerFactory.Core().V1().Services()
-
-	rm, err := manager.NewResourceManager(podInformer.Lister(), secretInformer.Lister(), configMapInformer.Lister(), serviceInformer.Lister())
+	rm, err := manager.NewResourceManager(podInformer.Lister(), env.secretInformer.Lister(), env.configMapInformer.Lister(), env.serviceInformer.Lister(), env.serviceAccountInformer.Lister())
 	if err != nil {
 		return errors.Wrap(err, "could not create resource manager")
 	}
@@ -116,17 +173,19 @@ func runRootCommand(ctx context.Context, s *provider.Store, c Opts) error {
 		DaemonPort:        int32(c.ListenPort),
 		InternalIP:        os.Getenv("VKUBELET_POD_IP"),
 		KubeClusterDomain: c.KubeClusterDomain,
+		EventRecorder:     env.eb.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "kubelet", Host: c.NodeName}),
 	}
 
-	pInit := s.Get(c.Provider)
-	if pInit == nil {
-		return errors.Errorf("provider %q not found", c.Provider)
-	}
-
-	p, err := pInit(initConfig)
+	p, err := env.pInit(initConfig)
 	if err != nil {
 		return errors.Wrapf(err, "error initializing provider %s", c.Provider)
 	}
+	// providers with background workers stop them when the node stops
+	if closer, ok := p.(io.Closer); ok {
+		defer closer.Close()
+	}
+	env.routes.add(c.NodeName, p)
+	defer env.routes.remove(c.NodeName)
 
 	ctx = log.WithLogger(ctx, log.G(ctx).WithFields(log.Fields{
 		"provider":         c.Provider,
@@ -140,7 +199,7 @@ func runRootCommand(ctx context.Context, s *provider.Store, c Opts) error {
 		leaseClient = client.CoordinationV1beta1().Leases(corev1.NamespaceNodeLease)
 	}
 
-	pNode := NodeFromProvider(ctx, c.NodeName, taint, p, c.Version)
+	pNode := NodeFromProvider(ctx, c.NodeName, env.taint, p, c.Version)
 	nodeRunner, err := node.NewNodeController(
 		node.NaiveNodeProvider{},
 		pNode,
@@ -163,32 +222,29 @@ func runRootCommand(ctx context.Context, s *provider.Store, c Opts) error {
 		}),
 	)
 	if err != nil {
-		log.G(ctx).Fatal(err)
+		return errors.Wrap(err, "error setting up node controller")
 	}
 
-	eb := record.NewBroadcaster()
-	eb.StartLogging(log.G(ctx).Infof)
-	eb.StartRecordingToSink(&corev1client.EventSinkImpl{Interface: client.CoreV1().Events(c.KubeNamespace)})
-
 	pc, err := node.NewPodController(node.PodControllerConfig{
 		PodClient:         client.CoreV1(),
 		PodInformer:       podInformer,
-		EventRecorder:     eb.NewRecorder(scheme.Scheme, corev1.EventSource{Component: path.Join(pNode.Name, "pod-controller")}),
+		EventRecorder:     env.eb.NewRecorder(scheme.Scheme, corev1.EventSource{Component: path.Join(pNode.Name, "pod-controller")}),
 		Provider:          p,
-		SecretInformer:    secretInformer,
-		ConfigMapInformer: configMapInformer,
-		ServiceInformer:   serviceInformer,
+		SecretInformer:    env.secretInformer,
+		ConfigMapInformer: env.configMapInformer,
+		ServiceInformer:   env.serviceInformer,
 	})
 	if err != nil {
 		return errors.Wrap(err, "error setting up pod controller")
 	}
 
 	go podInformerFactory.Start(ctx.Done())
-	go scmInformerFactory.Start(ctx.Done())
 
+	// first error of the controllers stops the node
+	errc := make(chan error, 2)
 	go func() {
 		if err := pc.Run(ctx, c.PodSyncWorkers); err != nil && errors.Cause(err) != context.Canceled {
-			log.G(ctx).Fatal(err)
+			errc <- err
 		}
 	}()
 
@@ -209,14 +265,18 @@ func runRootCommand(ctx context.Context, s *provider.Store, c Opts) error {
 
 	go func() {
 		if err := nodeRunner.Run(ctx); err != nil {
-			log.G(ctx).Fatal(err)
+			errc <- err
 		}
 	}()
 
 	log.G(ctx).Info("Initialized")
 
-	<-ctx.Done()
-	return nil
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errc:
+		return err
+	}
 }
 
 func newClient(configPath string) (*kubernetes.Clientset, error) {
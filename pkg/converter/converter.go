@@ -4,13 +4,13 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"strings"
-	"time"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/ghodss/yaml"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 
 	"github.com/virtual-kubelet/podman/pkg/iopodman"
 )
@@ -27,28 +27,80 @@ func BuildKey(pod *v1.Pod) string {
 	return fmt.Sprintf("%s-%s", pod.Namespace, pod.Name)
 }
 
+// BuildContainerName returns podman container name for the container in the
+// pod identified by key
+func BuildContainerName(key, containerName string) string {
+	return fmt.Sprintf("%s-%s", key, containerName)
+}
+
+// PodDir returns host directory holding provider managed data of the pod
+func PodDir(root string, pod *v1.Pod) string {
+	return filepath.Join(root, BuildKey(pod))
+}
+
+// PodVolumePath returns host directory of the pod volume managed by the
+// provider
+func PodVolumePath(root string, pod *v1.Pod, volumeName string) string {
+	return filepath.Join(PodDir(root, pod), "volumes", volumeName)
+}
+
+// IsManagedVolume returns true for volumes which are created on the host by
+// the provider and bind-mounted into containers
+func IsManagedVolume(volume v1.Volume) bool {
+	return volume.EmptyDir != nil || IsAPIVolume(volume)
+}
+
+// IsAPIVolume returns true for volumes which content comes from kubernetes
+// API objects
+func IsAPIVolume(volume v1.Volume) bool {
+	return volume.Secret != nil || volume.ConfigMap != nil || volume.Projected != nil || volume.DownwardAPI != nil
+}
+
 func SplitPodName(key string) (namespace, name string) {
 	keys := strings.Split(key, "-")
 	return keys[0], keys[1]
 }
 
 // KubeSpecToPodmanContainer converts v1.Container to podman.Create spec. pod
-// argument is used to configure volumes and external configuration to container
-func KubeSpecToPodmanContainer(pod v1.Pod, container v1.Container, podName string) iopodman.Create {
+// argument is used to configure volumes and external configuration to container.
+// Provider managed volumes are bind-mounted from volumesDir
+func KubeSpecToPodmanContainer(pod v1.Pod, container v1.Container, podName, volumesDir string) iopodman.Create {
 	// TODO: Extend this to match most of the fields
 	var args []string
 	args = append(args, container.Image)
 	args = append(args, container.Command...)
 	args = append(args, container.Args...)
-	containerName := fmt.Sprintf("%s-%s", podName, container.Name)
+	containerName := BuildContainerName(podName, container.Name)
 
-	// construct hostPath pairs for mount
+	// construct host path pairs for mount
 	var volumes []string
-	for _, podVolume := range pod.Spec.Volumes {
-		for _, containerVolume := range container.VolumeMounts {
-			if podVolume.HostPath != nil && podVolume.Name == containerVolume.Name {
-				volumes = append(volumes, fmt.Sprintf("%s:%s", podVolume.HostPath.Path, containerVolume.MountPath))
+	for _, containerVolume := range container.VolumeMounts {
+		for _, podVolume := range pod.Spec.Volumes {
+			if podVolume.Name != containerVolume.Name {
+				continue
+			}
+			var source string
+			readOnly := containerVolume.ReadOnly
+			switch {
+			case podVolume.HostPath != nil:
+				source = podVolume.HostPath.Path
+			case podVolume.EmptyDir != nil:
+				source = PodVolumePath(volumesDir, &pod, podVolume.Name)
+			case IsAPIVolume(podVolume):
+				source = PodVolumePath(volumesDir, &pod, podVolume.Name)
+				// API backed volumes are always read-only, same as in kubelet
+				readOnly = true
+			default:
+				continue
+			}
+			if containerVolume.SubPath != "" {
+				source = filepath.Join(source, containerVolume.SubPath)
+			}
+			mount := fmt.Sprintf("%s:%s", source, containerVolume.MountPath)
+			if readOnly {
+				mount += ":ro"
 			}
+			volumes = append(volumes, mount)
 		}
 	}
 
@@ -71,83 +123,172 @@ func KubeSpecToPodmanContainer(pod v1.Pod, container v1.Container, podName strin
 		podmanPod.Net = StringPtr("host")
 	}
 
+	// env is expected to be resolved to literal values
 	var vars []string
-	for _, e := range pod.Spec.Containers[0].Env {
+	for _, e := range container.Env {
 		vars = append(vars, fmt.Sprintf("%s=%s", e.Name, e.Value))
 	}
 	podmanPod.Env = &vars
-	spew.Dump(podmanPod.Env)
 
 	return podmanPod
 }
 
-// GetPodmanPod return podmanPod with v1.Pod metadata in the label
-func GetPodmanPod(key string, p *v1.Pod) (*iopodman.PodCreate, error) {
-	// preserve original pod spec into lables
-	pod := p.DeepCopy()
-	data, err := yaml.Marshal(pod)
-	if err != nil {
-		return nil, err
+// Labels of podman pods identifying the kubernetes pod. The pod itself is
+// kept in the provider state store
+const (
+	LabelNamespace = "podman.virtual-kubelet.io/namespace"
+	LabelName      = "podman.virtual-kubelet.io/name"
+	LabelUID       = "podman.virtual-kubelet.io/uid"
+
+	// legacyPodLabel held base64 encoded YAML of the whole pod before pods
+	// were kept in the state store
+	legacyPodLabel = "pod"
+)
+
+// GetPodmanPod returns podman pod create options of the pod. Only namespace,
+// name and UID of the pod are kept in podman labels
+func GetPodmanPod(key string, pod *v1.Pod) (*iopodman.PodCreate, error) {
+	// infra container holds namespaces shared by containers of the pod
+	share := []string{"ipc", "uts"}
+	if !pod.Spec.HostNetwork {
+		share = append(share, "net")
 	}
-	podSpecBase := base64.StdEncoding.EncodeToString(data)
-	if pod.Labels == nil {
-		pod.Labels = make(map[string]string, 1)
+	if pod.Spec.ShareProcessNamespace != nil && *pod.Spec.ShareProcessNamespace {
+		share = append(share, "pid")
 	}
-	pod.Labels["pod"] = podSpecBase
 
 	podmanPod := iopodman.PodCreate{
-		Name:   key,
-		Labels: pod.Labels,
+		Name: key,
+		Labels: map[string]string{
+			LabelNamespace: pod.Namespace,
+			LabelName:      pod.Name,
+			LabelUID:       string(pod.UID),
+		},
+		Infra:   true,
+		Share:   share,
+		Publish: GetPodPublish(pod),
 	}
 
 	return &podmanPod, nil
 }
 
-// GetKubePod returns v1.Pod from podman pod json
-// Kuberentes spec is cached in the podman labels
-func GetKubePod(podmanJSON string) (*v1.Pod, error) {
-	var pPod PodmanPod
-	err := json.Unmarshal([]byte(podmanJSON), &pPod)
-	if err != nil {
-		return nil, err
+// PodNetwork holds addresses reported in the pod status
+type PodNetwork struct {
+	HostIP string
+	// PodIPs are addresses of the pod, primary address first
+	PodIPs []string
+}
+
+// GetPodNetwork returns addresses of the pod read from its infra container.
+// Pods using host network have the host address
+func GetPodNetwork(pod *v1.Pod, infra *PodmanContainerData, hostIP string) PodNetwork {
+	network := PodNetwork{HostIP: hostIP}
+	if pod.Spec.HostNetwork {
+		if hostIP != "" {
+			network.PodIPs = []string{hostIP}
+		}
+		return network
+	}
+	if infra == nil {
+		return network
+	}
+	for _, ip := range []string{infra.NetworkSettings.IPAddress, infra.NetworkSettings.GlobalIPv6Address} {
+		if ip != "" {
+			network.PodIPs = append(network.PodIPs, ip)
+		}
 	}
+	return network
+}
+
+// PodUID returns UID of the kubernetes pod of podman pod, empty for pods
+// created before pods were kept in the state store
+func PodUID(pPod PodmanPod) types.UID {
+	return types.UID(pPod.Config.Labels[LabelUID])
+}
 
-	data, err := base64.StdEncoding.DecodeString(pPod.Config.Labels["pod"])
+// GetLegacyKubePod returns kubernetes pod cached in podman labels by older
+// versions of the provider. It returns false if podman pod has no such label
+func GetLegacyKubePod(pPod PodmanPod) (*v1.Pod, bool, error) {
+	label, ok := pPod.Config.Labels[legacyPodLabel]
+	if !ok {
+		return nil, false, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(label)
 	if err != nil {
-		return nil, err
+		return nil, true, err
 	}
 	var kpod v1.Pod
-	err = yaml.Unmarshal(data, &kpod)
-	if err != nil {
-		return nil, err
+	if err := yaml.Unmarshal(data, &kpod); err != nil {
+		return nil, true, err
+	}
+	return &kpod, true, nil
+}
+
+// GetKubePod returns v1.Pod with status read from podman pod and its
+// containers inspect data. pod is the kubernetes pod podman pod was created
+// for. infra is inspect data of the pod infra container and may be nil.
+// waiting holds reasons of containers which could not be created, keyed by
+// podman container name
+func GetKubePod(pod *v1.Pod, pPod PodmanPod, containers []PodmanContainerData, infra *PodmanContainerData, hostIP string, waiting map[string]v1.ContainerStateWaiting) (*v1.Pod, error) {
+	kpod := pod.DeepCopy()
+	if ports := pPod.Config.InfraConfig.InfraPortBindings; len(ports) > 0 {
+		if kpod.Annotations == nil {
+			kpod.Annotations = map[string]string{}
+		}
+		kpod.Annotations[PublishedPortsAnnotation] = FormatPortMappings(ports)
+	}
+	if unhealthy := unhealthyContainers(kpod, containers); len(unhealthy) > 0 {
+		if kpod.Annotations == nil {
+			kpod.Annotations = map[string]string{}
+		}
+		kpod.Annotations[UnhealthyContainersAnnotation] = strings.Join(unhealthy, ",")
 	}
 
 	// configure status for the kubePod
-	kpod.Status, err = GetPodStatus(pPod)
+	status, err := GetPodStatus(*kpod, pPod, containers, GetPodNetwork(kpod, infra, hostIP), waiting)
 	if err != nil {
 		return nil, err
 	}
+	kpod.Status = status
 
-	return &kpod, nil
+	return kpod, nil
 }
 
 // MarshalPodPod marshals podmanPod json into PodmanPod struct
 func MarshalPodPod(podmanJSON string) (*PodmanPod, error) {
-	var pPod *PodmanPod
-	err := json.Unmarshal([]byte(podmanJSON), pPod)
+	var pPod PodmanPod
+	err := json.Unmarshal([]byte(podmanJSON), &pPod)
 	if err != nil {
 		return nil, err
 	}
-	return pPod, nil
+	return &pPod, nil
 }
 
-// GetPodStatus returns v1.PodStatus from PodmanPod spec
-func GetPodStatus(pPod PodmanPod) (v1.PodStatus, error) {
-	now := metav1.NewTime(time.Now())
+// MarshalPodmanContainer marshals podman container inspect json into
+// PodmanContainerData struct
+func MarshalPodmanContainer(containerJSON string) (*PodmanContainerData, error) {
+	var container PodmanContainerData
+	err := json.Unmarshal([]byte(containerJSON), &container)
+	if err != nil {
+		return nil, err
+	}
+	return &container, nil
+}
+
+// GetPodStatus returns v1.PodStatus from PodmanPod spec and inspect data of
+// the pod containers. Container statuses are reported for every container in
+// the kubernetes pod spec. Timestamps come from podman, so the status is
+// stable across calls
+func GetPodStatus(pod v1.Pod, pPod PodmanPod, containers []PodmanContainerData, network PodNetwork, waiting map[string]v1.ContainerStateWaiting) (v1.PodStatus, error) {
 	status := v1.PodStatus{}
-	status.StartTime = &now
-	status.HostIP = "1.2.3.4"
-	status.PodIP = "5.6.7.8"
+	startTime := metav1.NewTime(pPod.Config.Created)
+	status.StartTime = &startTime
+	status.HostIP = network.HostIP
+	// k8s.io/api in use has no PodIPs, only the primary address is reported
+	if len(network.PodIPs) > 0 {
+		status.PodIP = network.PodIPs[0]
+	}
+	status.QOSClass = GetPodQOS(&pod)
 	status.Conditions = []v1.PodCondition{
 		{
 			Type:   v1.PodInitialized,
@@ -161,52 +302,203 @@ func GetPodStatus(pPod PodmanPod) (v1.PodStatus, error) {
 			Type:   v1.PodScheduled,
 			Status: v1.ConditionTrue,
 		},
+		{
+			Type:   v1.ContainersReady,
+			Status: v1.ConditionTrue,
+		},
 	}
 
-	for _, c := range pPod.Containers {
-		containerStatus := v1.ContainerStatus{}
-		containerStatus.Name = c.ID
-		containerStatus.Image = c.ID
-		var state v1.ContainerState
-		switch c.State {
-		case "running":
-			state = v1.ContainerState{
-				Running: &v1.ContainerStateRunning{
-					StartedAt: metav1.Time{
-						Time: pPod.Config.Created,
-					},
-				},
-			}
+	key := BuildKey(&pod)
+	byName := make(map[string]PodmanContainerData, len(containers))
+	for _, c := range containers {
+		byName[c.Name] = c
+	}
+	containerStatus := func(c v1.Container) v1.ContainerStatus {
+		name := BuildContainerName(key, c.Name)
+		if d, ok := byName[name]; ok {
+			return GetContainerStatus(c, &d)
+		}
+		s := GetContainerStatus(c, nil)
+		if w, ok := waiting[name]; ok {
+			s.State.Waiting = w.DeepCopy()
+		}
+		return s
+	}
+
+	// init containers run one by one, pod is initialized only when all of
+	// them completed successfully
+	initialized, initFailed := true, false
+	for _, c := range pod.Spec.InitContainers {
+		containerStatus := containerStatus(c)
+		terminated := containerStatus.State.Terminated
+		switch {
+		case terminated != nil && terminated.ExitCode == 0:
 			containerStatus.Ready = true
-			status.Phase = v1.PodRunning
-		case "exited":
-			state = v1.ContainerState{
-				Terminated: &v1.ContainerStateTerminated{
-					StartedAt: metav1.Time{
-						Time: time.Now(),
+		case terminated != nil && initialized:
+			initFailed = true
+			if pod.Spec.RestartPolicy != v1.RestartPolicyNever {
+				containerStatus.LastTerminationState = containerStatus.State
+				containerStatus.State = v1.ContainerState{
+					Waiting: &v1.ContainerStateWaiting{
+						Reason:  "CrashLoopBackOff",
+						Message: fmt.Sprintf("init container %s exited with code %d", c.Name, terminated.ExitCode),
 					},
-				},
+				}
 			}
-			status.Phase = v1.PodFailed
-			containerStatus.Ready = false
+			fallthrough
 		default:
-			state = v1.ContainerState{
-				Terminated: &v1.ContainerStateTerminated{
-					StartedAt: metav1.Time{
-						Time: time.Now(),
+			containerStatus.Ready = false
+			initialized = false
+		}
+		status.InitContainerStatuses = append(status.InitContainerStatuses, containerStatus)
+	}
+
+	if !initialized {
+		status.Conditions[0].Status = v1.ConditionFalse
+		status.Conditions[0].Reason = "ContainersNotInitialized"
+		status.Conditions[1].Status = v1.ConditionFalse
+		status.Conditions[1].Reason = "ContainersNotInitialized"
+		status.Conditions[3].Status = v1.ConditionFalse
+		status.Conditions[3].Reason = "ContainersNotInitialized"
+		status.Phase = v1.PodPending
+		if initFailed && pod.Spec.RestartPolicy == v1.RestartPolicyNever {
+			status.Phase = v1.PodFailed
+		}
+		for _, c := range pod.Spec.Containers {
+			status.ContainerStatuses = append(status.ContainerStatuses, v1.ContainerStatus{
+				Name:  c.Name,
+				Image: c.Image,
+				State: v1.ContainerState{
+					Waiting: &v1.ContainerStateWaiting{
+						Reason: "PodInitializing",
 					},
 				},
-			}
-			status.Phase = v1.PodUnknown
-			containerStatus.Ready = false
+			})
+		}
+		return status, nil
+	}
+
+	var notReady []string
+	for _, c := range pod.Spec.Containers {
+		containerStatus := containerStatus(c)
+		if !containerStatus.Ready {
+			notReady = append(notReady, c.Name)
 		}
-		containerStatus.State = state
 		status.ContainerStatuses = append(status.ContainerStatuses, containerStatus)
 	}
+	if len(notReady) > 0 {
+		for _, i := range []int{1, 3} {
+			status.Conditions[i].Status = v1.ConditionFalse
+			status.Conditions[i].Reason = "ContainersNotReady"
+			status.Conditions[i].Message = fmt.Sprintf("containers with unready status: %v", notReady)
+		}
+	}
+	status.Phase = getPhase(&pod, status.ContainerStatuses)
 
 	return status, nil
 }
 
+// getPhase returns phase of initialized pod from its container statuses,
+// using the same rules as kubelet
+func getPhase(pod *v1.Pod, statuses []v1.ContainerStatus) v1.PodPhase {
+	var running, waiting, stopped, succeeded int
+	for _, s := range statuses {
+		switch {
+		case s.State.Running != nil:
+			running++
+		case s.State.Terminated != nil:
+			stopped++
+			if s.State.Terminated.ExitCode == 0 {
+				succeeded++
+			}
+		case s.State.Waiting != nil:
+			if s.LastTerminationState.Terminated != nil {
+				stopped++
+			} else {
+				waiting++
+			}
+		}
+	}
+
+	switch {
+	case waiting > 0:
+		return v1.PodPending
+	case running > 0:
+		return v1.PodRunning
+	case stopped > 0:
+		// containers are restarted by the provider
+		if pod.Spec.RestartPolicy == v1.RestartPolicyAlways {
+			return v1.PodRunning
+		}
+		if stopped == succeeded {
+			return v1.PodSucceeded
+		}
+		if pod.Spec.RestartPolicy == v1.RestartPolicyNever {
+			return v1.PodFailed
+		}
+		return v1.PodRunning
+	}
+	return v1.PodPending
+}
+
+// GetContainerStatus returns v1.ContainerStatus of kubernetes container from
+// podman container inspect data. Nil data means container is not created yet
+func GetContainerStatus(container v1.Container, data *PodmanContainerData) v1.ContainerStatus {
+	containerStatus := v1.ContainerStatus{
+		Name:  container.Name,
+		Image: container.Image,
+	}
+	if data == nil {
+		containerStatus.State = v1.ContainerState{
+			Waiting: &v1.ContainerStateWaiting{
+				Reason: "ContainerCreating",
+			},
+		}
+		return containerStatus
+	}
+
+	containerStatus.ImageID = data.Image
+	containerStatus.ContainerID = fmt.Sprintf("podman://%s", data.ID)
+	containerStatus.RestartCount = int32(data.RestartCount)
+	switch data.State.Status {
+	case "running", "paused":
+		containerStatus.State = v1.ContainerState{
+			Running: &v1.ContainerStateRunning{
+				StartedAt: metav1.NewTime(data.State.StartedAt),
+			},
+		}
+		// podman healthchecks run liveness probes, which don't affect
+		// readiness. Readiness probes are applied by the provider
+		containerStatus.Ready = data.State.Status == "running"
+	case "exited", "stopped":
+		reason := "Completed"
+		switch {
+		case data.State.OOMKilled:
+			reason = "OOMKilled"
+		case data.State.ExitCode != 0:
+			reason = "Error"
+		}
+		containerStatus.State = v1.ContainerState{
+			Terminated: &v1.ContainerStateTerminated{
+				ExitCode:    int32(data.State.ExitCode),
+				Reason:      reason,
+				Message:     data.State.Error,
+				StartedAt:   metav1.NewTime(data.State.StartedAt),
+				FinishedAt:  metav1.NewTime(data.State.FinishedAt),
+				ContainerID: containerStatus.ContainerID,
+			},
+		}
+	default:
+		containerStatus.State = v1.ContainerState{
+			Waiting: &v1.ContainerStateWaiting{
+				Reason: "ContainerCreating",
+			},
+		}
+	}
+
+	return containerStatus
+}
+
 // StringPtr returns pointer string
 func StringPtr(s string) *string {
 	return &s
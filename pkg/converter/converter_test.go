@@ -0,0 +1,354 @@
+package converter
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+)
+
+func newPod(containers ...v1.Container) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "default",
+			UID:       "uid-web",
+			Labels:    map[string]string{"app": "web"},
+		},
+		Spec: v1.PodSpec{
+			RestartPolicy: v1.RestartPolicyAlways,
+			Containers:    containers,
+		},
+	}
+}
+
+func runningContainer(name string) PodmanContainerData {
+	data := PodmanContainerData{ID: name + "-id", Name: name, Image: "image-id"}
+	data.State.Status = "running"
+	data.State.Running = true
+	data.State.StartedAt = time.Unix(1000, 0)
+	return data
+}
+
+func exitedContainer(name string, exitCode int) PodmanContainerData {
+	data := PodmanContainerData{ID: name + "-id", Name: name, Image: "image-id"}
+	data.State.Status = "exited"
+	data.State.ExitCode = exitCode
+	data.State.StartedAt = time.Unix(1000, 0)
+	data.State.FinishedAt = time.Unix(2000, 0)
+	return data
+}
+
+func TestKubeSpecToPodmanContainer(t *testing.T) {
+	privileged := true
+	pod := newPod(v1.Container{
+		Name:    "nginx",
+		Image:   "nginx:latest",
+		Command: []string{"nginx"},
+		Args:    []string{"-g", "daemon off;"},
+		Env:     []v1.EnvVar{{Name: "FOO", Value: "bar"}},
+		VolumeMounts: []v1.VolumeMount{
+			{Name: "host", MountPath: "/host"},
+			{Name: "cache", MountPath: "/cache", SubPath: "nginx"},
+			{Name: "config", MountPath: "/etc/nginx"},
+		},
+		SecurityContext: &v1.SecurityContext{Privileged: &privileged},
+	})
+	pod.Spec.Volumes = []v1.Volume{
+		{Name: "host", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/srv"}}},
+		{Name: "cache", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+		{Name: "config", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{}}},
+	}
+
+	create := KubeSpecToPodmanContainer(*pod, pod.Spec.Containers[0], BuildKey(pod), "/var/lib/vk")
+	assert.DeepEqual(t, create.Args, []string{"nginx:latest", "nginx", "-g", "daemon off;"})
+	assert.Equal(t, *create.Name, "default-web-nginx")
+	assert.Equal(t, *create.Pod, "default-web")
+	assert.DeepEqual(t, *create.Env, []string{"FOO=bar"})
+	assert.DeepEqual(t, *create.Volume, []string{
+		"/srv:/host",
+		"/var/lib/vk/default-web/volumes/cache/nginx:/cache",
+		"/var/lib/vk/default-web/volumes/config:/etc/nginx:ro",
+	})
+	assert.Assert(t, *create.Privileged)
+	assert.Assert(t, create.Net == nil)
+
+	pod.Spec.HostNetwork = true
+	create = KubeSpecToPodmanContainer(*pod, pod.Spec.Containers[0], BuildKey(pod), "/var/lib/vk")
+	assert.Equal(t, *create.Net, "host")
+}
+
+func TestGetPodmanPod(t *testing.T) {
+	pod := newPod(v1.Container{
+		Name:  "nginx",
+		Image: "nginx:latest",
+		Ports: []v1.ContainerPort{{ContainerPort: 80, HostPort: 8080}},
+	})
+
+	create, err := GetPodmanPod("default-web", pod)
+	assert.NilError(t, err)
+	assert.Equal(t, create.Name, "default-web")
+	assert.Assert(t, create.Infra)
+	assert.DeepEqual(t, create.Share, []string{"ipc", "uts", "net"})
+	assert.DeepEqual(t, create.Publish, []string{"8080:80/tcp"})
+	assert.DeepEqual(t, create.Labels, map[string]string{
+		LabelNamespace: "default",
+		LabelName:      "web",
+		LabelUID:       "uid-web",
+	})
+
+	pPod := PodmanPod{}
+	pPod.Config.Labels = create.Labels
+	pPod.Config.Created = time.Unix(1000, 0)
+	pPod.Config.InfraConfig.InfraPortBindings = []PortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}}
+	assert.Equal(t, PodUID(pPod), pod.UID)
+	_, legacy, err := GetLegacyKubePod(pPod)
+	assert.NilError(t, err)
+	assert.Assert(t, !legacy)
+
+	kpod, err := GetKubePod(pod, pPod, []PodmanContainerData{runningContainer("default-web-nginx")}, nil, "10.0.0.1", nil)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(pod.Annotations, 0), "the pod should not be changed")
+	assert.Equal(t, kpod.Name, "web")
+	assert.DeepEqual(t, kpod.Spec.Containers, pod.Spec.Containers)
+	assert.Equal(t, kpod.Annotations[PublishedPortsAnnotation], "0.0.0.0:8080->80/tcp")
+	assert.Equal(t, kpod.Status.Phase, v1.PodRunning)
+	assert.Equal(t, kpod.Status.HostIP, "10.0.0.1")
+	assert.Assert(t, is.Len(UnhealthyContainers(kpod), 0))
+
+	unhealthy := runningContainer("default-web-nginx")
+	unhealthy.State.Healthcheck.Status = HealthUnhealthy
+	kpod, err = GetKubePod(pod, pPod, []PodmanContainerData{unhealthy}, nil, "10.0.0.1", nil)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, UnhealthyContainers(kpod), []string{"nginx"})
+}
+
+func TestGetLegacyKubePod(t *testing.T) {
+	pod := newPod(v1.Container{Name: "nginx", Image: "nginx:latest"})
+	data, err := yaml.Marshal(pod)
+	assert.NilError(t, err)
+	pPod := PodmanPod{}
+	pPod.Config.Labels = map[string]string{"app": "web", "pod": base64.StdEncoding.EncodeToString(data)}
+	assert.Equal(t, PodUID(pPod), types.UID(""))
+
+	kpod, legacy, err := GetLegacyKubePod(pPod)
+	assert.NilError(t, err)
+	assert.Assert(t, legacy)
+	assert.DeepEqual(t, kpod, pod)
+
+	pPod.Config.Labels["pod"] = "invalid"
+	_, legacy, err = GetLegacyKubePod(pPod)
+	assert.Assert(t, legacy)
+	assert.Assert(t, err != nil)
+}
+
+func TestGetPodStatus(t *testing.T) {
+	pod := newPod(v1.Container{Name: "nginx", Image: "nginx"}, v1.Container{Name: "sidecar", Image: "busybox"})
+	pPod := PodmanPod{}
+	pPod.Config.Created = time.Unix(1000, 0)
+	network := PodNetwork{HostIP: "10.0.0.1", PodIPs: []string{"10.88.0.2"}}
+
+	status, err := GetPodStatus(*pod, pPod, []PodmanContainerData{runningContainer("default-web-nginx")}, network,
+		map[string]v1.ContainerStateWaiting{"default-web-sidecar": {Reason: "ErrImagePull"}})
+	assert.NilError(t, err)
+	assert.Equal(t, status.Phase, v1.PodPending)
+	assert.Equal(t, status.PodIP, "10.88.0.2")
+	assert.Equal(t, status.QOSClass, v1.PodQOSBestEffort)
+	assert.Assert(t, is.Len(status.ContainerStatuses, 2))
+	assert.Assert(t, status.ContainerStatuses[0].Ready)
+	assert.Equal(t, status.ContainerStatuses[0].ContainerID, "podman://default-web-nginx-id")
+	assert.Equal(t, status.ContainerStatuses[1].State.Waiting.Reason, "ErrImagePull")
+	assert.Equal(t, status.Conditions[1].Type, v1.PodReady)
+	assert.Equal(t, status.Conditions[1].Status, v1.ConditionFalse)
+	assert.Equal(t, status.Conditions[1].Reason, "ContainersNotReady")
+}
+
+func TestGetPodStatusInitContainers(t *testing.T) {
+	pod := newPod(v1.Container{Name: "nginx", Image: "nginx"})
+	pod.Spec.InitContainers = []v1.Container{{Name: "init", Image: "busybox"}}
+	pPod := PodmanPod{}
+
+	status, err := GetPodStatus(*pod, pPod, []PodmanContainerData{runningContainer("default-web-init")}, PodNetwork{}, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, status.Phase, v1.PodPending)
+	assert.Equal(t, status.Conditions[0].Status, v1.ConditionFalse)
+	assert.Equal(t, status.ContainerStatuses[0].State.Waiting.Reason, "PodInitializing")
+
+	status, err = GetPodStatus(*pod, pPod, []PodmanContainerData{exitedContainer("default-web-init", 1)}, PodNetwork{}, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, status.Phase, v1.PodPending)
+	assert.Equal(t, status.InitContainerStatuses[0].State.Waiting.Reason, "CrashLoopBackOff")
+	assert.Equal(t, status.InitContainerStatuses[0].LastTerminationState.Terminated.ExitCode, int32(1))
+
+	pod.Spec.RestartPolicy = v1.RestartPolicyNever
+	status, err = GetPodStatus(*pod, pPod, []PodmanContainerData{exitedContainer("default-web-init", 1)}, PodNetwork{}, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, status.Phase, v1.PodFailed)
+
+	status, err = GetPodStatus(*pod, pPod, []PodmanContainerData{
+		exitedContainer("default-web-init", 0),
+		runningContainer("default-web-nginx"),
+	}, PodNetwork{}, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, status.Phase, v1.PodRunning)
+	assert.Equal(t, status.Conditions[0].Status, v1.ConditionTrue)
+	assert.Assert(t, status.InitContainerStatuses[0].Ready)
+}
+
+func TestGetPhase(t *testing.T) {
+	running := v1.ContainerStatus{State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}}
+	succeeded := v1.ContainerStatus{State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{}}}
+	failed := v1.ContainerStatus{State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 1}}}
+	creating := v1.ContainerStatus{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{}}}
+	restarting := v1.ContainerStatus{
+		State:                v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 1}},
+	}
+
+	tests := []struct {
+		name     string
+		policy   v1.RestartPolicy
+		statuses []v1.ContainerStatus
+		phase    v1.PodPhase
+	}{
+		{"running", v1.RestartPolicyAlways, []v1.ContainerStatus{running, succeeded}, v1.PodRunning},
+		{"creating", v1.RestartPolicyAlways, []v1.ContainerStatus{running, creating}, v1.PodPending},
+		{"restarting", v1.RestartPolicyAlways, []v1.ContainerStatus{restarting}, v1.PodRunning},
+		{"completed always", v1.RestartPolicyAlways, []v1.ContainerStatus{succeeded}, v1.PodRunning},
+		{"completed", v1.RestartPolicyOnFailure, []v1.ContainerStatus{succeeded, succeeded}, v1.PodSucceeded},
+		{"failed on failure", v1.RestartPolicyOnFailure, []v1.ContainerStatus{succeeded, failed}, v1.PodRunning},
+		{"failed never", v1.RestartPolicyNever, []v1.ContainerStatus{succeeded, failed}, v1.PodFailed},
+		{"empty", v1.RestartPolicyAlways, nil, v1.PodPending},
+	}
+	for _, test := range tests {
+		pod := newPod()
+		pod.Spec.RestartPolicy = test.policy
+		assert.Equal(t, getPhase(pod, test.statuses), test.phase, test.name)
+	}
+}
+
+func TestGetContainerStatus(t *testing.T) {
+	c := v1.Container{Name: "nginx", Image: "nginx"}
+
+	data := exitedContainer("default-web-nginx", 137)
+	data.State.OOMKilled = true
+	status := GetContainerStatus(c, &data)
+	assert.Equal(t, status.State.Terminated.Reason, "OOMKilled")
+	assert.Equal(t, status.State.Terminated.ExitCode, int32(137))
+	assert.Assert(t, !status.Ready)
+
+	data = runningContainer("default-web-nginx")
+	data.State.Healthcheck.Status = HealthUnhealthy
+	status = GetContainerStatus(c, &data)
+	assert.Assert(t, status.State.Running != nil)
+	assert.Assert(t, status.Ready, "liveness healthcheck should not affect readiness")
+
+	data.State.Healthcheck.Status = HealthStarting
+	data.State.Healthcheck.FailingStreak = 1
+	status = GetContainerStatus(c, &data)
+	assert.Assert(t, status.Ready, "liveness healthcheck should not affect readiness")
+
+	status = GetContainerStatus(c, nil)
+	assert.Equal(t, status.State.Waiting.Reason, "ContainerCreating")
+}
+
+func TestPorts(t *testing.T) {
+	pod := newPod(v1.Container{
+		Name: "nginx",
+		Ports: []v1.ContainerPort{
+			{ContainerPort: 80, HostPort: 8080},
+			{ContainerPort: 53, HostPort: 53, HostIP: "127.0.0.1", Protocol: v1.ProtocolUDP},
+			{ContainerPort: 9090},
+		},
+	})
+	assert.DeepEqual(t, GetPodPublish(pod), []string{"8080:80/tcp", "127.0.0.1:53:53/udp"})
+
+	pod.Spec.HostNetwork = true
+	assert.Assert(t, is.Len(GetPodPublish(pod), 0))
+	assert.Assert(t, is.Len(HostPorts(pod), 3), "host network pods bind all container ports")
+
+	tcp := func(hostIP string, port int32) v1.ContainerPort {
+		return v1.ContainerPort{HostIP: hostIP, HostPort: port, Protocol: v1.ProtocolTCP}
+	}
+	assert.Assert(t, PortsConflict(tcp("", 80), tcp("10.0.0.1", 80)))
+	assert.Assert(t, PortsConflict(tcp("10.0.0.1", 80), tcp("10.0.0.1", 80)))
+	assert.Assert(t, !PortsConflict(tcp("10.0.0.1", 80), tcp("10.0.0.2", 80)))
+	assert.Assert(t, !PortsConflict(tcp("", 80), tcp("", 81)))
+	udp := tcp("", 80)
+	udp.Protocol = v1.ProtocolUDP
+	assert.Assert(t, !PortsConflict(tcp("", 80), udp))
+
+	assert.Equal(t, FormatPortMappings([]PortMapping{
+		{HostPort: 8080, ContainerPort: 80, Protocol: "TCP"},
+		{HostIP: "127.0.0.1", HostPort: 53, ContainerPort: 53, Protocol: "udp"},
+	}), "0.0.0.0:8080->80/tcp,127.0.0.1:53->53/udp")
+}
+
+func TestApplyResources(t *testing.T) {
+	resources := func(requests, limits v1.ResourceList) v1.Container {
+		return v1.Container{Name: "nginx", Resources: v1.ResourceRequirements{Requests: requests, Limits: limits}}
+	}
+	list := func(cpu, memory string) v1.ResourceList {
+		return v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse(cpu),
+			v1.ResourceMemory: resource.MustParse(memory),
+		}
+	}
+
+	// guaranteed
+	c := resources(list("500m", "128Mi"), list("500m", "128Mi"))
+	var create iopodman.Create
+	ApplyResources(&create, newPod(c), &c, 1<<30)
+	assert.Equal(t, *create.CpuShares, int64(512))
+	assert.Equal(t, *create.CpuPeriod, int64(100000))
+	assert.Equal(t, *create.CpuQuota, int64(50000))
+	assert.Equal(t, *create.Memory, "134217728")
+	assert.Equal(t, *create.MemorySwap, "134217728")
+	assert.Equal(t, *create.OomScoreAdj, int64(guaranteedOOMScoreAdj))
+
+	// burstable
+	c = resources(list("1m", "256Mi"), nil)
+	create = iopodman.Create{}
+	ApplyResources(&create, newPod(c), &c, 1<<30)
+	assert.Equal(t, *create.CpuShares, int64(minShares))
+	assert.Assert(t, create.CpuQuota == nil)
+	assert.Assert(t, create.Memory == nil)
+	assert.Equal(t, *create.OomScoreAdj, int64(750))
+
+	// best effort
+	c = resources(nil, nil)
+	create = iopodman.Create{}
+	ApplyResources(&create, newPod(c), &c, 1<<30)
+	assert.Equal(t, *create.CpuShares, int64(minShares))
+	assert.Equal(t, *create.OomScoreAdj, int64(besteffortOOMScoreAdj))
+}
+
+func TestApplyHealthcheck(t *testing.T) {
+	c := v1.Container{Name: "nginx"}
+	var create iopodman.Create
+	assert.NilError(t, ApplyHealthcheck(&create, &c))
+	assert.Assert(t, create.HealthcheckCommand == nil)
+
+	c.LivenessProbe = &v1.Probe{
+		Handler:       v1.Handler{Exec: &v1.ExecAction{Command: []string{"cat", "/tmp/healthy"}}},
+		PeriodSeconds: 5,
+	}
+	assert.NilError(t, ApplyHealthcheck(&create, &c))
+	assert.Equal(t, *create.HealthcheckCommand, `["cat","/tmp/healthy"]`)
+	assert.Equal(t, *create.HealthcheckInterval, "5s")
+	assert.Equal(t, *create.HealthcheckTimeout, "1s")
+	assert.Equal(t, *create.HealthcheckStartPeriod, "0s")
+	assert.Equal(t, *create.HealthcheckRetries, int64(3))
+
+	c.LivenessProbe = &v1.Probe{Handler: v1.Handler{HTTPGet: &v1.HTTPGetAction{Path: "/"}}}
+	assert.Assert(t, !HasNativeHealthcheck(c.LivenessProbe))
+}
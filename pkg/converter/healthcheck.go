@@ -0,0 +1,94 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+)
+
+// podman healthcheck states reported in container inspect data
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// UnhealthyContainersAnnotation lists running containers of the pod which
+// podman healthcheck reports unhealthy, comma separated
+const UnhealthyContainersAnnotation = "podman.virtual-kubelet.io/unhealthy-containers"
+
+// HasNativeHealthcheck returns true if the probe can be run by podman as a
+// native container healthcheck. Only exec probes can, as podman runs
+// healthchecks inside the container
+func HasNativeHealthcheck(probe *v1.Probe) bool {
+	return probe != nil && probe.Exec != nil && len(probe.Exec.Command) > 0
+}
+
+// ApplyHealthcheck translates exec liveness probe of the container into
+// podman healthcheck. Podman keeps running healthchecks and recording their
+// results independently of the provider
+func ApplyHealthcheck(create *iopodman.Create, container *v1.Container) error {
+	probe := container.LivenessProbe
+	if !HasNativeHealthcheck(probe) {
+		return nil
+	}
+
+	// JSON array is run by podman as exec form, same as probe command
+	command, err := json.Marshal(probe.Exec.Command)
+	if err != nil {
+		return fmt.Errorf("invalid liveness probe command: %v", err)
+	}
+	cmd := string(command)
+	interval := probeDuration(probe.PeriodSeconds, 10)
+	timeout := probeDuration(probe.TimeoutSeconds, 1)
+	startPeriod := probeDuration(probe.InitialDelaySeconds, 0)
+	retries := int64(probe.FailureThreshold)
+	if retries <= 0 {
+		retries = 3
+	}
+
+	create.HealthcheckCommand = &cmd
+	create.HealthcheckInterval = &interval
+	create.HealthcheckTimeout = &timeout
+	create.HealthcheckStartPeriod = &startPeriod
+	create.HealthcheckRetries = &retries
+	return nil
+}
+
+// UnhealthyContainers returns names of containers listed in
+// UnhealthyContainersAnnotation of the pod
+func UnhealthyContainers(pod *v1.Pod) []string {
+	value := pod.Annotations[UnhealthyContainersAnnotation]
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// unhealthyContainers returns names of running containers of the pod which
+// failed podman healthcheck
+func unhealthyContainers(pod *v1.Pod, containers []PodmanContainerData) []string {
+	key := BuildKey(pod)
+	var names []string
+	for _, c := range pod.Spec.Containers {
+		for i := range containers {
+			data := &containers[i]
+			if data.Name == BuildContainerName(key, c.Name) && data.State.Status == "running" && data.State.Healthcheck.Status == HealthUnhealthy {
+				names = append(names, c.Name)
+			}
+		}
+	}
+	return names
+}
+
+func probeDuration(seconds, defaultSeconds int32) string {
+	if seconds <= 0 {
+		seconds = defaultSeconds
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
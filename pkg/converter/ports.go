@@ -0,0 +1,86 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// PublishedPortsAnnotation lists host ports published by the pod infra
+// container in "hostIP:hostPort->containerPort/protocol" form
+const PublishedPortsAnnotation = "podman.virtual-kubelet.io/published-ports"
+
+// PortMapping is a port binding of podman infra container
+type PortMapping struct {
+	HostPort      int32  `json:"hostPort"`
+	ContainerPort int32  `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+	HostIP        string `json:"hostIP"`
+}
+
+// HostPorts returns container ports of the pod bound to host ports. Pods using
+// host network bind all their container ports on the host
+func HostPorts(pod *v1.Pod) []v1.ContainerPort {
+	var ports []v1.ContainerPort
+	for _, c := range pod.Spec.Containers {
+		for _, port := range c.Ports {
+			if port.HostPort == 0 && pod.Spec.HostNetwork {
+				port.HostPort = port.ContainerPort
+			}
+			if port.HostPort == 0 {
+				continue
+			}
+			if port.Protocol == "" {
+				port.Protocol = v1.ProtocolTCP
+			}
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// GetPodPublish returns podman publish specs of the pod host ports in
+// "[hostIP:]hostPort:containerPort/protocol" form. Ports of pods using host
+// network are not published
+func GetPodPublish(pod *v1.Pod) []string {
+	if pod.Spec.HostNetwork {
+		return nil
+	}
+	var publish []string
+	for _, port := range HostPorts(pod) {
+		spec := fmt.Sprintf("%d:%d/%s", port.HostPort, port.ContainerPort, strings.ToLower(string(port.Protocol)))
+		if port.HostIP != "" {
+			spec = port.HostIP + ":" + spec
+		}
+		publish = append(publish, spec)
+	}
+	return publish
+}
+
+// PortsConflict returns true if both host ports can't be bound at the same
+// time. Empty and unspecified addresses bind all host addresses
+func PortsConflict(a, b v1.ContainerPort) bool {
+	if a.HostPort != b.HostPort || a.Protocol != b.Protocol {
+		return false
+	}
+	return isAnyAddress(a.HostIP) || isAnyAddress(b.HostIP) || a.HostIP == b.HostIP
+}
+
+// FormatPortMappings returns port bindings in the PublishedPortsAnnotation
+// format
+func FormatPortMappings(mappings []PortMapping) string {
+	ports := make([]string, 0, len(mappings))
+	for _, m := range mappings {
+		hostIP := m.HostIP
+		if hostIP == "" {
+			hostIP = "0.0.0.0"
+		}
+		ports = append(ports, fmt.Sprintf("%s:%d->%d/%s", hostIP, m.HostPort, m.ContainerPort, strings.ToLower(m.Protocol)))
+	}
+	return strings.Join(ports, ",")
+}
+
+func isAnyAddress(ip string) bool {
+	return ip == "" || ip == "0.0.0.0" || ip == "::"
+}
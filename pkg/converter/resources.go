@@ -0,0 +1,104 @@
+package converter
+
+import (
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	v1qos "k8s.io/kubernetes/pkg/apis/core/v1/helper/qos"
+
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+)
+
+// cgroup and OOM score constants, same as kubelet uses
+const (
+	minShares     = 2
+	sharesPerCPU  = 1024
+	milliCPUToCPU = 1000
+
+	quotaPeriod    = 100000
+	minQuotaPeriod = 1000
+
+	guaranteedOOMScoreAdj = -998
+	besteffortOOMScoreAdj = 1000
+)
+
+// ApplyResources sets cgroup limits of podman container from container
+// resources the same way kubelet does for CRI runtimes. memoryCapacity is the
+// node memory in bytes, used to compute OOM score of burstable containers
+func ApplyResources(create *iopodman.Create, pod *v1.Pod, container *v1.Container, memoryCapacity int64) {
+	cpuRequest := container.Resources.Requests.Cpu()
+	cpuLimit := container.Resources.Limits.Cpu()
+
+	// API server defaults requests to limits, pods created without
+	// defaulting get the same treatment
+	milliCPU := cpuRequest.MilliValue()
+	if cpuRequest.IsZero() && !cpuLimit.IsZero() {
+		milliCPU = cpuLimit.MilliValue()
+	}
+	shares := milliCPUToShares(milliCPU)
+	create.CpuShares = &shares
+
+	if !cpuLimit.IsZero() {
+		period := int64(quotaPeriod)
+		quota := milliCPUToQuota(cpuLimit.MilliValue(), period)
+		create.CpuPeriod = &period
+		create.CpuQuota = &quota
+	}
+
+	if memoryLimit := container.Resources.Limits.Memory().Value(); memoryLimit > 0 {
+		memory := strconv.FormatInt(memoryLimit, 10)
+		create.Memory = &memory
+		// swap is disabled, same as in kubelet
+		create.MemorySwap = &memory
+	}
+
+	oomScoreAdj := containerOOMScoreAdjust(pod, container, memoryCapacity)
+	create.OomScoreAdj = &oomScoreAdj
+}
+
+// GetPodQOS returns QoS class of the pod
+func GetPodQOS(pod *v1.Pod) v1.PodQOSClass {
+	return v1qos.GetPodQOS(pod)
+}
+
+func milliCPUToShares(milliCPU int64) int64 {
+	if milliCPU == 0 {
+		return minShares
+	}
+	shares := (milliCPU * sharesPerCPU) / milliCPUToCPU
+	if shares < minShares {
+		return minShares
+	}
+	return shares
+}
+
+func milliCPUToQuota(milliCPU, period int64) int64 {
+	quota := (milliCPU * period) / milliCPUToCPU
+	if quota < minQuotaPeriod {
+		return minQuotaPeriod
+	}
+	return quota
+}
+
+// containerOOMScoreAdjust protects guaranteed containers and burstable
+// containers using less memory than requested from OOM killer
+func containerOOMScoreAdjust(pod *v1.Pod, container *v1.Container, memoryCapacity int64) int64 {
+	switch GetPodQOS(pod) {
+	case v1.PodQOSGuaranteed:
+		return guaranteedOOMScoreAdj
+	case v1.PodQOSBestEffort:
+		return besteffortOOMScoreAdj
+	}
+	if memoryCapacity <= 0 {
+		return besteffortOOMScoreAdj - 1
+	}
+
+	adjust := 1000 - (1000*container.Resources.Requests.Memory().Value())/memoryCapacity
+	if adjust < 1000+guaranteedOOMScoreAdj {
+		return 1000 + guaranteedOOMScoreAdj
+	}
+	if adjust == besteffortOOMScoreAdj {
+		return adjust - 1
+	}
+	return adjust
+}
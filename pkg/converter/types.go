@@ -10,8 +10,8 @@ type PodmanPod struct {
 		CgroupParent string            `json:"cgroupParent"`
 		SharesCgroup bool              `json:"sharesCgroup"`
 		InfraConfig  struct {
-			MakeInfraContainer bool        `json:"makeInfraContainer"`
-			InfraPortBindings  interface{} `json:"infraPortBindings"`
+			MakeInfraContainer bool          `json:"makeInfraContainer"`
+			InfraPortBindings  []PortMapping `json:"infraPortBindings"`
 		} `json:"infraConfig"`
 		Created time.Time `json:"created"`
 		LockID  int       `json:"lockID"`
@@ -26,7 +26,10 @@ type PodmanPod struct {
 	} `json:"Containers"`
 }
 
-type PodmanContainer []struct {
+type PodmanContainer []PodmanContainerData
+
+// PodmanContainerData is the inspect data of a single podman container
+type PodmanContainerData struct {
 	ID      string    `json:"Id"`
 	Created time.Time `json:"Created"`
 	Path    string    `json:"Path"`
@@ -29,15 +29,18 @@ type ResourceManager struct {
 	secretLister    corev1listers.SecretLister
 	configMapLister corev1listers.ConfigMapLister
 	serviceLister   corev1listers.ServiceLister
+	// serviceAccountLister is used to resolve image pull secrets of pods
+	serviceAccountLister corev1listers.ServiceAccountLister
 }
 
 // NewResourceManager returns a ResourceManager with the internal maps initialized.
-func NewResourceManager(podLister corev1listers.PodLister, secretLister corev1listers.SecretLister, configMapLister corev1listers.ConfigMapLister, serviceLister corev1listers.ServiceLister) (*ResourceManager, error) {
+func NewResourceManager(podLister corev1listers.PodLister, secretLister corev1listers.SecretLister, configMapLister corev1listers.ConfigMapLister, serviceLister corev1listers.ServiceLister, serviceAccountLister corev1listers.ServiceAccountLister) (*ResourceManager, error) {
 	rm := ResourceManager{
-		podLister:       podLister,
-		secretLister:    secretLister,
-		configMapLister: configMapLister,
-		serviceLister:   serviceLister,
+		podLister:            podLister,
+		secretLister:         secretLister,
+		configMapLister:      configMapLister,
+		serviceLister:        serviceLister,
+		serviceAccountLister: serviceAccountLister,
 	}
 	return &rm, nil
 }
@@ -52,6 +55,11 @@ func (rm *ResourceManager) GetPods() []*v1.Pod {
 	return make([]*v1.Pod, 0)
 }
 
+// GetPod retrieves the specified pod assigned to this virtual node from the cache.
+func (rm *ResourceManager) GetPod(name, namespace string) (*v1.Pod, error) {
+	return rm.podLister.Pods(namespace).Get(name)
+}
+
 // GetConfigMap retrieves the specified config map from the cache.
 func (rm *ResourceManager) GetConfigMap(name, namespace string) (*v1.ConfigMap, error) {
 	return rm.configMapLister.ConfigMaps(namespace).Get(name)
@@ -66,3 +74,8 @@ func (rm *ResourceManager) GetSecret(name, namespace string) (*v1.Secret, error)
 func (rm *ResourceManager) ListServices() ([]*v1.Service, error) {
 	return rm.serviceLister.List(labels.Everything())
 }
+
+// GetServiceAccount retrieves the specified service account from the cache.
+func (rm *ResourceManager) GetServiceAccount(name, namespace string) (*v1.ServiceAccount, error) {
+	return rm.serviceAccountLister.ServiceAccounts(namespace).Get(name)
+}
@@ -0,0 +1,258 @@
+package podman
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/kubernetes/pkg/credentialprovider"
+	credentialsecrets "k8s.io/kubernetes/pkg/credentialprovider/secrets"
+)
+
+const (
+	// registry of images without registry host
+	defaultRegistry = "docker.io"
+)
+
+// pullImage pulls the image using credentials from image pull secrets of the
+// pod matching the image registry. Like kubelet, every matching credential is
+// tried until the pull succeeds and anonymous pull is used when there is none
+func (p podman) pullImage(ctx context.Context, pod *corev1.Pod, image string) error {
+	keyring, err := credentialsecrets.MakeDockerKeyring(p.pullSecrets(pod), &credentialprovider.BasicDockerKeyring{})
+	if err != nil {
+		return err
+	}
+	creds, withCredentials := keyring.Lookup(image)
+	if !withCredentials {
+		return p.b.PullImage(ctx, image, nil)
+	}
+
+	var errs []error
+	for _, cred := range creds {
+		auth := cred.AuthConfig
+		if err := p.b.PullImage(ctx, image, &auth); err != nil {
+			errs = append(errs, fmt.Errorf("pull with credentials of %s failed: %v", registryHost(image), err))
+			continue
+		}
+		return nil
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// pullSecrets returns image pull secrets of the pod and of its service
+// account. Missing secrets are skipped, same as in kubelet
+func (p podman) pullSecrets(pod *corev1.Pod) []corev1.Secret {
+	if p.rm == nil {
+		return nil
+	}
+	refs := append([]corev1.LocalObjectReference{}, pod.Spec.ImagePullSecrets...)
+	serviceAccountName := pod.Spec.ServiceAccountName
+	if serviceAccountName == "" {
+		serviceAccountName = "default"
+	}
+	serviceAccount, err := p.rm.GetServiceAccount(serviceAccountName, pod.Namespace)
+	if err == nil {
+		refs = append(refs, serviceAccount.ImagePullSecrets...)
+	} else if !apierrors.IsNotFound(err) {
+		p.log.Info("couldn't get service account ", "pod ", pod.Name, " serviceAccount ", serviceAccountName, " err ", err.Error())
+	}
+
+	var secrets []corev1.Secret
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		if seen[ref.Name] {
+			continue
+		}
+		seen[ref.Name] = true
+		secret, err := p.rm.GetSecret(ref.Name, pod.Namespace)
+		if err != nil {
+			p.log.Info("couldn't get image pull secret ", "pod ", pod.Name, " secret ", ref.Name, " err ", err.Error())
+			continue
+		}
+		secrets = append(secrets, *secret)
+	}
+	return secrets
+}
+
+// authRestore records how to undo a change of registry auth file made for a
+// pull. It is kept next to the auth file while the pull runs, so the change
+// is undone when the provider restarts after a crash
+type authRestore struct {
+	Registry string `json:"registry"`
+	// Previous is the registry entry before the pull, empty if there was
+	// none
+	Previous json.RawMessage `json:"previous,omitempty"`
+	// Written is sha256 of the entry written for the pull. Entry changed
+	// during the pull, by podman login, is left alone
+	Written string `json:"written"`
+	// Created is true if the auth file didn't exist before the pull
+	Created bool `json:"created"`
+}
+
+// withAuthFile adds the credential to registry auth file read by podman for
+// the duration of fn. Podman varlink API has no way to pass credentials with
+// the pull, so only the registry entry is restored afterwards. Callers hold
+// authMu for writing, so no other pull runs while the file holds the
+// credential
+func (b *varlinkBackend) withAuthFile(registry string, cred credentialprovider.AuthConfig, fn func() error) error {
+	config, err := readAuthFile(b.authFile)
+	if err != nil {
+		return err
+	}
+	created := config == nil
+	if created {
+		config = map[string]json.RawMessage{}
+	}
+	auths, err := authEntries(config)
+	if err != nil {
+		return err
+	}
+	entry, err := json.Marshal(map[string]string{
+		"auth": base64.StdEncoding.EncodeToString([]byte(cred.Username + ":" + cred.Password)),
+	})
+	if err != nil {
+		return err
+	}
+	restore := authRestore{
+		Registry: registry,
+		Previous: auths[registry],
+		Written:  entryHash(entry),
+		Created:  created,
+	}
+	data, err := json.Marshal(restore)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.authFile), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(b.restoreFile(), data, 0600); err != nil {
+		return err
+	}
+	auths[registry] = entry
+	if err := writeAuthFile(b.authFile, config, auths); err != nil {
+		b.restoreAuthFile()
+		return err
+	}
+	defer b.restoreAuthFile()
+
+	return fn()
+}
+
+// restoreAuthFile undoes change of the auth file recorded in the restore
+// file, if there is any
+func (b *varlinkBackend) restoreAuthFile() {
+	data, err := ioutil.ReadFile(b.restoreFile())
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = b.restoreAuthEntry(data)
+	}
+	if err != nil {
+		b.log.Error("error restoring registry auth file", " file ", b.authFile, " err ", err.Error())
+		return
+	}
+	if err := os.Remove(b.restoreFile()); err != nil {
+		b.log.Error("error removing registry auth restore file", " file ", b.restoreFile(), " err ", err.Error())
+	}
+}
+
+func (b *varlinkBackend) restoreAuthEntry(data []byte) error {
+	var restore authRestore
+	if err := json.Unmarshal(data, &restore); err != nil {
+		return err
+	}
+	config, err := readAuthFile(b.authFile)
+	if err != nil || config == nil {
+		return err
+	}
+	auths, err := authEntries(config)
+	if err != nil {
+		return err
+	}
+	if entryHash(auths[restore.Registry]) != restore.Written {
+		return nil
+	}
+	if len(restore.Previous) > 0 {
+		auths[restore.Registry] = restore.Previous
+	} else {
+		delete(auths, restore.Registry)
+	}
+	if restore.Created && len(auths) == 0 && len(config) == 1 {
+		return os.Remove(b.authFile)
+	}
+	return writeAuthFile(b.authFile, config, auths)
+}
+
+func (b *varlinkBackend) restoreFile() string {
+	return b.authFile + ".restore"
+}
+
+// readAuthFile returns top level fields of the auth file, nil if the file
+// doesn't exist
+func readAuthFile(path string) (map[string]json.RawMessage, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	config := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid registry auth file %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// authEntries returns registry entries of the auth file
+func authEntries(config map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	auths := map[string]json.RawMessage{}
+	if data, ok := config["auths"]; ok {
+		if err := json.Unmarshal(data, &auths); err != nil {
+			return nil, fmt.Errorf("invalid auths of registry auth file: %v", err)
+		}
+	}
+	return auths, nil
+}
+
+func writeAuthFile(path string, config, auths map[string]json.RawMessage) error {
+	data, err := json.Marshal(auths)
+	if err != nil {
+		return err
+	}
+	config["auths"] = data
+	if data, err = json.Marshal(config); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func entryHash(entry []byte) string {
+	sum := sha256.Sum256(entry)
+	return hex.EncodeToString(sum[:])
+}
+
+// registryHost returns registry host of the image reference
+func registryHost(image string) string {
+	i := strings.Index(image, "/")
+	if i < 0 {
+		return defaultRegistry
+	}
+	host := image[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return defaultRegistry
+	}
+	return host
+}
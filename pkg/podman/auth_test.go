@@ -0,0 +1,167 @@
+package podman
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// pullSecret returns docker config secret with credentials of user for the
+// registries
+func pullSecret(name, user string, registries ...string) *corev1.Secret {
+	auths := ""
+	for i, registry := range registries {
+		if i > 0 {
+			auths += ","
+		}
+		auths += fmt.Sprintf(`%q:{"username":%q,"password":"secret"}`, registry, user)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{` + auths + `}}`),
+		},
+	}
+}
+
+func serviceAccount(name string, secrets ...string) *corev1.ServiceAccount {
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	for _, secret := range secrets {
+		sa.ImagePullSecrets = append(sa.ImagePullSecrets, corev1.LocalObjectReference{Name: secret})
+	}
+	return sa
+}
+
+func TestRegistryHost(t *testing.T) {
+	for _, tc := range []struct {
+		image string
+		want  string
+	}{
+		{image: "busybox", want: "docker.io"},
+		{image: "busybox:latest", want: "docker.io"},
+		{image: "library/busybox", want: "docker.io"},
+		{image: "myorg/app:1.0", want: "docker.io"},
+		{image: "docker.io/library/busybox", want: "docker.io"},
+		{image: "quay.io/coreos/etcd", want: "quay.io"},
+		{image: "localhost/app", want: "localhost"},
+		{image: "localhost:5000/app:1.0", want: "localhost:5000"},
+		{image: "registry:5000/team/app", want: "registry:5000"},
+		{image: "registry.example.com/team/app@sha256:1303dbf110c57f3edf68d9f5a16c082ec06c4cf7604831669faf2c712260b5a0", want: "registry.example.com"},
+	} {
+		assert.Equal(t, registryHost(tc.image), tc.want, tc.image)
+	}
+}
+
+func TestPullSecrets(t *testing.T) {
+	objs := []interface{}{
+		pullSecret("pod-creds", "pod", "registry.example.com"),
+		pullSecret("sa-creds", "sa", "registry.example.com"),
+		pullSecret("builder-creds", "builder", "registry.example.com"),
+		serviceAccount("default", "sa-creds", "missing"),
+		serviceAccount("builder", "builder-creds", "pod-creds"),
+	}
+	for _, tc := range []struct {
+		name           string
+		secrets        []string
+		serviceAccount string
+		want           []string
+	}{
+		{name: "default service account", want: []string{"sa-creds"}},
+		{name: "pod and service account", secrets: []string{"pod-creds"}, want: []string{"pod-creds", "sa-creds"}},
+		{name: "named service account", serviceAccount: "builder", want: []string{"builder-creds", "pod-creds"}},
+		{name: "duplicates", secrets: []string{"pod-creds", "pod-creds"}, serviceAccount: "builder", want: []string{"builder-creds", "pod-creds"}},
+		{name: "missing secret", secrets: []string{"missing", "pod-creds"}, want: []string{"pod-creds", "sa-creds"}},
+		{name: "missing service account", secrets: []string{"pod-creds"}, serviceAccount: "ghost", want: []string{"pod-creds"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newEnvPodman(t, objs...)
+			pod := newEnvPod()
+			pod.Spec.ServiceAccountName = tc.serviceAccount
+			for _, name := range tc.secrets {
+				pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+			}
+			var names []string
+			for _, secret := range p.pullSecrets(pod) {
+				names = append(names, secret.Name)
+			}
+			sort.Strings(names)
+			assert.DeepEqual(t, names, tc.want)
+		})
+	}
+
+	// without resource manager there are no secrets
+	assert.Assert(t, is.Len(podman{}.pullSecrets(newEnvPod()), 0))
+}
+
+func TestPullImage(t *testing.T) {
+	objs := []interface{}{
+		pullSecret("example", "example", "registry.example.com"),
+		pullSecret("example-port", "example-port", "registry.example.com:5000"),
+		pullSecret("hub", "hub", "https://index.docker.io/v1/"),
+		pullSecret("hub-2", "hub-2", "https://index.docker.io/v1/"),
+		pullSecret("hub-short", "hub-short", "docker.io"),
+		pullSecret("local", "local", "localhost:5000"),
+	}
+	for _, tc := range []struct {
+		image    string
+		secrets  []string
+		failures []string
+		pulls    []string
+		err      string
+	}{
+		{image: "registry.example.com/app", pulls: []string{""}},
+		{image: "registry.example.com/app", secrets: []string{"hub"}, pulls: []string{""}},
+		{image: "registry.example.com/app", secrets: []string{"example"}, pulls: []string{"example"}},
+		// port of the registry must match
+		{image: "registry.example.com:5000/app", secrets: []string{"example"}, pulls: []string{""}},
+		{image: "registry.example.com:5000/app", secrets: []string{"example-port"}, pulls: []string{"example-port"}},
+		{image: "localhost:5000/app:1.0", secrets: []string{"local", "example"}, pulls: []string{"local"}},
+		// docker hub credentials match images without registry host
+		{image: "busybox", secrets: []string{"hub"}, pulls: []string{"hub"}},
+		{image: "library/busybox:latest", secrets: []string{"hub"}, pulls: []string{"hub"}},
+		{image: "docker.io/library/busybox", secrets: []string{"hub"}, pulls: []string{"hub"}},
+		{image: "myorg/app", secrets: []string{"hub"}, pulls: []string{"hub"}},
+		// same as in kubelet, docker.io key doesn't match docker hub images
+		{image: "myorg/app", secrets: []string{"hub-short"}, pulls: []string{""}},
+		// every matching credential is tried in order until the pull succeeds
+		{image: "busybox", secrets: []string{"hub", "hub-2"}, failures: []string{"hub"}, pulls: []string{"hub", "hub-2"}},
+		{image: "busybox", secrets: []string{"hub", "hub-2"}, failures: []string{"hub-2"}, pulls: []string{"hub"}},
+		{image: "busybox", secrets: []string{"hub-2", "hub"}, failures: []string{"hub-2"}, pulls: []string{"hub-2", "hub"}},
+		{
+			image:    "busybox",
+			secrets:  []string{"hub", "hub-2"},
+			failures: []string{"hub", "hub-2"},
+			pulls:    []string{"hub", "hub-2"},
+			err:      "[pull with credentials of docker.io failed: unauthorized: hub, pull with credentials of docker.io failed: unauthorized: hub-2]",
+		},
+		// anonymous pull failure is returned as is
+		{image: "quay.io/app", secrets: []string{"hub"}, failures: []string{""}, pulls: []string{""}, err: "unauthorized: "},
+	} {
+		t.Run(fmt.Sprintf("%s %v", tc.image, tc.secrets), func(t *testing.T) {
+			b := &pullBackend{images: map[string]bool{}, failures: map[string]error{}}
+			for _, user := range tc.failures {
+				b.failures[user] = fmt.Errorf("unauthorized: %s", user)
+			}
+			p := newPullPodman(t, b, objs...)
+			pod := newEnvPod()
+			for _, secret := range tc.secrets {
+				pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: secret})
+			}
+
+			err := p.pullImage(context.Background(), pod, tc.image)
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+			} else {
+				assert.NilError(t, err)
+			}
+			assert.DeepEqual(t, b.pulls, tc.pulls)
+		})
+	}
+}
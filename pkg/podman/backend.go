@@ -0,0 +1,61 @@
+package podman
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	"k8s.io/kubernetes/pkg/credentialprovider"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+)
+
+// backend runs calls of podman API. Varlink API of podman 1.x and libpod REST
+// API of podman 2 and newer implement it, podman builds pod semantics on top.
+// Whatever the API, missing objects are reported as iopodman PodNotFound,
+// ContainerNotFound and ImageNotFound errors, stats of stopped containers as
+// iopodman NoContainerRunning and failed connections as transient errors
+type backend interface {
+	CreatePod(ctx context.Context, create iopodman.PodCreate) (string, error)
+	PodExists(ctx context.Context, name string) (bool, error)
+	InspectPod(ctx context.Context, name string) (*converter.PodmanPod, error)
+	ListPods(ctx context.Context) ([]string, error)
+	StopPod(ctx context.Context, name string, timeout int64) error
+	RemovePod(ctx context.Context, name string) error
+
+	CreateContainer(ctx context.Context, create iopodman.Create) error
+	ContainerExists(ctx context.Context, name string) (bool, error)
+	InspectContainer(ctx context.Context, name string) (*converter.PodmanContainerData, error)
+	// ContainerSize returns size of writable layer of the container
+	ContainerSize(ctx context.Context, name string) (int64, error)
+	StartContainer(ctx context.Context, name string) error
+	StopContainer(ctx context.Context, name string, timeout int64) error
+	// WaitContainer blocks until the container exits and returns its exit
+	// code
+	WaitContainer(ctx context.Context, name string) (int64, error)
+	ContainerStats(ctx context.Context, name string) (*iopodman.ContainerStats, error)
+	// ContainerLogs returns log stream formatted the same way as by
+	// kubelet. LimitBytes of opts is applied by the caller
+	ContainerLogs(ctx context.Context, name string, opts ContainerLogOpts) (io.ReadCloser, error)
+	// Exec runs cmd in the container. Non-zero exit code is returned as
+	// utilexec.CodeExitError
+	Exec(ctx context.Context, name string, cmd []string, attach api.AttachIO) error
+
+	ImageExists(ctx context.Context, image string) (bool, error)
+	// PullImage pulls the image, with registry credentials if auth is set
+	PullImage(ctx context.Context, image string, auth *credentialprovider.AuthConfig) error
+
+	// Events streams container and pod events to fn until ctx is done or
+	// the stream breaks
+	Events(ctx context.Context, fn func(Event)) error
+	Close() error
+}
+
+// IsRESTSocket returns true if the socket is libpod REST API socket,
+// unix:///run/podman/podman.sock. Varlink sockets have no slashes after the
+// scheme, unix:/run/podman/io.podman
+func IsRESTSocket(socket string) bool {
+	return strings.HasPrefix(socket, "unix://")
+}
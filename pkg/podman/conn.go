@@ -0,0 +1,209 @@
+package podman
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/varlink/go/varlink"
+
+	"github.com/virtual-kubelet/podman/pkg/util/errors"
+)
+
+const (
+	// back-off of retried idempotent calls, doubled on every retry
+	initialRetryBackOff = 100 * time.Millisecond
+)
+
+// lane is a bounded set of podman connections. Varlink client keeps idle
+// connections of the lane, REST backend only limits concurrent calls with it,
+// as HTTP transport keeps idle connections itself
+type lane struct {
+	name  string
+	slots chan struct{}
+	idle  chan *varlink.Connection
+}
+
+func newLane(name string, size int) *lane {
+	if size <= 0 {
+		size = 1
+	}
+	return &lane{
+		name:  name,
+		slots: make(chan struct{}, size),
+		idle:  make(chan *varlink.Connection, size),
+	}
+}
+
+// wait waits for free slot of the lane. Slot must be returned with done
+func (l *lane) wait(ctx context.Context) error {
+	start := time.Now()
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return errors.Transient(ctx.Err())
+	}
+	recordPoolWait(ctx, l.name, time.Since(start))
+	return nil
+}
+
+func (l *lane) done() {
+	<-l.slots
+}
+
+// client runs podman varlink calls over pooled connections. Short calls and
+// long running calls, such as image pulls, stops, waits and log streams, use
+// separate lanes, so long calls don't block status and stats of other pods.
+// Connections broken by failed calls are closed and dialed again on next
+// use, which also reconnects after podman service restarts
+type client struct {
+	dial    func(ctx context.Context) (*varlink.Connection, error)
+	short   *lane
+	long    *lane
+	timeout time.Duration
+	retries int
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(socket string, poolSize, longPoolSize int, timeout time.Duration, retries int) *client {
+	return &client{
+		dial: func(ctx context.Context) (*varlink.Connection, error) {
+			return varlink.NewConnection(ctx, socket)
+		},
+		short:   newLane("short", poolSize),
+		long:    newLane("long", longPoolSize),
+		timeout: timeout,
+		retries: retries,
+		closed:  make(chan struct{}),
+	}
+}
+
+// Close closes idle connections. Connections of calls in flight are closed
+// once the calls finish and new calls fail
+func (c *client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	for _, l := range []*lane{c.short, c.long} {
+		for {
+			select {
+			case conn := <-l.idle:
+				conn.Close()
+				continue
+			default:
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// Call runs short call with the per call timeout
+func (c *client) Call(ctx context.Context, fn func(context.Context, *varlink.Connection) error) error {
+	return c.do(ctx, c.short, c.timeout, fn)
+}
+
+// CallIdempotent is like Call, but retries transient failures with back-off.
+// It must only be used for calls which are safe to repeat
+func (c *client) CallIdempotent(ctx context.Context, fn func(context.Context, *varlink.Connection) error) error {
+	backOff := initialRetryBackOff
+	for attempt := 0; ; attempt++ {
+		err := c.Call(ctx, fn)
+		if !errors.IsTransient(err) || attempt >= c.retries {
+			return err
+		}
+		select {
+		case <-time.After(backOff):
+		case <-ctx.Done():
+			return err
+		}
+		backOff *= 2
+	}
+}
+
+// CallLong runs long running call in the long lane, limited only by ctx
+func (c *client) CallLong(ctx context.Context, fn func(context.Context, *varlink.Connection) error) error {
+	return c.do(ctx, c.long, 0, fn)
+}
+
+// Stream returns connection of the long lane for streaming call. Release
+// must be called once the stream is done, it closes the connection as the
+// state of the stream is unknown
+func (c *client) Stream(ctx context.Context) (*varlink.Connection, func(), error) {
+	conn, err := c.acquire(ctx, c.long)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, func() {
+		c.release(c.long, conn, true)
+	}, nil
+}
+
+func (c *client) do(ctx context.Context, l *lane, timeout time.Duration, fn func(context.Context, *varlink.Connection) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	conn, err := c.acquire(ctx, l)
+	if err != nil {
+		return err
+	}
+
+	err = fn(ctx, conn)
+	if err == nil || errors.IsReply(err) {
+		c.release(l, conn, false)
+		return err
+	}
+	// connection failed or the call was interrupted in the middle of the
+	// reply, the connection can't be reused
+	c.release(l, conn, true)
+	return errors.Transient(err)
+}
+
+// acquire waits for free slot of the lane and returns idle connection or
+// dials new one
+func (c *client) acquire(ctx context.Context, l *lane) (*varlink.Connection, error) {
+	select {
+	case <-c.closed:
+		return nil, fmt.Errorf("podman client is closed")
+	default:
+	}
+	if err := l.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	select {
+	case conn := <-l.idle:
+		return conn, nil
+	default:
+	}
+	conn, err := c.dial(ctx)
+	if err != nil {
+		l.done()
+		return nil, errors.Transient(err)
+	}
+	return conn, nil
+}
+
+// release returns connection to the lane. Broken connections are closed
+func (c *client) release(l *lane, conn *varlink.Connection, broken bool) {
+	select {
+	case <-c.closed:
+		broken = true
+	default:
+	}
+	if broken {
+		conn.Close()
+	} else {
+		select {
+		case l.idle <- conn:
+		default:
+			conn.Close()
+		}
+	}
+	l.done()
+}
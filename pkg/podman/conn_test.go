@@ -0,0 +1,125 @@
+package podman
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/varlink/go/varlink"
+	"gotest.tools/assert"
+
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+	"github.com/virtual-kubelet/podman/pkg/podman/podmantest"
+	"github.com/virtual-kubelet/podman/pkg/util/errors"
+)
+
+// newTestClient returns varlink client of the fake server with lanes of one
+// slot, counting dialed connections
+func newTestClient(t *testing.T, timeout time.Duration, retries int) (*client, *podmantest.Server, *int32, func()) {
+	t.Helper()
+	server, err := podmantest.NewServer()
+	assert.NilError(t, err)
+	c := newClient(server.Socket, 1, 1, timeout, retries)
+	var dials int32
+	dial := c.dial
+	c.dial = func(ctx context.Context) (*varlink.Connection, error) {
+		atomic.AddInt32(&dials, 1)
+		return dial(ctx)
+	}
+	return c, server, &dials, func() {
+		c.Close()
+		server.Close()
+	}
+}
+
+func getPod(ctx context.Context, conn *varlink.Connection) error {
+	_, err := iopodman.GetPod().Call(ctx, conn, "web")
+	return err
+}
+
+func TestClientReusesConnections(t *testing.T) {
+	c, server, dials, cleanup := newTestClient(t, time.Second, 0)
+	defer cleanup()
+	ctx := context.Background()
+
+	// podman errors leave the connection usable
+	for i := 0; i < 3; i++ {
+		err := c.Call(ctx, getPod)
+		_, ok := err.(*iopodman.PodNotFound)
+		assert.Assert(t, ok, "expected pod not found, got %v", err)
+	}
+	assert.Equal(t, atomic.LoadInt32(dials), int32(1))
+
+	// broken connection is closed and dialed again
+	server.InjectFault("GetPod", podmantest.Fault{Drop: true, Times: 1})
+	err := c.Call(ctx, getPod)
+	assert.Assert(t, errors.IsTransient(err), "expected transient error, got %v", err)
+	err = c.Call(ctx, getPod)
+	_, ok := err.(*iopodman.PodNotFound)
+	assert.Assert(t, ok, "expected pod not found, got %v", err)
+	assert.Equal(t, atomic.LoadInt32(dials), int32(2))
+
+	// lanes keep their own connections
+	assert.Assert(t, c.CallLong(ctx, getPod) != nil)
+	assert.Equal(t, atomic.LoadInt32(dials), int32(3))
+
+	// the client fails calls once closed
+	c.Close()
+	assert.ErrorContains(t, c.Call(ctx, getPod), "podman client is closed")
+}
+
+func TestClientTimeouts(t *testing.T) {
+	c, server, dials, cleanup := newTestClient(t, 100*time.Millisecond, 0)
+	defer cleanup()
+	ctx := context.Background()
+
+	// short calls are limited by the call timeout, the interrupted
+	// connection is not reused
+	server.InjectFault("GetPod", podmantest.Fault{Delay: time.Second, Times: 1})
+	start := time.Now()
+	err := c.Call(ctx, getPod)
+	assert.Assert(t, errors.IsTransient(err), "expected transient error, got %v", err)
+	assert.Assert(t, time.Since(start) < 500*time.Millisecond)
+	assert.Assert(t, c.Call(ctx, getPod) != nil)
+	assert.Equal(t, atomic.LoadInt32(dials), int32(2))
+
+	// long calls are limited by ctx only
+	server.InjectFault("GetPod", podmantest.Fault{Delay: 300 * time.Millisecond, Times: 1})
+	err = c.CallLong(ctx, getPod)
+	_, ok := err.(*iopodman.PodNotFound)
+	assert.Assert(t, ok, "expected pod not found, got %v", err)
+
+	// calls waiting for busy lane fail once ctx is done
+	held := make(chan struct{})
+	release := make(chan struct{})
+	go c.CallLong(ctx, func(context.Context, *varlink.Connection) error { //nolint:errcheck
+		close(held)
+		<-release
+		return nil
+	})
+	<-held
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err = c.CallLong(waitCtx, getPod)
+	assert.Assert(t, errors.IsTransient(err), "expected transient error, got %v", err)
+	close(release)
+}
+
+func TestClientRetries(t *testing.T) {
+	c, server, _, cleanup := newTestClient(t, time.Second, 2)
+	defer cleanup()
+	ctx := context.Background()
+
+	server.InjectFault("GetPod", podmantest.Fault{Drop: true, Times: 2})
+	err := c.CallIdempotent(ctx, getPod)
+	_, ok := err.(*iopodman.PodNotFound)
+	assert.Assert(t, ok, "expected pod not found, got %v", err)
+	assert.Equal(t, server.Calls("GetPod"), 3)
+
+	// retries are limited
+	server.InjectFault("GetPod", podmantest.Fault{Drop: true, Times: 3})
+	err = c.CallIdempotent(ctx, getPod)
+	assert.Assert(t, errors.IsTransient(err), "expected transient error, got %v", err)
+	assert.Equal(t, server.Calls("GetPod"), 6)
+}
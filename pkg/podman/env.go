@@ -0,0 +1,311 @@
+package podman
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/kubernetes/pkg/api/v1/resource"
+	podshelper "k8s.io/kubernetes/pkg/apis/core/pods"
+	v1helper "k8s.io/kubernetes/pkg/apis/core/v1/helper"
+	"k8s.io/kubernetes/pkg/fieldpath"
+	"k8s.io/kubernetes/pkg/kubelet/envvars"
+	"k8s.io/kubernetes/third_party/forked/golang/expansion"
+)
+
+const (
+	// services exposed to pods in every namespace, same as in kubelet
+	masterServiceNamespace = "default"
+	masterServiceName      = "kubernetes"
+)
+
+// resolveContainer returns copy of the container with environment resolved
+// to literal values and $(VAR) references in command and args expanded.
+// Env is resolved from the pod stored in the API server when it is available,
+// as virtual-kubelet flattens env before CreatePod and drops references it
+// can't resolve, such as status.podIP and resourceFieldRef
+func (p podman) resolveContainer(pod *corev1.Pod, container corev1.Container, podIP string) (corev1.Container, error) {
+	source := pod
+	if p.rm != nil {
+		if apiPod, err := p.rm.GetPod(pod.Name, pod.Namespace); err == nil {
+			source = apiPod
+		}
+	}
+	spec := container
+	for _, c := range append(append([]corev1.Container{}, source.Spec.InitContainers...), source.Spec.Containers...) {
+		if c.Name == container.Name {
+			spec = c
+			break
+		}
+	}
+
+	// runtime values of downward API fields
+	source = source.DeepCopy()
+	source.Status.PodIP = podIP
+	source.Status.HostIP = p.hostIP
+
+	env, err := p.makeEnvironment(source, &spec)
+	if err != nil {
+		return container, err
+	}
+
+	resolved := *container.DeepCopy()
+	resolved.Env = env
+	resolved.EnvFrom = nil
+
+	values := make(map[string]string, len(env))
+	for _, e := range env {
+		values[e.Name] = e.Value
+	}
+	mapping := expansion.MappingFuncFor(values)
+	resolved.Command = expand(spec.Command, mapping)
+	resolved.Args = expand(spec.Args, mapping)
+	return resolved, nil
+}
+
+// makeEnvironment resolves envFrom, env and service links of the container
+// following kubelet precedence: env overrides envFrom, service variables are
+// added only when not defined by the container
+func (p podman) makeEnvironment(pod *corev1.Pod, container *corev1.Container) ([]corev1.EnvVar, error) {
+	var (
+		names  []string
+		values = map[string]string{}
+	)
+	set := func(name, value string) {
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+		values[name] = value
+	}
+
+	serviceEnv, err := p.serviceEnv(pod)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, envFrom := range container.EnvFrom {
+		data, err := p.envFromData(pod, envFrom)
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			name := envFrom.Prefix + key
+			if errs := validation.IsEnvVarName(name); len(errs) != 0 {
+				p.log.Info("skipping invalid environment variable name ", "pod ", pod.Name, " name ", name)
+				continue
+			}
+			set(name, data[key])
+		}
+	}
+
+	mapping := expansion.MappingFuncFor(values, serviceEnv)
+	for _, env := range container.Env {
+		if env.ValueFrom == nil {
+			set(env.Name, expansion.Expand(env.Value, mapping))
+			continue
+		}
+		value, ok, err := p.envValueFrom(pod, container, env)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			set(env.Name, value)
+		}
+	}
+
+	serviceNames := make([]string, 0, len(serviceEnv))
+	for name := range serviceEnv {
+		serviceNames = append(serviceNames, name)
+	}
+	sort.Strings(serviceNames)
+	for _, name := range serviceNames {
+		if _, ok := values[name]; !ok {
+			set(name, serviceEnv[name])
+		}
+	}
+
+	result := make([]corev1.EnvVar, 0, len(names))
+	for _, name := range names {
+		result = append(result, corev1.EnvVar{Name: name, Value: values[name]})
+	}
+	return result, nil
+}
+
+// envValueFrom resolves env valueFrom reference. Missing optional keys are
+// reported with ok set to false
+func (p podman) envValueFrom(pod *corev1.Pod, container *corev1.Container, env corev1.EnvVar) (value string, ok bool, err error) {
+	from := env.ValueFrom
+	switch {
+	case from.SecretKeyRef != nil:
+		ref := from.SecretKeyRef
+		optional := ref.Optional != nil && *ref.Optional
+		if p.rm == nil {
+			return "", false, fmt.Errorf("couldn't get secret %s/%s: no resource manager", pod.Namespace, ref.Name)
+		}
+		secret, err := p.rm.GetSecret(ref.Name, pod.Namespace)
+		if err != nil {
+			if apierrors.IsNotFound(err) && optional {
+				return "", false, nil
+			}
+			return "", false, fmt.Errorf("couldn't get secret %s/%s: %v", pod.Namespace, ref.Name, err)
+		}
+		data, found := secret.Data[ref.Key]
+		if !found {
+			if optional {
+				return "", false, nil
+			}
+			return "", false, fmt.Errorf("couldn't find key %s in secret %s/%s", ref.Key, pod.Namespace, ref.Name)
+		}
+		return string(data), true, nil
+	case from.ConfigMapKeyRef != nil:
+		ref := from.ConfigMapKeyRef
+		optional := ref.Optional != nil && *ref.Optional
+		if p.rm == nil {
+			return "", false, fmt.Errorf("couldn't get configMap %s/%s: no resource manager", pod.Namespace, ref.Name)
+		}
+		configMap, err := p.rm.GetConfigMap(ref.Name, pod.Namespace)
+		if err != nil {
+			if apierrors.IsNotFound(err) && optional {
+				return "", false, nil
+			}
+			return "", false, fmt.Errorf("couldn't get configMap %s/%s: %v", pod.Namespace, ref.Name, err)
+		}
+		data, found := configMap.Data[ref.Key]
+		if !found {
+			if optional {
+				return "", false, nil
+			}
+			return "", false, fmt.Errorf("couldn't find key %s in configMap %s/%s", ref.Key, pod.Namespace, ref.Name)
+		}
+		return data, true, nil
+	case from.FieldRef != nil:
+		value, err := podFieldValue(pod, from.FieldRef)
+		return value, err == nil, err
+	case from.ResourceFieldRef != nil:
+		containerName := from.ResourceFieldRef.ContainerName
+		if containerName == "" {
+			containerName = container.Name
+		}
+		value, err := resource.ExtractResourceValueByContainerNameAndNodeAllocatable(from.ResourceFieldRef, pod, containerName, p.allocatable)
+		return value, err == nil, err
+	}
+	return "", false, nil
+}
+
+// envFromData returns content of configMap or secret referenced by envFrom
+func (p podman) envFromData(pod *corev1.Pod, envFrom corev1.EnvFromSource) (map[string]string, error) {
+	data := map[string]string{}
+	switch {
+	case envFrom.ConfigMapRef != nil:
+		ref := envFrom.ConfigMapRef
+		optional := ref.Optional != nil && *ref.Optional
+		if p.rm == nil {
+			return nil, fmt.Errorf("couldn't get configMap %s/%s: no resource manager", pod.Namespace, ref.Name)
+		}
+		configMap, err := p.rm.GetConfigMap(ref.Name, pod.Namespace)
+		if err != nil {
+			if apierrors.IsNotFound(err) && optional {
+				return data, nil
+			}
+			return nil, fmt.Errorf("couldn't get configMap %s/%s: %v", pod.Namespace, ref.Name, err)
+		}
+		for key, value := range configMap.Data {
+			data[key] = value
+		}
+	case envFrom.SecretRef != nil:
+		ref := envFrom.SecretRef
+		optional := ref.Optional != nil && *ref.Optional
+		if p.rm == nil {
+			return nil, fmt.Errorf("couldn't get secret %s/%s: no resource manager", pod.Namespace, ref.Name)
+		}
+		secret, err := p.rm.GetSecret(ref.Name, pod.Namespace)
+		if err != nil {
+			if apierrors.IsNotFound(err) && optional {
+				return data, nil
+			}
+			return nil, fmt.Errorf("couldn't get secret %s/%s: %v", pod.Namespace, ref.Name, err)
+		}
+		for key, value := range secret.Data {
+			data[key] = string(value)
+		}
+	}
+	return data, nil
+}
+
+// serviceEnv returns docker link style variables of the services visible to
+// the pod
+func (p podman) serviceEnv(pod *corev1.Pod) (map[string]string, error) {
+	env := map[string]string{}
+	if p.rm == nil {
+		return env, nil
+	}
+	services, err := p.rm.ListServices()
+	if err != nil {
+		return nil, err
+	}
+
+	enableServiceLinks := pod.Spec.EnableServiceLinks == nil || *pod.Spec.EnableServiceLinks
+	visible := map[string]*corev1.Service{}
+	for _, service := range services {
+		if !v1helper.IsServiceIPSet(service) {
+			continue
+		}
+		if service.Namespace == masterServiceNamespace && service.Name == masterServiceName {
+			if _, exists := visible[service.Name]; !exists {
+				visible[service.Name] = service
+			}
+		} else if service.Namespace == pod.Namespace && enableServiceLinks {
+			visible[service.Name] = service
+		}
+	}
+
+	list := make([]*corev1.Service, 0, len(visible))
+	for _, service := range visible {
+		list = append(list, service)
+	}
+	for _, e := range envvars.FromServices(list) {
+		env[e.Name] = e.Value
+	}
+	return env, nil
+}
+
+// podFieldValue returns runtime value of the downward API field
+func podFieldValue(pod *corev1.Pod, fs *corev1.ObjectFieldSelector) (string, error) {
+	apiVersion := fs.APIVersion
+	if apiVersion == "" {
+		apiVersion = "v1"
+	}
+	path, _, err := podshelper.ConvertDownwardAPIFieldLabel(apiVersion, fs.FieldPath, "")
+	if err != nil {
+		return "", err
+	}
+	switch path {
+	case "spec.nodeName":
+		return pod.Spec.NodeName, nil
+	case "spec.serviceAccountName":
+		return pod.Spec.ServiceAccountName, nil
+	case "status.hostIP":
+		return pod.Status.HostIP, nil
+	case "status.podIP":
+		return pod.Status.PodIP, nil
+	}
+	return fieldpath.ExtractFieldPathAsString(pod, path)
+}
+
+func expand(in []string, mapping func(string) string) []string {
+	if in == nil {
+		return nil
+	}
+	out := make([]string, len(in))
+	for i, s := range in {
+		out[i] = expansion.Expand(s, mapping)
+	}
+	return out
+}
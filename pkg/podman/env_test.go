@@ -0,0 +1,414 @@
+package podman
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/virtual-kubelet/podman/pkg/manager"
+)
+
+// newEnvPodman returns podman client resolving env from objs, without
+// connection to podman
+func newEnvPodman(t *testing.T, objs ...interface{}) podman {
+	t.Helper()
+	newIndexer := func() cache.Indexer {
+		return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	}
+	pods, secrets, configMaps, services, serviceAccounts := newIndexer(), newIndexer(), newIndexer(), newIndexer(), newIndexer()
+	for _, obj := range objs {
+		var err error
+		switch obj.(type) {
+		case *corev1.Pod:
+			err = pods.Add(obj)
+		case *corev1.Secret:
+			err = secrets.Add(obj)
+		case *corev1.ConfigMap:
+			err = configMaps.Add(obj)
+		case *corev1.Service:
+			err = services.Add(obj)
+		case *corev1.ServiceAccount:
+			err = serviceAccounts.Add(obj)
+		}
+		assert.NilError(t, err)
+	}
+	rm, err := manager.NewResourceManager(
+		corev1listers.NewPodLister(pods),
+		corev1listers.NewSecretLister(secrets),
+		corev1listers.NewConfigMapLister(configMaps),
+		corev1listers.NewServiceLister(services),
+		corev1listers.NewServiceAccountLister(serviceAccounts),
+	)
+	assert.NilError(t, err)
+	return podman{
+		rm: rm,
+		allocatable: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("2"),
+			corev1.ResourceMemory: resource.MustParse("1Gi"),
+		},
+		hostIP: "192.168.1.10",
+		log:    zap.NewNop().Sugar(),
+	}
+}
+
+var (
+	envSecret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default"},
+		Data: map[string][]byte{
+			"user":     []byte("admin"),
+			"password": []byte("s3cret"),
+		},
+	}
+	envConfigMap = &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "default"},
+		Data: map[string]string{
+			"LOG_LEVEL":  "debug",
+			"log-format": "json",
+			"1PORT":      "80",
+		},
+	}
+)
+
+func newEnvPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			UID:         "uid-web",
+			Labels:      map[string]string{"app": "web"},
+			Annotations: map[string]string{"team": "core"},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:           "node",
+			ServiceAccountName: "builder",
+			Containers: []corev1.Container{
+				{
+					Name: "app",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m")},
+						Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+					},
+				},
+				{Name: "sidecar"},
+			},
+		},
+		Status: corev1.PodStatus{
+			PodIP:  "10.88.0.5",
+			HostIP: "192.168.1.10",
+		},
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestEnvValueFrom(t *testing.T) {
+	p := newEnvPodman(t, envSecret, envConfigMap)
+	pod := newEnvPod()
+
+	secretRef := func(name, key string, optional bool) *corev1.EnvVarSource {
+		return &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			Key:                  key,
+			Optional:             boolPtr(optional),
+		}}
+	}
+	configMapRef := func(name, key string, optional bool) *corev1.EnvVarSource {
+		return &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			Key:                  key,
+			Optional:             boolPtr(optional),
+		}}
+	}
+	fieldRef := func(path string) *corev1.EnvVarSource {
+		return &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: path}}
+	}
+	resourceRef := func(container, name, divisor string) *corev1.EnvVarSource {
+		ref := &corev1.ResourceFieldSelector{ContainerName: container, Resource: name}
+		if divisor != "" {
+			ref.Divisor = resource.MustParse(divisor)
+		}
+		return &corev1.EnvVarSource{ResourceFieldRef: ref}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		from  *corev1.EnvVarSource
+		value string
+		ok    bool
+		err   bool
+	}{
+		{name: "secret key", from: secretRef("creds", "user", false), value: "admin", ok: true},
+		{name: "missing secret key", from: secretRef("creds", "token", false), err: true},
+		{name: "optional missing secret key", from: secretRef("creds", "token", true)},
+		{name: "missing secret", from: secretRef("other", "user", false), err: true},
+		{name: "optional missing secret", from: secretRef("other", "user", true)},
+		{name: "configMap key", from: configMapRef("config", "LOG_LEVEL", false), value: "debug", ok: true},
+		{name: "missing configMap key", from: configMapRef("config", "LEVEL", false), err: true},
+		{name: "optional missing configMap key", from: configMapRef("config", "LEVEL", true)},
+		{name: "missing configMap", from: configMapRef("other", "LOG_LEVEL", false), err: true},
+		{name: "optional missing configMap", from: configMapRef("other", "LOG_LEVEL", true)},
+		{name: "pod name", from: fieldRef("metadata.name"), value: "web", ok: true},
+		{name: "pod label", from: fieldRef("metadata.labels['app']"), value: "web", ok: true},
+		{name: "pod annotation", from: fieldRef("metadata.annotations['team']"), value: "core", ok: true},
+		{name: "node name", from: fieldRef("spec.nodeName"), value: "node", ok: true},
+		{name: "service account", from: fieldRef("spec.serviceAccountName"), value: "builder", ok: true},
+		{name: "pod IP", from: fieldRef("status.podIP"), value: "10.88.0.5", ok: true},
+		{name: "host IP", from: fieldRef("status.hostIP"), value: "192.168.1.10", ok: true},
+		{name: "unknown field", from: fieldRef("spec.hostname"), err: true},
+		{name: "own request", from: resourceRef("", "requests.cpu", "1m"), value: "250", ok: true},
+		{name: "own limit", from: resourceRef("", "limits.memory", "1Mi"), value: "64", ok: true},
+		{name: "allocatable for missing limit", from: resourceRef("", "limits.cpu", ""), value: "2", ok: true},
+		{name: "other container", from: resourceRef("sidecar", "limits.memory", "1Mi"), value: "1024", ok: true},
+		{name: "unknown container", from: resourceRef("missing", "limits.memory", ""), err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			value, ok, err := p.envValueFrom(pod, &pod.Spec.Containers[0], corev1.EnvVar{Name: "VAR", ValueFrom: tc.from})
+			if tc.err {
+				assert.Assert(t, err != nil)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, ok, tc.ok)
+			assert.Equal(t, value, tc.value)
+		})
+	}
+}
+
+func TestEnvFromData(t *testing.T) {
+	p := newEnvPodman(t, envSecret, envConfigMap)
+	pod := newEnvPod()
+
+	for _, tc := range []struct {
+		name    string
+		envFrom corev1.EnvFromSource
+		data    map[string]string
+		err     bool
+	}{
+		{
+			name:    "configMap",
+			envFrom: corev1.EnvFromSource{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}}},
+			data:    envConfigMap.Data,
+		},
+		{
+			name:    "secret",
+			envFrom: corev1.EnvFromSource{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "creds"}}},
+			data:    map[string]string{"user": "admin", "password": "s3cret"},
+		},
+		{
+			name:    "missing configMap",
+			envFrom: corev1.EnvFromSource{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "other"}}},
+			err:     true,
+		},
+		{
+			name:    "optional missing configMap",
+			envFrom: corev1.EnvFromSource{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "other"}, Optional: boolPtr(true)}},
+			data:    map[string]string{},
+		},
+		{
+			name:    "missing secret",
+			envFrom: corev1.EnvFromSource{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "other"}}},
+			err:     true,
+		},
+		{
+			name:    "optional missing secret",
+			envFrom: corev1.EnvFromSource{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "other"}, Optional: boolPtr(true)}},
+			data:    map[string]string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := p.envFromData(pod, tc.envFrom)
+			if tc.err {
+				assert.Assert(t, err != nil)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, data, tc.data)
+		})
+	}
+}
+
+func TestPodFieldValue(t *testing.T) {
+	pod := newEnvPod()
+	for _, tc := range []struct {
+		fieldRef corev1.ObjectFieldSelector
+		value    string
+		err      bool
+	}{
+		{fieldRef: corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}, value: "default"},
+		{fieldRef: corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.uid"}, value: "uid-web"},
+		{fieldRef: corev1.ObjectFieldSelector{FieldPath: "metadata.labels"}, value: `app="web"`},
+		{fieldRef: corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}, value: "node"},
+		{fieldRef: corev1.ObjectFieldSelector{FieldPath: "status.podIP"}, value: "10.88.0.5"},
+		{fieldRef: corev1.ObjectFieldSelector{APIVersion: "v2", FieldPath: "metadata.name"}, err: true},
+		{fieldRef: corev1.ObjectFieldSelector{FieldPath: "spec.containers"}, err: true},
+	} {
+		value, err := podFieldValue(pod, &tc.fieldRef)
+		if tc.err {
+			assert.Assert(t, err != nil, tc.fieldRef.FieldPath)
+			continue
+		}
+		assert.NilError(t, err, tc.fieldRef.FieldPath)
+		assert.Equal(t, value, tc.value, tc.fieldRef.FieldPath)
+	}
+}
+
+func TestMakeEnvironment(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: "10.0.0.20",
+			Ports:     []corev1.ServicePort{{Name: "pg", Port: 5432, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	p := newEnvPodman(t, envSecret, envConfigMap, service)
+
+	for _, tc := range []struct {
+		name      string
+		container corev1.Container
+		env       []corev1.EnvVar
+		err       bool
+	}{
+		{
+			name: "envFrom with prefix skips invalid keys",
+			container: corev1.Container{
+				Name: "app",
+				EnvFrom: []corev1.EnvFromSource{
+					{Prefix: "CFG_", ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}}},
+					{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}}},
+				},
+			},
+			env: []corev1.EnvVar{
+				{Name: "CFG_1PORT", Value: "80"},
+				{Name: "CFG_LOG_LEVEL", Value: "debug"},
+				{Name: "CFG_log-format", Value: "json"},
+				{Name: "LOG_LEVEL", Value: "debug"},
+				{Name: "log-format", Value: "json"},
+			},
+		},
+		{
+			name: "env overrides envFrom and services",
+			container: corev1.Container{
+				Name: "app",
+				EnvFrom: []corev1.EnvFromSource{
+					{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "creds"}}},
+				},
+				Env: []corev1.EnvVar{
+					{Name: "user", Value: "root"},
+					{Name: "DB_SERVICE_HOST", Value: "db.local"},
+				},
+			},
+			env: []corev1.EnvVar{
+				{Name: "password", Value: "s3cret"},
+				{Name: "user", Value: "root"},
+				{Name: "DB_SERVICE_HOST", Value: "db.local"},
+			},
+		},
+		{
+			name: "expansion of earlier variables",
+			container: corev1.Container{
+				Name: "app",
+				Env: []corev1.EnvVar{
+					{Name: "USER", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "creds"},
+						Key:                  "user",
+					}}},
+					{Name: "POD_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"}}},
+					{Name: "URL", Value: "http://$(USER)@$(POD_IP):$(DB_SERVICE_PORT)/$(LATER)"},
+					{Name: "ESCAPED", Value: "$$(USER)"},
+					{Name: "LATER", Value: "db"},
+				},
+			},
+			env: []corev1.EnvVar{
+				{Name: "USER", Value: "admin"},
+				{Name: "POD_IP", Value: "10.88.0.5"},
+				{Name: "URL", Value: "http://admin@10.88.0.5:5432/$(LATER)"},
+				{Name: "ESCAPED", Value: "$(USER)"},
+				{Name: "LATER", Value: "db"},
+			},
+		},
+		{
+			name: "optional missing key is not set",
+			container: corev1.Container{
+				Name: "app",
+				Env: []corev1.EnvVar{
+					{Name: "TOKEN", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "creds"},
+						Key:                  "token",
+						Optional:             boolPtr(true),
+					}}},
+				},
+			},
+			env: []corev1.EnvVar{},
+		},
+		{
+			name: "missing key fails",
+			container: corev1.Container{
+				Name: "app",
+				Env: []corev1.EnvVar{
+					{Name: "TOKEN", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "creds"},
+						Key:                  "token",
+					}}},
+				},
+			},
+			err: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := newEnvPod()
+			env, err := p.makeEnvironment(pod, &tc.container)
+			if tc.err {
+				assert.Assert(t, err != nil)
+				return
+			}
+			assert.NilError(t, err)
+			// service variables follow the container ones
+			serviceEnv, err := p.serviceEnv(pod)
+			assert.NilError(t, err)
+			assert.Assert(t, len(env) >= len(tc.env))
+			assert.DeepEqual(t, env[:len(tc.env)], tc.env)
+			for _, e := range env[len(tc.env):] {
+				assert.Equal(t, e.Value, serviceEnv[e.Name], e.Name)
+			}
+			assert.Equal(t, len(env)-len(tc.env), len(serviceEnv)-overridden(tc.env, serviceEnv))
+		})
+	}
+}
+
+func TestResolveContainer(t *testing.T) {
+	pod := newEnvPod()
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{
+		{Name: "POD_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"}}},
+	}
+	pod.Spec.Containers[0].Command = []string{"serve", "--listen=$(POD_IP):80"}
+	pod.Spec.Containers[0].Args = []string{"$(MISSING)", "$$(POD_IP)"}
+	// virtual-kubelet passes container with env it couldn't resolve dropped
+	flattened := pod.Spec.Containers[0]
+	flattened.Env = nil
+	p := newEnvPodman(t, pod)
+
+	resolved, err := p.resolveContainer(pod, flattened, "10.88.0.7")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, resolved.Env[:1], []corev1.EnvVar{{Name: "POD_IP", Value: "10.88.0.7"}})
+	assert.DeepEqual(t, resolved.Command, []string{"serve", "--listen=10.88.0.7:80"})
+	assert.DeepEqual(t, resolved.Args, []string{"$(MISSING)", "$(POD_IP)"})
+}
+
+// overridden counts service variables set by env
+func overridden(env []corev1.EnvVar, serviceEnv map[string]string) int {
+	count := 0
+	for _, e := range env {
+		if _, ok := serviceEnv[e.Name]; ok {
+			count++
+		}
+	}
+	return count
+}
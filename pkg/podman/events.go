@@ -0,0 +1,80 @@
+package podman
+
+import (
+	"context"
+
+	"github.com/varlink/go/varlink"
+
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+	"github.com/virtual-kubelet/podman/pkg/util/errors"
+)
+
+// podman event types and statuses the provider reacts to
+const (
+	EventTypeContainer = "container"
+	EventTypePod       = "pod"
+
+	EventStart        = "start"
+	EventDied         = "died"
+	EventOOM          = "oom"
+	EventHealthStatus = "health_status"
+	EventRemove       = "remove"
+)
+
+// Event is podman container or pod event. Name is the container or pod name
+type Event struct {
+	Type   string
+	Status string
+	Name   string
+}
+
+// WatchEvents streams podman events to fn until ctx is done or the stream
+// breaks
+func (p podman) WatchEvents(ctx context.Context, fn func(Event)) error {
+	return p.b.Events(ctx, fn)
+}
+
+// Events uses dedicated connection as the call blocks for as long as events
+// are watched
+func (b *varlinkBackend) Events(ctx context.Context, fn func(Event)) error {
+	conn, err := b.c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// filters of podman events are all required to match, so events are
+	// filtered by the caller
+	receive, err := iopodman.GetEvents().Send(ctx, conn, varlink.More, nil, "", "")
+	if err != nil {
+		return errors.VKError(err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// unblock receive when watch is cancelled
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	for {
+		event, flags, err := receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if event.Type == EventTypeContainer || event.Type == EventTypePod {
+			fn(Event{
+				Type:   event.Type,
+				Status: event.Status,
+				Name:   event.Name,
+			})
+		}
+		if flags&varlink.Continues == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package podman
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/varlink/go/varlink"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+	"github.com/virtual-kubelet/podman/pkg/util/errors"
+)
+
+// Stream identifiers used by podman on upgraded varlink connections.
+// Every frame starts with 8 bytes header: destination byte, 3 bytes padding
+// and big endian uint32 payload length
+const (
+	streamStdout byte = iota
+	streamStdin
+	streamStderr
+	streamResize
+	streamQuit
+	streamHangUp
+)
+
+// ExecInContainer runs cmd in the container of the pod and copies data between
+// attach streams and the process. Exit code of the command is returned as
+// utilexec.CodeExitError so it is propagated to the client
+func (p podman) ExecInContainer(ctx context.Context, namespace, name, containerName string, cmd []string, attach api.AttachIO) error {
+	key, err := converter.BuildKeyFromNames(namespace, name)
+	if err != nil {
+		return err
+	}
+	return p.b.Exec(ctx, converter.BuildContainerName(key, containerName), cmd, attach)
+}
+
+// Exec runs the command over dedicated connection upgraded to podman stream
+func (b *varlinkBackend) Exec(ctx context.Context, name string, cmd []string, attach api.AttachIO) error {
+	conn, err := dialSocket(ctx, b.socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	opts := iopodman.ExecOpts{
+		Name: name,
+		Tty:  attach.TTY(),
+		Cmd:  cmd,
+	}
+	reader, err := upgradeCall(conn, "io.podman.ExecContainer", struct {
+		Opts iopodman.ExecOpts `json:"opts"`
+	}{Opts: opts})
+	if err != nil {
+		return errors.VKError(err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// unblock stream reader when client goes away
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	w := &frameWriter{w: conn}
+
+	if stdin := attach.Stdin(); stdin != nil {
+		go func() {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := stdin.Read(buf)
+				if n > 0 {
+					if werr := w.write(streamStdin, buf[:n]); werr != nil {
+						return
+					}
+				}
+				if err != nil {
+					w.write(streamHangUp, nil) //nolint:errcheck
+					return
+				}
+			}
+		}()
+	}
+
+	if attach.TTY() && attach.Resize() != nil {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case size, ok := <-attach.Resize():
+					if !ok {
+						return
+					}
+					data, err := json.Marshal(struct {
+						Width  uint16
+						Height uint16
+					}{size.Width, size.Height})
+					if err != nil {
+						continue
+					}
+					if err := w.write(streamResize, data); err != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	exitCode, err := readFrames(reader, attach.Stdout(), attach.Stderr())
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return utilexec.CodeExitError{
+			Err:  fmt.Errorf("command terminated with non-zero exit code %d", exitCode),
+			Code: exitCode,
+		}
+	}
+	return nil
+}
+
+// dialSocket opens raw connection to varlink address in the
+// "<protocol>:<address>[;parameters]" form
+func dialSocket(ctx context.Context, address string) (net.Conn, error) {
+	words := strings.SplitN(address, ":", 2)
+	if len(words) != 2 {
+		return nil, fmt.Errorf("protocol missing in address %q", address)
+	}
+	addr := strings.SplitN(words[1], ";", 2)[0]
+
+	var d net.Dialer
+	return d.DialContext(ctx, words[0], addr)
+}
+
+// upgradeCall sends varlink call with upgrade flag and waits for the reply.
+// After successful reply the connection carries raw podman stream frames
+func upgradeCall(conn net.Conn, method string, parameters interface{}) (*bufio.Reader, error) {
+	call, err := json.Marshal(struct {
+		Method     string      `json:"method"`
+		Parameters interface{} `json:"parameters,omitempty"`
+		Upgrade    bool        `json:"upgrade"`
+	}{method, parameters, true})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(call, 0)); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	out, err := reader.ReadBytes(0)
+	if err != nil {
+		return nil, err
+	}
+	var reply struct {
+		Parameters *json.RawMessage `json:"parameters"`
+		Error      string           `json:"error"`
+	}
+	if err := json.Unmarshal(out[:len(out)-1], &reply); err != nil {
+		return nil, err
+	}
+	if reply.Error != "" {
+		return nil, iopodman.Dispatch_Error(&varlink.Error{Name: reply.Error, Parameters: reply.Parameters})
+	}
+	return reader, nil
+}
+
+// readFrames demultiplexes podman stream until quit frame is received and
+// returns exit code carried by it
+func readFrames(r io.Reader, stdout, stderr io.Writer) (int, error) {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return 0, fmt.Errorf("exec stream closed before exit code was received")
+			}
+			return 0, err
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[4:8]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return 0, err
+		}
+
+		switch header[0] {
+		case streamStdout:
+			if stdout != nil {
+				if _, err := stdout.Write(payload); err != nil {
+					return 0, err
+				}
+			}
+		case streamStderr:
+			if stderr != nil {
+				if _, err := stderr.Write(payload); err != nil {
+					return 0, err
+				}
+			}
+		case streamQuit:
+			code, err := strconv.Atoi(strings.TrimSpace(string(payload)))
+			if err != nil {
+				return 0, fmt.Errorf("invalid exit code %q", string(payload))
+			}
+			return code, nil
+		}
+	}
+}
+
+// frameWriter serializes frames written to the upgraded connection
+type frameWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (f *frameWriter) write(dest byte, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	header := make([]byte, 8)
+	header[0] = dest
+	binary.BigEndian.PutUint32(header[4:8], uint32(len(data)))
+	if _, err := f.w.Write(header); err != nil {
+		return err
+	}
+	_, err := f.w.Write(data)
+	return err
+}
@@ -0,0 +1,212 @@
+package podman
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	"gotest.tools/assert"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+	"github.com/virtual-kubelet/podman/pkg/podman/podmantest"
+)
+
+// ioAttach is exec attach with stdin and terminal
+type ioAttach struct {
+	stdin          io.Reader
+	tty            bool
+	resize         chan api.TermSize
+	stdout, stderr bytes.Buffer
+}
+
+func (a *ioAttach) Stdin() io.Reader            { return a.stdin }
+func (a *ioAttach) Stdout() io.WriteCloser      { return nopCloser{&a.stdout} }
+func (a *ioAttach) Stderr() io.WriteCloser      { return nopCloser{&a.stderr} }
+func (a *ioAttach) TTY() bool                   { return a.tty }
+func (a *ioAttach) Resize() <-chan api.TermSize { return a.resize }
+
+// echoExec outputs the command, stdin and terminal of the session
+func echoExec(container string, cmd []string, stdin string, tty bool) (string, string, int) {
+	return fmt.Sprintf("%s %s tty=%v", strings.Join(cmd, " "), stdin, tty), "stderr", 0
+}
+
+func TestExecTTY(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		assert.NilError(t, p.Create(ctx, newTestPod("web", "nginx")))
+		server.SetExecIO(echoExec)
+
+		attach := &ioAttach{}
+		assert.NilError(t, p.ExecInContainer(ctx, "default", "web", "nginx", []string{"sh"}, attach))
+		assert.Equal(t, attach.stdout.String(), "sh  tty=false")
+		assert.Equal(t, attach.stderr.String(), "stderr")
+
+		// stderr of terminal is merged into stdout by podman
+		attach = &ioAttach{tty: true}
+		assert.NilError(t, p.ExecInContainer(ctx, "default", "web", "nginx", []string{"sh"}, attach))
+		assert.Equal(t, attach.stdout.String(), "sh  tty=true")
+		assert.Equal(t, attach.stderr.String(), "")
+	})
+}
+
+func TestRESTExecStdin(t *testing.T) {
+	p, server, cleanup := newTestPodman(t, backends[1].socket)
+	defer cleanup()
+	ctx := context.Background()
+	assert.NilError(t, p.Create(ctx, newTestPod("web", "nginx")))
+	server.SetExecIO(echoExec)
+
+	attach := &ioAttach{stdin: strings.NewReader("hello")}
+	assert.NilError(t, p.ExecInContainer(ctx, "default", "web", "nginx", []string{"cat"}, attach))
+	assert.Equal(t, attach.stdout.String(), "cat hello tty=false")
+
+	// stdin is sent once the terminal is resized, so the resize is not
+	// cancelled by the end of the session
+	stdin, stdinWriter := io.Pipe()
+	attach = &ioAttach{stdin: stdin, tty: true, resize: make(chan api.TermSize)}
+	go func() {
+		attach.resize <- api.TermSize{Width: 80, Height: 24}
+		waitFor(t, func() bool { return len(server.ExecResizes()) == 1 })
+		stdinWriter.Write([]byte("hello")) //nolint:errcheck
+		stdinWriter.Close()
+	}()
+	assert.NilError(t, p.ExecInContainer(ctx, "default", "web", "nginx", []string{"cat"}, attach))
+	assert.Equal(t, attach.stdout.String(), "cat hello tty=true")
+	assert.DeepEqual(t, server.ExecResizes(), []string{"80x24"})
+}
+
+// execCall is varlink exec call received by serveExec
+type execCall struct {
+	Method     string `json:"method"`
+	Parameters struct {
+		Opts iopodman.ExecOpts `json:"opts"`
+	} `json:"parameters"`
+	Upgrade bool `json:"upgrade"`
+}
+
+// serveExec serves a single upgraded varlink call on unix socket with the
+// handler and returns the socket address
+func serveExec(t *testing.T, handler func(call execCall, r *bufio.Reader, conn net.Conn)) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "podman-exec")
+	assert.NilError(t, err)
+	l, err := net.Listen("unix", filepath.Join(dir, "io.podman"))
+	assert.NilError(t, err)
+	go func() {
+		defer os.RemoveAll(dir)
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		data, err := r.ReadBytes(0)
+		if err != nil {
+			return
+		}
+		var call execCall
+		if err := json.Unmarshal(data[:len(data)-1], &call); err != nil {
+			return
+		}
+		handler(call, r, conn)
+	}()
+	return "unix:" + l.Addr().String()
+}
+
+func writeFrame(w io.Writer, dest byte, data string) {
+	header := make([]byte, 8)
+	header[0] = dest
+	binary.BigEndian.PutUint32(header[4:8], uint32(len(data)))
+	w.Write(append(header, data...)) //nolint:errcheck
+}
+
+func TestVarlinkExecStreams(t *testing.T) {
+	type received struct {
+		call    execCall
+		stdin   string
+		resizes []string
+	}
+	done := make(chan received, 1)
+	socket := serveExec(t, func(call execCall, r *bufio.Reader, conn net.Conn) {
+		conn.Write([]byte(`{"parameters":{}}` + "\x00")) //nolint:errcheck
+		got := received{call: call}
+		hangUp := false
+		// the resize and stdin frames are sent concurrently
+		for !hangUp || len(got.resizes) == 0 {
+			header := make([]byte, 8)
+			if _, err := io.ReadFull(r, header); err != nil {
+				return
+			}
+			payload := make([]byte, binary.BigEndian.Uint32(header[4:8]))
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			switch header[0] {
+			case streamStdin:
+				got.stdin += string(payload)
+			case streamResize:
+				got.resizes = append(got.resizes, string(payload))
+			case streamHangUp:
+				hangUp = true
+			}
+		}
+		done <- got
+		writeFrame(conn, streamStdout, "out:"+got.stdin)
+		writeFrame(conn, streamStderr, "err")
+		writeFrame(conn, streamQuit, strconv.Itoa(3))
+	})
+
+	b := &varlinkBackend{socket: socket}
+	attach := &ioAttach{stdin: strings.NewReader("hello"), tty: true, resize: make(chan api.TermSize, 1)}
+	attach.resize <- api.TermSize{Width: 80, Height: 24}
+	err := b.Exec(context.Background(), "default-web-nginx", []string{"cat"}, attach)
+	exitErr, ok := err.(utilexec.CodeExitError)
+	assert.Assert(t, ok, "expected exit error, got %v", err)
+	assert.Equal(t, exitErr.Code, 3)
+	assert.Equal(t, attach.stdout.String(), "out:hello")
+	assert.Equal(t, attach.stderr.String(), "err")
+
+	got := <-done
+	assert.Equal(t, got.call.Method, "io.podman.ExecContainer")
+	assert.Assert(t, got.call.Upgrade)
+	assert.Equal(t, got.call.Parameters.Opts.Name, "default-web-nginx")
+	assert.DeepEqual(t, got.call.Parameters.Opts.Cmd, []string{"cat"})
+	assert.Assert(t, got.call.Parameters.Opts.Tty)
+	assert.Equal(t, got.stdin, "hello")
+	assert.DeepEqual(t, got.resizes, []string{`{"Width":80,"Height":24}`})
+}
+
+func TestVarlinkExecErrors(t *testing.T) {
+	socket := serveExec(t, func(call execCall, r *bufio.Reader, conn net.Conn) {
+		conn.Write([]byte(`{"error":"io.podman.ContainerNotFound","parameters":{"id":"default-web-nginx","reason":"no such container"}}` + "\x00")) //nolint:errcheck
+	})
+	b := &varlinkBackend{socket: socket}
+	err := b.Exec(context.Background(), "default-web-nginx", []string{"sh"}, &ioAttach{})
+	assert.Assert(t, errdefs.IsNotFound(err), "expected not found error, got %v", err)
+
+	// the stream ends without exit code
+	socket = serveExec(t, func(call execCall, r *bufio.Reader, conn net.Conn) {
+		conn.Write([]byte(`{"parameters":{}}` + "\x00")) //nolint:errcheck
+		writeFrame(conn, streamStdout, "partial")
+	})
+	b = &varlinkBackend{socket: socket}
+	attach := &ioAttach{}
+	err = b.Exec(context.Background(), "default-web-nginx", []string{"sh"}, attach)
+	assert.ErrorContains(t, err, "exec stream closed before exit code was received")
+	assert.Equal(t, attach.stdout.String(), "partial")
+}
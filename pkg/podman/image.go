@@ -0,0 +1,130 @@
+package podman
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/util/errors"
+)
+
+const (
+	// Image pull back-off, same as kubelet uses
+	initialPullBackOff = 10 * time.Second
+	maxPullBackOff     = 5 * time.Minute
+
+	// container waiting reasons, same as kubelet reports
+	reasonErrImagePull      = "ErrImagePull"
+	reasonImagePullBackOff  = "ImagePullBackOff"
+	reasonErrImageNeverPull = "ErrImageNeverPull"
+)
+
+// ImagePullError is returned by Create when image of a container is not
+// available. The pod and containers created so far are kept and the failure is
+// reported as waiting reason of the container, so Create can be called again
+// to retry the pull
+type ImagePullError struct {
+	Container string
+	Reason    string
+	Message   string
+}
+
+func (e *ImagePullError) Error() string {
+	return fmt.Sprintf("container %s: %s: %s", e.Container, e.Reason, e.Message)
+}
+
+// IsImagePullError returns true if err is ImagePullError
+func IsImagePullError(err error) bool {
+	_, ok := err.(*ImagePullError)
+	return ok
+}
+
+// IsImagePullReason returns true if reason is waiting reason of container
+// which image couldn't be pulled
+func IsImagePullReason(reason string) bool {
+	return reason == reasonErrImagePull || reason == reasonImagePullBackOff || reason == reasonErrImageNeverPull
+}
+
+// ensureImage makes image of the container present according to its
+// imagePullPolicy. Failed pulls are retried with back-off
+func (p podman) ensureImage(ctx context.Context, pod *corev1.Pod, key string, c corev1.Container) error {
+	name := converter.BuildContainerName(key, c.Name)
+	present, err := p.imageExists(ctx, c.Image)
+	if err != nil {
+		return err
+	}
+
+	policy := pullPolicy(c)
+	if policy == corev1.PullNever && !present {
+		message := fmt.Sprintf("Container image %q is not present with pull policy of Never", c.Image)
+		p.recorder.Event(pod, corev1.EventTypeWarning, reasonErrImageNeverPull, message)
+		return p.imageNotAvailable(name, c.Name, reasonErrImageNeverPull, message)
+	}
+	if present && policy != corev1.PullAlways {
+		p.recorder.Eventf(pod, corev1.EventTypeNormal, "Pulled", "Container image %q already present on machine", c.Image)
+		p.waiting.Delete(name)
+		return nil
+	}
+
+	backOffKey := name + "/" + c.Image
+	if p.pullBackOff.IsInBackOffSinceUpdate(backOffKey, p.pullBackOff.Clock.Now()) {
+		message := fmt.Sprintf("Back-off pulling image %q", c.Image)
+		p.recorder.Event(pod, corev1.EventTypeNormal, "BackOff", message)
+		return p.imageNotAvailable(name, c.Name, reasonImagePullBackOff, message)
+	}
+
+	p.recorder.Eventf(pod, corev1.EventTypeNormal, "Pulling", "Pulling image %q", c.Image)
+	if err := p.pullImage(ctx, pod, c.Image); err != nil {
+		p.log.Error("error pullImage", "err", err.Error())
+		p.pullBackOff.Next(backOffKey, p.pullBackOff.Clock.Now())
+		p.recorder.Eventf(pod, corev1.EventTypeWarning, "Failed", "Failed to pull image %q: %v", c.Image, err)
+		return p.imageNotAvailable(name, c.Name, reasonErrImagePull, err.Error())
+	}
+	p.pullBackOff.GC()
+	p.recorder.Eventf(pod, corev1.EventTypeNormal, "Pulled", "Successfully pulled image %q", c.Image)
+	p.waiting.Delete(name)
+	return nil
+}
+
+// imageNotAvailable records waiting reason of the container and returns
+// matching error
+func (p podman) imageNotAvailable(name, container, reason, message string) error {
+	p.waiting.Store(name, corev1.ContainerStateWaiting{
+		Reason:  reason,
+		Message: message,
+	})
+	return &ImagePullError{
+		Container: container,
+		Reason:    reason,
+		Message:   message,
+	}
+}
+
+func (p podman) imageExists(ctx context.Context, image string) (bool, error) {
+	exists, err := p.b.ImageExists(ctx, image)
+	if err != nil {
+		return false, errors.VKError(err)
+	}
+	return exists, nil
+}
+
+// pullPolicy returns imagePullPolicy of the container. Policy of containers
+// created without API server defaulting is derived from the image tag the
+// same way: latest or missing tag means Always
+func pullPolicy(c corev1.Container) corev1.PullPolicy {
+	if c.ImagePullPolicy != "" {
+		return c.ImagePullPolicy
+	}
+	if strings.Contains(c.Image, "@") {
+		return corev1.PullIfNotPresent
+	}
+	image := c.Image[strings.LastIndex(c.Image, "/")+1:]
+	if i := strings.LastIndex(image, ":"); i < 0 || image[i+1:] == "latest" {
+		return corev1.PullAlways
+	}
+	return corev1.PullIfNotPresent
+}
@@ -0,0 +1,155 @@
+package podman
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/kubernetes/pkg/credentialprovider"
+)
+
+// pullBackend is backend with images present in images. Pulls are recorded
+// by the user name of their credentials, empty for anonymous pulls, and
+// fail with failures of the user
+type pullBackend struct {
+	backend
+	images   map[string]bool
+	failures map[string]error
+	pulls    []string
+}
+
+func (b *pullBackend) ImageExists(ctx context.Context, image string) (bool, error) {
+	return b.images[image], nil
+}
+
+func (b *pullBackend) PullImage(ctx context.Context, image string, auth *credentialprovider.AuthConfig) error {
+	user := ""
+	if auth != nil {
+		user = auth.Username
+	}
+	b.pulls = append(b.pulls, user)
+	if err := b.failures[user]; err != nil {
+		return err
+	}
+	b.images[image] = true
+	return nil
+}
+
+// newPullPodman returns podman client pulling images with b and image pull
+// secrets from objs
+func newPullPodman(t *testing.T, b *pullBackend, objs ...interface{}) podman {
+	t.Helper()
+	p := newEnvPodman(t, objs...)
+	p.b = b
+	p.recorder = record.NewFakeRecorder(100)
+	p.pullBackOff = flowcontrol.NewBackOff(initialPullBackOff, maxPullBackOff)
+	p.waiting = &sync.Map{}
+	return p
+}
+
+func TestPullPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		image  string
+		policy corev1.PullPolicy
+		want   corev1.PullPolicy
+	}{
+		{image: "busybox", want: corev1.PullAlways},
+		{image: "busybox:latest", want: corev1.PullAlways},
+		{image: "busybox:1.31", want: corev1.PullIfNotPresent},
+		{image: "library/busybox", want: corev1.PullAlways},
+		{image: "docker.io/library/busybox:latest", want: corev1.PullAlways},
+		{image: "docker.io/library/busybox:1.31", want: corev1.PullIfNotPresent},
+		{image: "busybox@sha256:1303dbf110c57f3edf68d9f5a16c082ec06c4cf7604831669faf2c712260b5a0", want: corev1.PullIfNotPresent},
+		{image: "busybox:latest@sha256:1303dbf110c57f3edf68d9f5a16c082ec06c4cf7604831669faf2c712260b5a0", want: corev1.PullIfNotPresent},
+		// port of registry host is not a tag
+		{image: "localhost:5000/app", want: corev1.PullAlways},
+		{image: "localhost:5000/app:latest", want: corev1.PullAlways},
+		{image: "localhost:5000/app:1.0", want: corev1.PullIfNotPresent},
+		{image: "registry.example.com:5000/team/app:1.0", want: corev1.PullIfNotPresent},
+		// explicit policy wins over the tag
+		{image: "busybox", policy: corev1.PullIfNotPresent, want: corev1.PullIfNotPresent},
+		{image: "busybox:latest", policy: corev1.PullNever, want: corev1.PullNever},
+		{image: "busybox:1.31", policy: corev1.PullAlways, want: corev1.PullAlways},
+	} {
+		c := corev1.Container{Image: tc.image, ImagePullPolicy: tc.policy}
+		assert.Equal(t, pullPolicy(c), tc.want, "%s %s", tc.image, tc.policy)
+	}
+}
+
+func TestEnsureImage(t *testing.T) {
+	for _, tc := range []struct {
+		image   string
+		policy  corev1.PullPolicy
+		present bool
+		pull    bool
+		reason  string
+	}{
+		{image: "busybox", present: true, pull: true},
+		{image: "busybox", present: false, pull: true},
+		{image: "busybox:latest", present: true, pull: true},
+		{image: "busybox:1.31", present: true},
+		{image: "busybox:1.31", present: false, pull: true},
+		{image: "busybox", policy: corev1.PullAlways, present: true, pull: true},
+		{image: "busybox:1.31", policy: corev1.PullAlways, present: true, pull: true},
+		{image: "busybox", policy: corev1.PullIfNotPresent, present: true},
+		{image: "busybox:latest", policy: corev1.PullIfNotPresent, present: true},
+		{image: "busybox:latest", policy: corev1.PullIfNotPresent, present: false, pull: true},
+		{image: "busybox", policy: corev1.PullNever, present: true},
+		{image: "busybox:latest", policy: corev1.PullNever, present: true},
+		{image: "busybox", policy: corev1.PullNever, present: false, reason: reasonErrImageNeverPull},
+		{image: "busybox:latest", policy: corev1.PullNever, present: false, reason: reasonErrImageNeverPull},
+	} {
+		name := fmt.Sprintf("%s %s present=%v", tc.image, tc.policy, tc.present)
+		b := &pullBackend{images: map[string]bool{tc.image: tc.present}}
+		p := newPullPodman(t, b)
+		pod := newEnvPod()
+		c := corev1.Container{Name: "app", Image: tc.image, ImagePullPolicy: tc.policy}
+
+		err := p.ensureImage(context.Background(), pod, "default-web", c)
+		if tc.reason != "" {
+			assert.Assert(t, IsImagePullError(err), name)
+			assert.Equal(t, err.(*ImagePullError).Reason, tc.reason, name)
+			waiting, ok := p.waiting.Load("default-web-app")
+			assert.Assert(t, ok, name)
+			assert.Equal(t, waiting.(corev1.ContainerStateWaiting).Reason, tc.reason, name)
+		} else {
+			assert.NilError(t, err, name)
+		}
+		assert.Equal(t, len(b.pulls) == 1, tc.pull, name)
+	}
+}
+
+func TestEnsureImageBackOff(t *testing.T) {
+	b := &pullBackend{
+		images:   map[string]bool{},
+		failures: map[string]error{"": fmt.Errorf("manifest unknown")},
+	}
+	p := newPullPodman(t, b)
+	pod := newEnvPod()
+	c := corev1.Container{Name: "app", Image: "busybox:1.31"}
+
+	err := p.ensureImage(context.Background(), pod, "default-web", c)
+	assert.Assert(t, IsImagePullError(err))
+	assert.Equal(t, err.(*ImagePullError).Reason, reasonErrImagePull)
+	assert.Assert(t, is.Contains(err.Error(), "manifest unknown"))
+
+	// pull is not retried until back-off passes
+	err = p.ensureImage(context.Background(), pod, "default-web", c)
+	assert.Assert(t, IsImagePullError(err))
+	assert.Equal(t, err.(*ImagePullError).Reason, reasonImagePullBackOff)
+	assert.Equal(t, len(b.pulls), 1)
+
+	// back-off is kept per image, pull of the fixed image clears waiting reason
+	b.failures = nil
+	c.Image = "busybox:1.32"
+	assert.NilError(t, p.ensureImage(context.Background(), pod, "default-web", c))
+	assert.Equal(t, len(b.pulls), 2)
+	_, ok := p.waiting.Load("default-web-app")
+	assert.Assert(t, !ok)
+}
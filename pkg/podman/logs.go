@@ -0,0 +1,138 @@
+package podman
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/varlink/go/varlink"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+	"github.com/virtual-kubelet/podman/pkg/util/errors"
+)
+
+// ContainerLogOpts defines how container logs are read from podman
+type ContainerLogOpts struct {
+	// Tail is the number of lines to return from the end of the log.
+	// Zero or negative value returns the whole log
+	Tail int
+	// Since returns only lines logged after the given time
+	Since time.Time
+	// LimitBytes caps the number of bytes returned. Zero means no limit
+	LimitBytes int
+	// Timestamps prefixes every line with its RFC3339Nano timestamp
+	Timestamps bool
+	// Follow keeps the stream open and returns new lines as they are logged.
+	// The provider doesn't set it until virtual-kubelet passes follow option
+	Follow bool
+}
+
+// GetContainerLogs returns log stream of the container in the pod
+func (p podman) GetContainerLogs(ctx context.Context, namespace, name, containerName string, opts ContainerLogOpts) (io.ReadCloser, error) {
+	key, err := converter.BuildKeyFromNames(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := p.b.ContainerLogs(ctx, converter.BuildContainerName(key, containerName), opts)
+	if err != nil {
+		return nil, errors.VKError(err)
+	}
+	if opts.LimitBytes > 0 {
+		rc = &logReader{
+			Reader: io.LimitReader(rc, int64(opts.LimitBytes)),
+			close:  rc.Close,
+		}
+	}
+	return rc, nil
+}
+
+// ContainerLogs reads logs using connection of the long lane, so long or
+// followed streams do not block other calls
+func (b *varlinkBackend) ContainerLogs(ctx context.Context, ctrName string, opts ContainerLogOpts) (io.ReadCloser, error) {
+	conn, release, err := b.c.Stream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var since string
+	if !opts.Since.IsZero() {
+		since = opts.Since.Format(time.RFC3339Nano)
+	}
+	tail := int64(0)
+	if opts.Tail > 0 {
+		tail = int64(opts.Tail)
+	}
+
+	// timestamps are rendered by formatLogLine, podman returns them
+	// as separate field
+	ctx, cancel := context.WithCancel(ctx)
+	receive, err := iopodman.GetContainersLogs().Send(ctx, conn, varlink.More, []string{ctrName}, opts.Follow, false, since, tail, false)
+	if err != nil {
+		cancel()
+		release()
+		return nil, err
+	}
+
+	// read first reply synchronously so missing containers are reported
+	// as errors and not as empty log stream
+	line, flags, err := receive(ctx)
+	if err != nil {
+		cancel()
+		release()
+		return nil, err
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		defer release()
+		for {
+			if line != (iopodman.LogLine{}) {
+				if _, err := io.WriteString(w, formatLogLine(line, opts.Timestamps)); err != nil {
+					return
+				}
+			}
+			if flags&varlink.Continues == 0 {
+				w.Close()
+				return
+			}
+			line, flags, err = receive(ctx)
+			if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	return &logReader{
+		Reader: r,
+		close: func() error {
+			cancel()
+			return r.Close()
+		},
+	}, nil
+}
+
+// formatLogLine renders podman log line the same way as kubelet does.
+// Partial lines are not terminated so they are joined with the next one
+func formatLogLine(line iopodman.LogLine, timestamps bool) string {
+	msg := line.Msg
+	if line.ParseLogType != "P" {
+		msg += "\n"
+	}
+	if timestamps && line.Time != "" {
+		return fmt.Sprintf("%s %s", line.Time, msg)
+	}
+	return msg
+}
+
+type logReader struct {
+	io.Reader
+	close func() error
+}
+
+func (l *logReader) Close() error {
+	return l.close()
+}
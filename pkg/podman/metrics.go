@@ -0,0 +1,33 @@
+package podman
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var (
+	poolWaitTime = stats.Float64("podman/pool_wait_time", "Time spent waiting for a podman connection", stats.UnitMilliseconds)
+	laneKey      = tag.MustNewKey("lane")
+
+	// PoolWaitTimeView is distribution of time calls wait for a connection
+	// of the podman connection pool, by pool lane
+	PoolWaitTimeView = &view.View{
+		Name:        "podman/pool_wait_time",
+		Description: "Time spent waiting for a podman connection",
+		Measure:     poolWaitTime,
+		TagKeys:     []tag.Key{laneKey},
+		Aggregation: view.Distribution(0, 1, 5, 10, 50, 100, 500, 1000, 5000, 10000, 30000),
+	}
+)
+
+func recordPoolWait(ctx context.Context, lane string, wait time.Duration) {
+	stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(laneKey, lane)}, poolWaitTime.M(float64(wait)/float64(time.Millisecond))) //nolint:errcheck
+}
+
+func registerViews() error {
+	return view.Register(PoolWaitTimeView)
+}
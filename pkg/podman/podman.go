@@ -2,80 +2,177 @@ package podman
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"sync"
 	"time"
 
-	"github.com/varlink/go/varlink"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
 
 	"github.com/virtual-kubelet/podman/pkg/converter"
 	"github.com/virtual-kubelet/podman/pkg/iopodman"
+	"github.com/virtual-kubelet/podman/pkg/manager"
+	"github.com/virtual-kubelet/podman/pkg/state"
 	"github.com/virtual-kubelet/podman/pkg/util/errors"
 	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
 )
 
 var (
 	// Provider configuration defaults.
-	defaultSocket = "unix:/run/podman/io.podman"
-	defaultSleep  = time.Millisecond * 100
+	defaultSocket     = "unix:/run/podman/io.podman"
+	defaultVolumesDir = "/var/lib/vkubelet/pods"
+	// defaultAuthFile is where podman running as root reads registry
+	// credentials from
+	defaultAuthFile = "/run/containers/0/auth.json"
+	defaultSleep    = time.Millisecond * 100
+	// defaultWaitInterval is how often podman checks container state while
+	// waiting for it to exit
+	defaultWaitInterval = time.Millisecond * 500
+	// defaultCallTimeout limits duration of short podman calls
+	defaultCallTimeout = time.Minute
+	// defaultPoolSize is the number of connections of each pool lane
+	defaultPoolSize = 4
+	// defaultRetries is how many times idempotent calls failing with
+	// transient errors are retried
+	defaultRetries = 3
 )
 
 // Config defines podman configurables
 type Config struct {
+	// Socket is the podman varlink address or, for unix:///path
+	// addresses, the libpod REST API socket
 	Socket *string
-	Log    *zap.SugaredLogger
-}
-
-type conn struct {
-	varlink.Connection
-	sync.Mutex
+	// VolumesDir is the host directory with provider managed pod volumes
+	VolumesDir *string
+	// AuthFile is the registry auth file read by podman service. Image
+	// pull secrets are written there for the duration of the pull. REST
+	// API takes the credentials with the pull call and does not use it.
+	// Pulls with credentials are rejected for remote hosts
+	AuthFile *string
+	// PoolSize is the number of concurrent short podman calls
+	PoolSize int
+	// LongPoolSize is the number of concurrent long running podman calls,
+	// such as image pulls, stops, waits and log streams
+	LongPoolSize int
+	// CallTimeout limits duration of short podman calls
+	CallTimeout time.Duration
+	// SSHIdentityFile is the private key used to reach ssh:// sockets
+	SSHIdentityFile string
+	// SSHKnownHostsFile holds accepted host keys of ssh:// sockets
+	SSHKnownHostsFile string
+	// SSHKeepalive is the interval of keepalive requests of ssh:// sockets
+	SSHKeepalive time.Duration
+	// ResourceManager is used to resolve container environment
+	ResourceManager *manager.ResourceManager
+	// NodeAllocatable is used for resourceFieldRef of containers without
+	// limits
+	NodeAllocatable corev1.ResourceList
+	// HostIP is the node address reported as pod hostIP and as podIP of
+	// pods using host network
+	HostIP string
+	// NativeHealthchecks translates exec liveness probes into podman
+	// healthchecks
+	NativeHealthchecks bool
+	// Recorder receives image pull events of pods. It is required
+	Recorder record.EventRecorder
+	// State keeps kubernetes pods created in podman. It is required
+	State *state.Store
+	Log   *zap.SugaredLogger
 }
 
 type podman struct {
-	c   *conn
-	log *zap.SugaredLogger
+	b           backend
+	volumesDir  string
+	rm          *manager.ResourceManager
+	allocatable corev1.ResourceList
+	hostIP      string
+	// nativeHealthchecks translates exec liveness probes into podman
+	// healthchecks
+	nativeHealthchecks bool
+	recorder           record.EventRecorder
+	state              *state.Store
+	pullBackOff        *flowcontrol.Backoff
+	// waiting holds reasons of containers which could not be created,
+	// keyed by podman container name
+	waiting *sync.Map
+	log     *zap.SugaredLogger
 }
 
-// Podman is an simplified interface to interfact with
-// podman varlink api
+// Podman is an simplified interface to interfact with podman. Podman varlink
+// API and libpod REST API are supported
 type Podman interface {
-	// Methdods locking the connection
 	Create(ctx context.Context, pod *corev1.Pod) error
 	Delete(ctx context.Context, pod *corev1.Pod) error
+	Start(ctx context.Context, pod *corev1.Pod) error
+	StartContainer(ctx context.Context, pod *corev1.Pod, containerName string) error
+	StopContainer(ctx context.Context, pod *corev1.Pod, containerName string) error
+	Stop(ctx context.Context, pod *corev1.Pod) error
 	GetByName(ctx context.Context, name string) (*corev1.Pod, error)
 	List(ctx context.Context) (*corev1.PodList, error)
 	GetPodStats(ctx context.Context, pod *corev1.Pod) (*stats.PodStats, error)
-	// Methods using above methods
+	DiskUsage(ctx context.Context, pod *corev1.Pod) (map[string]int64, error)
+	// Methods using other methods
 	Update(ctx context.Context, pod *corev1.Pod) error
 	CreateOrUpdate(ctx context.Context, pod *corev1.Pod) error
 	Get(ctx context.Context, pod *corev1.Pod) (*corev1.Pod, error)
+	// Methods streaming data
+	GetContainerLogs(ctx context.Context, namespace, name, containerName string, opts ContainerLogOpts) (io.ReadCloser, error)
+	ExecInContainer(ctx context.Context, namespace, name, containerName string, cmd []string, attach api.AttachIO) error
+	WatchEvents(ctx context.Context, fn func(Event)) error
+	// Close closes connections to podman
+	Close() error
 }
 
 // New created new instance of podman interface
 func New(ctx context.Context, c *Config) (Podman, error) {
 	podman := podman{}
 	cfg := getConfig(c)
+	if cfg.State == nil {
+		return nil, fmt.Errorf("state store is required")
+	}
+	if cfg.Recorder == nil {
+		return nil, fmt.Errorf("event recorder is required")
+	}
 	var err error
-	vConn, err := varlink.NewConnection(ctx, *cfg.Socket)
+	if IsRESTSocket(*cfg.Socket) {
+		podman.b, err = newRESTBackend(ctx, cfg)
+	} else {
+		podman.b, err = newVarlinkBackend(ctx, cfg)
+	}
 	if err != nil {
 		return nil, err
 	}
-
-	conn := conn{
-		Connection: *vConn,
+	if err := registerViews(); err != nil {
+		podman.Close()
+		return nil, err
 	}
-	podman.c = &conn
+	podman.volumesDir = *cfg.VolumesDir
+	podman.rm = cfg.ResourceManager
+	podman.allocatable = cfg.NodeAllocatable
+	podman.hostIP = cfg.HostIP
+	podman.nativeHealthchecks = cfg.NativeHealthchecks
+	podman.recorder = cfg.Recorder
+	podman.state = cfg.State
+	podman.pullBackOff = flowcontrol.NewBackOff(initialPullBackOff, maxPullBackOff)
+	podman.waiting = &sync.Map{}
 	podman.log = cfg.Log
 
 	return podman, nil
 }
 
+// Close closes connections to podman
+func (p podman) Close() error {
+	return p.b.Close()
+}
+
 func getConfig(c *Config) *Config {
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
@@ -85,6 +182,21 @@ func getConfig(c *Config) *Config {
 		if c.Socket == nil {
 			c.Socket = &defaultSocket
 		}
+		if c.VolumesDir == nil {
+			c.VolumesDir = &defaultVolumesDir
+		}
+		if c.AuthFile == nil {
+			c.AuthFile = &defaultAuthFile
+		}
+		if c.PoolSize <= 0 {
+			c.PoolSize = defaultPoolSize
+		}
+		if c.LongPoolSize <= 0 {
+			c.LongPoolSize = defaultPoolSize
+		}
+		if c.CallTimeout <= 0 {
+			c.CallTimeout = defaultCallTimeout
+		}
 		if c.Log == nil {
 			c.Log = log
 		}
@@ -92,8 +204,13 @@ func getConfig(c *Config) *Config {
 	}
 
 	return &Config{
-		Socket: &defaultSocket,
-		Log:    log,
+		Socket:       &defaultSocket,
+		VolumesDir:   &defaultVolumesDir,
+		AuthFile:     &defaultAuthFile,
+		PoolSize:     defaultPoolSize,
+		LongPoolSize: defaultPoolSize,
+		CallTimeout:  defaultCallTimeout,
+		Log:          log,
 	}
 }
 
@@ -104,20 +221,31 @@ func (p podman) Create(ctx context.Context, pod *corev1.Pod) error {
 	}
 
 	key := converter.BuildKey(pod)
-	podmanPod, err := converter.GetPodmanPod(key, pod)
-	if err != nil {
-		p.log.Error("getPodmanPod failed", "err", err.Error())
+	podmanPodName := key
+	if err := p.ensureState(pod); err != nil {
+		p.log.Error("error storing pod state", "err", err.Error())
 		return err
 	}
-	p.c.Lock()
-	podmanPodName, err := iopodman.CreatePod().Call(ctx, &p.c.Connection, *podmanPod)
-	p.c.Unlock()
+	exists, err := p.podExists(ctx, key)
 	if err != nil {
-		p.log.Error("create pod failed", "err", err.Error())
-		return errors.VKError(err)
+		return err
+	}
+	// pod is kept when container image is not available, so Create can be
+	// called again to resume it
+	if !exists {
+		podmanPod, err := converter.GetPodmanPod(key, pod)
+		if err != nil {
+			p.log.Error("getPodmanPod failed", "err", err.Error())
+			return err
+		}
+		podmanPodName, err = p.b.CreatePod(ctx, *podmanPod)
+		if err != nil {
+			p.log.Error("create pod failed", "err", err.Error())
+			return errors.VKError(err)
+		}
+		p.log.Info("pod created ", "podName ", podmanPodName)
 	}
 
-	p.log.Info("pod created ", "podName ", podmanPodName)
 	// Create hostPath volumes if does not exist
 	for _, volume := range pod.Spec.Volumes {
 		if volume.HostPath != nil {
@@ -134,71 +262,222 @@ func (p podman) Create(ctx context.Context, pod *corev1.Pod) error {
 			default:
 				p.log.Debug("hostPath volume type %s is not supported", volume.HostPath.Type)
 			}
-		} else {
+		} else if !converter.IsManagedVolume(volume) {
 			p.log.Debug("volume provider %s is not supported", volume.String())
 		}
 	}
 
-	// add containers in the pod
-	for _, c := range pod.Spec.Containers {
-		p.log.Info("create container ", "pod ", podmanPodName, " container ", c.Name)
-		container := converter.KubeSpecToPodmanContainer(*pod, c, podmanPodName)
+	podIP, err := p.startInfra(ctx, key)
+	if err != nil {
+		p.log.Error("error starting infra container", "err", err.Error())
+		return err
+	}
+	if pod.Spec.HostNetwork {
+		podIP = p.hostIP
+	}
 
-		// pull image
-		p.c.Lock()
-		_, err := iopodman.PullImage().Call(ctx, &p.c.Connection, c.Image)
-		p.c.Unlock()
+	// add init and application containers in the pod. Containers are only
+	// created here, Start runs them in the right order
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		exists, err := p.containerExists(ctx, converter.BuildContainerName(key, c.Name))
 		if err != nil {
-			p.log.Error("error pullImage", "err", err.Error())
-			return errors.VKError(err)
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		p.log.Info("create container ", "pod ", podmanPodName, " container ", c.Name)
+		c, err := p.resolveContainer(pod, c, podIP)
+		if err != nil {
+			p.log.Error("error resolving container environment", "err", err.Error())
+			return err
+		}
+		container := converter.KubeSpecToPodmanContainer(*pod, c, key, p.volumesDir)
+		converter.ApplyResources(&container, pod, &c, p.allocatable.Memory().Value())
+		if p.nativeHealthchecks {
+			if err := converter.ApplyHealthcheck(&container, &c); err != nil {
+				p.log.Error("error converting liveness probe", "err", err.Error())
+				return err
+			}
 		}
 
-		p.c.Lock()
-		_, err = iopodman.CreateContainer().Call(ctx, &p.c.Connection, container)
-		p.c.Unlock()
+		if err := p.ensureImage(ctx, pod, key, c); err != nil {
+			return err
+		}
+
+		err = p.b.CreateContainer(ctx, container)
 		if err != nil {
 			p.log.Error("error createContainer", "err", err.Error())
 			return errors.VKError(err)
 		}
 	}
 
-	// start pod
-	p.c.Lock()
-	_, err = iopodman.StartPod().Call(ctx, &p.c.Connection, podmanPodName)
-	p.c.Unlock()
+	err = p.Start(ctx, pod)
 	if err != nil {
 		p.log.Error("error startPod", "err", err.Error())
-		return errors.VKError(err)
+		return err
+	}
+
+	return nil
+}
+
+// Start runs init containers of the pod one by one and starts application
+// containers once all of them completed successfully. Completed init
+// containers are not run again, so Start can be called repeatedly to retry
+// failed ones. Failures are reported in the pod status and not as error
+func (p podman) Start(ctx context.Context, pod *corev1.Pod) error {
+	key := converter.BuildKey(pod)
+
+	for _, c := range pod.Spec.InitContainers {
+		name := converter.BuildContainerName(key, c.Name)
+		container, err := p.inspectContainer(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		switch container.State.Status {
+		case "running":
+			// started by previous call, wait for it below
+		case "exited", "stopped":
+			if container.State.ExitCode == 0 {
+				continue
+			}
+			if pod.Spec.RestartPolicy == corev1.RestartPolicyNever {
+				return nil
+			}
+			fallthrough
+		default:
+			p.log.Info("start init container ", "pod ", key, " container ", c.Name)
+			err = p.b.StartContainer(ctx, name)
+			if err != nil {
+				return errors.VKError(err)
+			}
+		}
+
+		exitCode, err := p.waitContainer(ctx, name)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			p.log.Info("init container failed ", "pod ", key, " container ", c.Name, " exitCode ", exitCode)
+			return nil
+		}
 	}
 
-	// check pod status
-	retry := 1
-	for retry < 5 {
-		retry++
-		p.c.Lock()
-		podmanPodStatus, err := iopodman.InspectPod().Call(ctx, &p.c.Connection, podmanPodName)
-		p.c.Unlock()
+	for _, c := range pod.Spec.Containers {
+		name := converter.BuildContainerName(key, c.Name)
+		container, err := p.inspectContainer(ctx, name)
 		if err != nil {
-			p.log.Error("error GetPod.InspectPod ", "err ", err.Error())
-			return errors.VKError(err)
+			return err
+		}
+		if container.State.Status != "configured" && container.State.Status != "created" {
+			continue
 		}
 
-		var status PodmanPod
-		err = json.Unmarshal([]byte(podmanPodStatus), &status)
+		err = p.b.StartContainer(ctx, name)
 		if err != nil {
 			return errors.VKError(err)
 		}
-		healty := true
-		for _, c := range status.Containers {
-			if c.State != "running" {
-				healty = false
+	}
+
+	return nil
+}
+
+// DiskUsage returns size of writable layer of the pod containers in bytes,
+// keyed by kubernetes container name
+func (p podman) DiskUsage(ctx context.Context, pod *corev1.Pod) (map[string]int64, error) {
+	key := converter.BuildKey(pod)
+	usage := map[string]int64{}
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		size, err := p.b.ContainerSize(ctx, converter.BuildContainerName(key, c.Name))
+		if err != nil {
+			if _, ok := err.(*iopodman.ContainerNotFound); ok {
+				continue
 			}
+			return nil, errors.VKError(err)
 		}
-		if healty {
-			continue
-		}
+		usage[c.Name] = size
+	}
+	return usage, nil
+}
+
+// startInfra starts infra container of the pod, so the pod network is set up
+// before application containers are created, and returns the pod IP
+func (p podman) startInfra(ctx context.Context, key string) (string, error) {
+	pPod, err := p.b.InspectPod(ctx, key)
+	if err != nil {
+		return "", errors.VKError(err)
+	}
+	infraID := pPod.State.InfraContainerID
+	if infraID == "" {
+		return "", nil
+	}
+
+	infra, err := p.inspectContainer(ctx, infraID)
+	if err != nil {
+		return "", err
+	}
+	// infra is already running when Create is retried
+	if infra.State.Status == "running" {
+		return infra.NetworkSettings.IPAddress, nil
+	}
+
+	err = p.b.StartContainer(ctx, infraID)
+	if err != nil {
+		return "", errors.VKError(err)
+	}
+	infra, err = p.inspectContainer(ctx, infraID)
+	if err != nil {
+		return "", err
+	}
+	return infra.NetworkSettings.IPAddress, nil
+}
+
+// StartContainer starts single container of the pod. It is used to restart
+// exited containers
+func (p podman) StartContainer(ctx context.Context, pod *corev1.Pod, containerName string) error {
+	name := converter.BuildContainerName(converter.BuildKey(pod), containerName)
+	err := p.b.StartContainer(ctx, name)
+	if err != nil {
+		return errors.VKError(err)
+	}
+	return nil
+}
+
+// StopContainer stops single container of the pod, giving it the pod
+// termination grace period to exit
+func (p podman) StopContainer(ctx context.Context, pod *corev1.Pod, containerName string) error {
+	timeout := int64(corev1.DefaultTerminationGracePeriodSeconds)
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		timeout = *pod.Spec.TerminationGracePeriodSeconds
 	}
 
+	name := converter.BuildContainerName(converter.BuildKey(pod), containerName)
+	err := p.b.StopContainer(ctx, name, timeout)
+	if err != nil {
+		p.log.Error("error while stopping container", " container ", name, " err ", err.Error())
+		return errors.VKError(err)
+	}
+	return nil
+}
+
+// Stop stops all containers of the pod, giving them the pod termination grace
+// period to exit
+func (p podman) Stop(ctx context.Context, pod *corev1.Pod) error {
+	timeout := int64(corev1.DefaultTerminationGracePeriodSeconds)
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		timeout = *pod.Spec.TerminationGracePeriodSeconds
+	}
+
+	key := converter.BuildKey(pod)
+	err := p.b.StopPod(ctx, key, timeout)
+	if err != nil {
+		p.log.Error("error while stopping pod", " pod ", key, " err ", err.Error())
+		return errors.VKError(err)
+	}
 	return nil
 }
 
@@ -232,9 +511,16 @@ func (p podman) Delete(ctx context.Context, pod *corev1.Pod) error {
 	}
 
 	key := converter.BuildKey(pod)
-	p.c.Lock()
-	_, err := iopodman.RemovePod().Call(ctx, &p.c.Connection, key, true)
-	p.c.Unlock()
+	for _, c := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		p.waiting.Delete(converter.BuildContainerName(key, c.Name))
+	}
+	err := p.b.RemovePod(ctx, key)
+	if _, notFound := err.(*iopodman.PodNotFound); err == nil || notFound {
+		if err := p.state.Delete(pod.UID); err != nil {
+			p.log.Error("error while deleting pod state", " pod ", key, " err ", err.Error())
+			return err
+		}
+	}
 	if err != nil {
 		p.log.Error("error while deleting pod", " pod ", key, " err ", err.Error())
 		return errors.VKError(err)
@@ -243,11 +529,29 @@ func (p podman) Delete(ctx context.Context, pod *corev1.Pod) error {
 	return nil
 }
 
+// Update recreates the pod with the new spec. Unlike Delete it keeps pod
+// state, so restart history survives the update
 func (p podman) Update(ctx context.Context, pod *corev1.Pod) error {
-	err := p.Delete(ctx, pod)
-	if err != nil {
+	if pod == nil {
+		return fmt.Errorf("update pod can't be nil")
+	}
+
+	key := converter.BuildKey(pod)
+	for _, c := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		p.waiting.Delete(converter.BuildContainerName(key, c.Name))
+	}
+	err := p.b.RemovePod(ctx, key)
+	if _, notFound := err.(*iopodman.PodNotFound); err != nil && !notFound {
+		p.log.Error("error while removing pod for update", " pod ", key, " err ", err.Error())
 		return errors.VKError(err)
 	}
+	err = p.state.Update(pod.UID, func(s *state.Pod) {
+		s.Pod = pod.DeepCopy()
+	})
+	if err != nil && !errdefs.IsNotFound(err) {
+		p.log.Error("error while updating pod state", " pod ", key, " err ", err.Error())
+		return err
+	}
 	return p.Create(ctx, pod)
 }
 
@@ -257,22 +561,41 @@ func (p podman) Get(ctx context.Context, input *corev1.Pod) (pod *v1.Pod, err er
 }
 
 func (p podman) GetByName(ctx context.Context, name string) (pod *v1.Pod, err error) {
-	p.c.Lock()
-	_, err = iopodman.GetPod().Call(ctx, &p.c.Connection, name)
-	p.c.Unlock()
+	exists, err := p.b.PodExists(ctx, name)
 	if err != nil {
 		return nil, errors.VKError(err)
 	}
+	if !exists {
+		return nil, errors.VKError(&iopodman.PodNotFound{Name: name, Reason: "no such pod"})
+	}
 
-	p.c.Lock()
-	pPod, err := iopodman.InspectPod().Call(ctx, &p.c.Connection, name)
-	p.c.Unlock()
+	pPod, err := p.b.InspectPod(ctx, name)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(pPod) > 0 {
-		kpod, err := converter.GetKubePod(pPod)
+	if pPod != nil {
+		spec, err := p.kubePod(pPod)
+		if err != nil {
+			return nil, err
+		}
+		containers, err := p.inspectContainers(ctx, pPod)
+		if err != nil {
+			return nil, err
+		}
+		var infra *converter.PodmanContainerData
+		if pPod.State.InfraContainerID != "" {
+			infra, err = p.inspectContainer(ctx, pPod.State.InfraContainerID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		waiting := map[string]corev1.ContainerStateWaiting{}
+		p.waiting.Range(func(key, value interface{}) bool {
+			waiting[key.(string)] = value.(corev1.ContainerStateWaiting)
+			return true
+		})
+		kpod, err := converter.GetKubePod(spec, *pPod, containers, infra, p.hostIP, waiting)
 		if err != nil {
 			return nil, errors.VKError(err)
 		}
@@ -282,55 +605,88 @@ func (p podman) GetByName(ctx context.Context, name string) (pod *v1.Pod, err er
 
 }
 
-func (p podman) List(ctx context.Context) (podList *corev1.PodList, err error) {
-	p.c.Lock()
-	pPods, err := iopodman.ListPods().Call(ctx, &p.c.Connection)
-	p.c.Unlock()
+// podExists returns true if podman pod with the name exists
+func (p podman) podExists(ctx context.Context, name string) (bool, error) {
+	exists, err := p.b.PodExists(ctx, name)
 	if err != nil {
-		return nil, errors.VKError(err)
+		return false, errors.VKError(err)
 	}
+	return exists, nil
+}
 
-	kpodsList := &corev1.PodList{}
-	for _, podData := range pPods {
-		kpod, err := p.GetByName(ctx, podData.Name)
-		if err != nil {
-			return nil, errors.VKError(err)
-		}
-		kpodsList.Items = append(kpodsList.Items, *kpod)
+// containerExists returns true if podman container with the name exists
+func (p podman) containerExists(ctx context.Context, name string) (bool, error) {
+	exists, err := p.b.ContainerExists(ctx, name)
+	if err != nil {
+		return false, errors.VKError(err)
 	}
-
-	return kpodsList, nil
+	return exists, nil
 }
 
-// GetContainerStats return container status from pod name and namespace
-// TODO: Implement sum of rss
-func (p podman) GetPodStats(ctx context.Context, kPod *v1.Pod) (*stats.PodStats, error) {
-	name := converter.BuildKey(kPod)
-	p.c.Lock()
-	podmanJSON, err := iopodman.InspectPod().Call(ctx, &p.c.Connection, name)
-	p.c.Unlock()
+// inspectContainer returns inspect data of the container by name or ID
+func (p podman) inspectContainer(ctx context.Context, name string) (*converter.PodmanContainerData, error) {
+	container, err := p.b.InspectContainer(ctx, name)
 	if err != nil {
-		return nil, err
+		return nil, errors.VKError(err)
 	}
+	return container, nil
+}
 
-	pPod, err := converter.MarshalPodPod(podmanJSON)
+// waitContainer waits until container stops and returns its exit code
+func (p podman) waitContainer(ctx context.Context, name string) (int64, error) {
+	exitCode, err := p.b.WaitContainer(ctx, name)
 	if err != nil {
-		return nil, err
+		return 0, errors.VKError(err)
 	}
+	return exitCode, nil
+}
 
-	// TODO: When supporting multiple containers in the pod
-	// iterate here and return aggregate of rss
-	p.c.Lock()
-	stat, err := iopodman.GetContainerStats().Call(ctx, &p.c.Connection, pPod.Containers[0].ID)
-	p.c.Unlock()
+// inspectContainers returns inspect data of all containers in the pod,
+// except infra container
+func (p podman) inspectContainers(ctx context.Context, pPod *converter.PodmanPod) ([]converter.PodmanContainerData, error) {
+	var containers []converter.PodmanContainerData
+	for _, c := range pPod.Containers {
+		if c.ID == pPod.State.InfraContainerID {
+			continue
+		}
+		container, err := p.inspectContainer(ctx, c.ID)
+		if err != nil {
+			return nil, err
+		}
+		containers = append(containers, *container)
+	}
+	return containers, nil
+}
+
+func (p podman) List(ctx context.Context) (podList *corev1.PodList, err error) {
+	names, err := p.b.ListPods(ctx)
 	if err != nil {
 		return nil, errors.VKError(err)
 	}
 
-	time := metav1.NewTime(time.Now())
-	cpuUint64 := uint64(stat.Cpu_nano)
-	memUint64 := uint64(stat.Mem_usage)
+	kpodsList := &corev1.PodList{}
+	for _, name := range names {
+		kpod, err := p.GetByName(ctx, name)
+		if errdefs.IsNotFound(err) {
+			// removed meanwhile or not created by the provider
+			continue
+		}
+		if err != nil {
+			return nil, errors.VKError(err)
+		}
+		kpodsList.Items = append(kpodsList.Items, *kpod)
+	}
+
+	return kpodsList, nil
+}
 
+// GetPodStats returns stats of every container in the pod and their aggregate
+// as pod stats. Containers which are not running are not reported
+func (p podman) GetPodStats(ctx context.Context, kPod *v1.Pod) (*stats.PodStats, error) {
+	key := converter.BuildKey(kPod)
+	now := metav1.NewTime(time.Now())
+
+	var podCPUNanoCores, podCPUNanoSeconds, podMemory uint64
 	pss := &stats.PodStats{
 		PodRef: stats.PodReference{
 			Name:      kPod.Name,
@@ -338,28 +694,56 @@ func (p podman) GetPodStats(ctx context.Context, kPod *v1.Pod) (*stats.PodStats,
 			UID:       string(kPod.UID),
 		},
 		StartTime: kPod.CreationTimestamp,
-		// TODO: These should be aggregate all pods stats
-		CPU: &stats.CPUStats{
-			Time:           time,
-			UsageNanoCores: &cpuUint64,
-		},
-		Memory: &stats.MemoryStats{
-			Time:       time,
-			UsageBytes: &memUint64,
-		},
 	}
-	// TODO: These should be individual pods stats
-	pss.Containers[0] = stats.ContainerStats{
-		Name:      kPod.Spec.Containers[0].Name,
-		StartTime: kPod.CreationTimestamp,
-		CPU: &stats.CPUStats{
-			Time:           time,
-			UsageNanoCores: &cpuUint64,
-		},
-		Memory: &stats.MemoryStats{
-			Time:       time,
-			UsageBytes: &memUint64,
-		},
+
+	// every container of the pod is reported, containers which are not
+	// running with zero usage rather than missing
+	for _, c := range kPod.Spec.Containers {
+		var stat iopodman.ContainerStats
+		current, err := p.b.ContainerStats(ctx, converter.BuildContainerName(key, c.Name))
+		if err == nil {
+			stat = *current
+		} else {
+			switch err.(type) {
+			case *iopodman.NoContainerRunning, *iopodman.ContainerNotFound:
+			default:
+				return nil, errors.VKError(err)
+			}
+		}
+
+		// podman reports cpu as percentage of a single core
+		cpuNanoCores := uint64(stat.Cpu * 1e7)
+		cpuNanoSeconds := uint64(stat.Cpu_nano)
+		memory := uint64(stat.Mem_usage)
+		podCPUNanoCores += cpuNanoCores
+		podCPUNanoSeconds += cpuNanoSeconds
+		podMemory += memory
+
+		pss.Containers = append(pss.Containers, stats.ContainerStats{
+			Name:      c.Name,
+			StartTime: kPod.CreationTimestamp,
+			CPU: &stats.CPUStats{
+				Time:                 now,
+				UsageNanoCores:       &cpuNanoCores,
+				UsageCoreNanoSeconds: &cpuNanoSeconds,
+			},
+			Memory: &stats.MemoryStats{
+				Time:            now,
+				UsageBytes:      &memory,
+				WorkingSetBytes: &memory,
+			},
+		})
+	}
+
+	pss.CPU = &stats.CPUStats{
+		Time:                 now,
+		UsageNanoCores:       &podCPUNanoCores,
+		UsageCoreNanoSeconds: &podCPUNanoSeconds,
+	}
+	pss.Memory = &stats.MemoryStats{
+		Time:            now,
+		UsageBytes:      &podMemory,
+		WorkingSetBytes: &podMemory,
 	}
 
 	return pss, nil
@@ -0,0 +1,639 @@
+package podman
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	"go.uber.org/zap"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	utilexec "k8s.io/client-go/util/exec"
+	"k8s.io/kubernetes/pkg/credentialprovider"
+
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+	"github.com/virtual-kubelet/podman/pkg/podman/podmantest"
+	"github.com/virtual-kubelet/podman/pkg/state"
+	"github.com/virtual-kubelet/podman/pkg/util/errors"
+)
+
+const testImage = "docker.io/library/busybox:latest"
+
+// backends are podman APIs the tests run against, by the socket of the fake
+// server serving them
+var backends = []struct {
+	name   string
+	socket func(*podmantest.Server) string
+}{
+	{"varlink", func(s *podmantest.Server) string { return s.Socket }},
+	{"rest", func(s *podmantest.Server) string { return s.RESTSocket }},
+}
+
+// forEachBackend runs the test for every backend, with podman client
+// connected to fake podman server
+func forEachBackend(t *testing.T, test func(t *testing.T, p Podman, server *podmantest.Server)) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			p, server, cleanup := newTestPodman(t, backend.socket)
+			defer cleanup()
+			test(t, p, server)
+		})
+	}
+}
+
+// newTestPodman returns podman client connected to fake podman server over
+// the socket and function closing both
+func newTestPodman(t *testing.T, socket func(*podmantest.Server) string) (Podman, *podmantest.Server, func()) {
+	t.Helper()
+	server, err := podmantest.NewServer()
+	assert.NilError(t, err)
+
+	volumesDir, err := ioutil.TempDir("", "podman-volumes")
+	assert.NilError(t, err)
+	authFile := volumesDir + "/auth.json"
+	store, err := state.Open(volumesDir + "/state.db")
+	assert.NilError(t, err)
+	serverSocket := socket(server)
+	p, err := New(context.Background(), &Config{
+		Socket:      &serverSocket,
+		VolumesDir:  &volumesDir,
+		AuthFile:    &authFile,
+		CallTimeout: 5 * time.Second,
+		HostIP:      "192.168.1.10",
+		State:       store,
+		Recorder:    record.NewFakeRecorder(100),
+		Log:         zap.NewNop().Sugar(),
+	})
+	assert.NilError(t, err)
+	return p, server, func() {
+		p.Close()
+		store.Close()
+		server.Close()
+		os.RemoveAll(volumesDir)
+	}
+}
+
+func newTestPod(name string, containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID("uid-" + name),
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyAlways,
+		},
+	}
+	for _, c := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Name:    c,
+			Image:   testImage,
+			Command: []string{"sleep"},
+			Args:    []string{"3600"},
+			Env:     []corev1.EnvVar{{Name: "FOO", Value: "bar"}},
+		})
+	}
+	return pod
+}
+
+func TestCreateGetDelete(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		pod := newTestPod("web", "nginx", "sidecar")
+
+		assert.NilError(t, p.Create(ctx, pod))
+		assert.Assert(t, server.HasImage(testImage), "image should be pulled")
+
+		status, ok := server.ContainerStatus("default-web-nginx")
+		assert.Assert(t, ok)
+		assert.Equal(t, status, "running")
+		create, ok := server.Container("default-web-nginx")
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, create.Args, []string{testImage, "sleep", "3600"})
+		assert.Assert(t, is.Contains(*create.Env, "FOO=bar"))
+
+		kpod, err := p.Get(ctx, pod)
+		assert.NilError(t, err)
+		assert.Equal(t, kpod.Name, "web")
+		assert.Equal(t, kpod.Status.Phase, corev1.PodRunning)
+		assert.Equal(t, kpod.Status.HostIP, "192.168.1.10")
+		assert.Assert(t, kpod.Status.PodIP != "", "pod IP should be read from infra container")
+		assert.Assert(t, is.Len(kpod.Status.ContainerStatuses, 2))
+		for _, cs := range kpod.Status.ContainerStatuses {
+			assert.Assert(t, cs.State.Running != nil, "container %s should be running", cs.Name)
+		}
+
+		list, err := p.List(ctx)
+		assert.NilError(t, err)
+		assert.Assert(t, is.Len(list.Items, 1))
+
+		assert.NilError(t, p.Delete(ctx, pod))
+		_, err = p.Get(ctx, pod)
+		assert.Assert(t, errdefs.IsNotFound(err), "expected not found, got %v", err)
+		assert.Assert(t, is.Len(server.Pods(), 0))
+	})
+}
+
+func TestCreateResumesExistingPod(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		pod := newTestPod("web", "nginx")
+
+		// container create fails once, pod is left in podman
+		server.InjectFault("CreateContainer", podmantest.Fault{
+			Error: &iopodman.ErrorOccurred{Reason: "storage is busy"},
+			Times: 1,
+		})
+		err := p.Create(ctx, pod)
+		assert.ErrorContains(t, err, "storage is busy")
+		assert.Assert(t, is.Len(server.Pods(), 1))
+
+		assert.NilError(t, p.Create(ctx, pod))
+		assert.Equal(t, server.Calls("CreatePod"), 1)
+		status, _ := server.ContainerStatus("default-web-nginx")
+		assert.Equal(t, status, "running")
+	})
+}
+
+func TestLegacyPodLabels(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		pp := p.(podman)
+		pod := newTestPod("web", "nginx")
+
+		// pods created by older versions keep the whole pod in the label
+		data, err := yaml.Marshal(pod)
+		assert.NilError(t, err)
+		_, err = pp.b.CreatePod(ctx, iopodman.PodCreate{
+			Name:   "default-web",
+			Labels: map[string]string{"pod": base64.StdEncoding.EncodeToString(data)},
+			Infra:  true,
+		})
+		assert.NilError(t, err)
+		// pods not created by the provider are not listed
+		_, err = pp.b.CreatePod(ctx, iopodman.PodCreate{Name: "other", Infra: true})
+		assert.NilError(t, err)
+
+		list, err := p.List(ctx)
+		assert.NilError(t, err)
+		assert.Assert(t, is.Len(list.Items, 1))
+		assert.Equal(t, list.Items[0].UID, pod.UID)
+		stored, err := pp.state.Get(pod.UID)
+		assert.NilError(t, err)
+		// env is not kept in the state file
+		want := pod.DeepCopy()
+		want.Spec.Containers[0].Env = nil
+		assert.DeepEqual(t, stored.Pod.Spec, want.Spec)
+
+		assert.NilError(t, p.Create(ctx, pod))
+		status, _ := server.ContainerStatus("default-web-nginx")
+		assert.Equal(t, status, "running")
+
+		assert.NilError(t, p.Delete(ctx, pod))
+		_, err = pp.state.Get(pod.UID)
+		assert.Assert(t, errdefs.IsNotFound(err), "expected not found, got %v", err)
+	})
+}
+
+func TestContainerExit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		pod := newTestPod("job", "main")
+		pod.Spec.RestartPolicy = corev1.RestartPolicyNever
+
+		assert.NilError(t, p.Create(ctx, pod))
+		assert.NilError(t, server.Exit("default-job-main", 3))
+
+		kpod, err := p.Get(ctx, pod)
+		assert.NilError(t, err)
+		assert.Equal(t, kpod.Status.Phase, corev1.PodFailed)
+		terminated := kpod.Status.ContainerStatuses[0].State.Terminated
+		assert.Assert(t, terminated != nil)
+		assert.Equal(t, terminated.ExitCode, int32(3))
+		assert.Equal(t, terminated.Reason, "Error")
+	})
+}
+
+func TestInitContainers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		pod := newTestPod("app", "main")
+		pod.Spec.InitContainers = []corev1.Container{{Name: "init", Image: testImage}}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- p.Create(ctx, pod)
+		}()
+
+		// Create waits for init container to complete before starting the
+		// application container
+		waitFor(t, func() bool {
+			status, _ := server.ContainerStatus("default-app-init")
+			return status == "running"
+		})
+		status, _ := server.ContainerStatus("default-app-main")
+		assert.Equal(t, status, "configured")
+
+		assert.NilError(t, server.Exit("default-app-init", 0))
+		assert.NilError(t, <-done)
+		status, _ = server.ContainerStatus("default-app-main")
+		assert.Equal(t, status, "running")
+	})
+}
+
+func TestGetNotFound(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		_, err := p.Get(context.Background(), newTestPod("missing", "main"))
+		assert.Assert(t, errdefs.IsNotFound(err), "expected not found, got %v", err)
+	})
+}
+
+func TestPodStats(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		pod := newTestPod("web", "nginx", "sidecar")
+		assert.NilError(t, p.Create(ctx, pod))
+
+		server.SetStats("default-web-nginx", iopodman.ContainerStats{Cpu: 50, Cpu_nano: 2000, Mem_usage: 1024})
+		server.SetStats("default-web-sidecar", iopodman.ContainerStats{Cpu: 10, Cpu_nano: 1000, Mem_usage: 512})
+		assert.NilError(t, server.Exit("default-web-sidecar", 0))
+
+		stats, err := p.GetPodStats(ctx, pod)
+		assert.NilError(t, err)
+		// exited containers are reported with zero usage
+		assert.Assert(t, is.Len(stats.Containers, 2))
+		assert.Equal(t, stats.Containers[0].Name, "nginx")
+		assert.Equal(t, *stats.Containers[0].CPU.UsageNanoCores, uint64(5e8))
+		assert.Equal(t, *stats.Containers[0].Memory.WorkingSetBytes, uint64(1024))
+		assert.Equal(t, stats.Containers[1].Name, "sidecar")
+		assert.Equal(t, *stats.Containers[1].CPU.UsageNanoCores, uint64(0))
+		assert.Equal(t, *stats.Containers[1].CPU.UsageCoreNanoSeconds, uint64(0))
+		assert.Equal(t, *stats.Containers[1].Memory.UsageBytes, uint64(0))
+
+		// so are containers not created yet
+		withMissing := pod.DeepCopy()
+		withMissing.Spec.Containers = append(withMissing.Spec.Containers, corev1.Container{Name: "missing", Image: testImage})
+		stats, err = p.GetPodStats(ctx, withMissing)
+		assert.NilError(t, err)
+		assert.Assert(t, is.Len(stats.Containers, 3))
+		assert.Equal(t, stats.Containers[2].Name, "missing")
+		assert.Equal(t, *stats.Containers[2].Memory.UsageBytes, uint64(0))
+		assert.Equal(t, *stats.CPU.UsageNanoCores, uint64(5e8))
+		assert.Equal(t, *stats.CPU.UsageCoreNanoSeconds, uint64(2000))
+		assert.Equal(t, *stats.Memory.UsageBytes, uint64(1024))
+
+		usage, err := p.DiskUsage(ctx, pod)
+		assert.NilError(t, err)
+		assert.Assert(t, is.Len(usage, 2))
+	})
+}
+
+func TestContainerLogs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		pod := newTestPod("web", "nginx")
+		assert.NilError(t, p.Create(ctx, pod))
+
+		assert.NilError(t, server.Log("default-web-nginx", "one"))
+		assert.NilError(t, server.LogStderr("default-web-nginx", "two"))
+		assert.NilError(t, server.Log("default-web-nginx", "three"))
+
+		rc, err := p.GetContainerLogs(ctx, "default", "web", "nginx", ContainerLogOpts{Tail: 2})
+		assert.NilError(t, err)
+		out, err := ioutil.ReadAll(rc)
+		assert.NilError(t, err)
+		assert.NilError(t, rc.Close())
+		assert.Equal(t, string(out), "two\nthree\n")
+
+		rc, err = p.GetContainerLogs(ctx, "default", "web", "nginx", ContainerLogOpts{LimitBytes: 5})
+		assert.NilError(t, err)
+		out, err = ioutil.ReadAll(rc)
+		assert.NilError(t, err)
+		assert.NilError(t, rc.Close())
+		assert.Equal(t, string(out), "one\nt")
+
+		_, err = p.GetContainerLogs(ctx, "default", "web", "missing", ContainerLogOpts{})
+		assert.Assert(t, errdefs.IsNotFound(err), "expected not found, got %v", err)
+	})
+}
+
+func TestBackendErrors(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		b := p.(podman).b
+		assert.NilError(t, p.Create(ctx, newTestPod("web", "nginx")))
+
+		// missing objects are reported as podman errors of their kind, REST
+		// API reports them with status 404
+		_, err := b.InspectPod(ctx, "missing")
+		_, ok := err.(*iopodman.PodNotFound)
+		assert.Assert(t, ok, "expected pod not found, got %v", err)
+		_, err = b.InspectContainer(ctx, "missing")
+		_, ok = err.(*iopodman.ContainerNotFound)
+		assert.Assert(t, ok, "expected container not found, got %v", err)
+		err = b.CreateContainer(ctx, iopodman.Create{Args: []string{"docker.io/library/missing:latest"}})
+		_, ok = err.(*iopodman.ImageNotFound)
+		assert.Assert(t, ok, "expected image not found, got %v", err)
+
+		exists, err := b.PodExists(ctx, "missing")
+		assert.NilError(t, err)
+		assert.Assert(t, !exists)
+		exists, err = b.ContainerExists(ctx, "default-web-nginx")
+		assert.NilError(t, err)
+		assert.Assert(t, exists)
+		exists, err = b.ImageExists(ctx, "docker.io/library/missing:latest")
+		assert.NilError(t, err)
+		assert.Assert(t, !exists)
+
+		// REST API reports stats of stopped containers with status 409
+		assert.NilError(t, server.Exit("default-web-nginx", 0))
+		_, err = b.ContainerStats(ctx, "default-web-nginx")
+		_, ok = err.(*iopodman.NoContainerRunning)
+		assert.Assert(t, ok, "expected no container running, got %v", err)
+
+		server.InjectFault("StartContainer", podmantest.Fault{
+			Error: &iopodman.ErrorOccurred{Reason: "storage is busy"},
+			Times: 1,
+		})
+		err = b.StartContainer(ctx, "default-web-nginx")
+		occurred, ok := err.(*iopodman.ErrorOccurred)
+		assert.Assert(t, ok, "expected error occurred, got %v", err)
+		assert.Equal(t, occurred.Reason, "storage is busy")
+	})
+}
+
+type testAttach struct {
+	stdout, stderr bytes.Buffer
+}
+
+func (a *testAttach) Stdin() io.Reader            { return nil }
+func (a *testAttach) Stdout() io.WriteCloser      { return nopCloser{&a.stdout} }
+func (a *testAttach) Stderr() io.WriteCloser      { return nopCloser{&a.stderr} }
+func (a *testAttach) TTY() bool                   { return false }
+func (a *testAttach) Resize() <-chan api.TermSize { return nil }
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestExec(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		pod := newTestPod("web", "nginx")
+		assert.NilError(t, p.Create(ctx, pod))
+
+		server.SetExec(func(container string, cmd []string) (string, string, int) {
+			if cmd[0] == "false" {
+				return "", "failed\n", 1
+			}
+			return container + "\n", "", 0
+		})
+
+		attach := &testAttach{}
+		assert.NilError(t, p.ExecInContainer(ctx, "default", "web", "nginx", []string{"hostname"}, attach))
+		assert.Equal(t, attach.stdout.String(), "default-web-nginx\n")
+
+		attach = &testAttach{}
+		err := p.ExecInContainer(ctx, "default", "web", "nginx", []string{"false"}, attach)
+		exitErr, ok := err.(utilexec.CodeExitError)
+		assert.Assert(t, ok, "expected exit error, got %v", err)
+		assert.Equal(t, exitErr.Code, 1)
+		assert.Equal(t, attach.stderr.String(), "failed\n")
+	})
+}
+
+func TestWatchEvents(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		pod := newTestPod("web", "nginx")
+
+		events := make(chan Event, 100)
+		watchDone := make(chan error, 1)
+		go func() {
+			watchDone <- p.WatchEvents(ctx, func(e Event) { events <- e })
+		}()
+		// events are only sent to watchers connected before they happen
+		waitFor(t, func() bool { return server.Calls("GetEvents") > 0 })
+		time.Sleep(50 * time.Millisecond)
+
+		assert.NilError(t, p.Create(ctx, pod))
+		assert.NilError(t, server.Exit("default-web-nginx", 1))
+
+		want := Event{Type: EventTypeContainer, Status: EventDied, Name: "default-web-nginx"}
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case e := <-events:
+				if e == want {
+					cancel()
+					assert.Equal(t, <-watchDone, context.Canceled)
+					return
+				}
+			case <-timeout:
+				t.Fatalf("event %v not received", want)
+			}
+		}
+	})
+}
+
+func TestTransientErrors(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		pod := newTestPod("web", "nginx")
+		assert.NilError(t, p.Create(ctx, pod))
+
+		// reads are retried over new connections
+		server.InjectFault("InspectPod", podmantest.Fault{Drop: true, Times: 1})
+		_, err := p.Get(ctx, pod)
+		assert.NilError(t, err)
+		assert.Equal(t, server.Calls("InspectPod"), 3)
+
+		// other calls are not retried, failure is reported as transient
+		server.InjectFault("RemovePod", podmantest.Fault{Drop: true, Times: 1})
+		err = p.Delete(ctx, pod)
+		assert.Assert(t, errors.IsTransient(err), "expected transient error, got %v", err)
+		assert.NilError(t, p.Delete(ctx, pod))
+	})
+}
+
+func TestPoolLanes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		b := p.(podman).b
+		server.InjectFault("PullImage", podmantest.Fault{Delay: 300 * time.Millisecond})
+
+		// long lane runs as many pulls at once as it has slots, the rest
+		// wait for them
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < defaultPoolSize+1; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.Check(t, b.PullImage(ctx, testImage, nil))
+			}()
+		}
+
+		// short calls don't wait for long ones
+		time.Sleep(50 * time.Millisecond)
+		shortStart := time.Now()
+		_, err := b.PodExists(ctx, "web")
+		assert.NilError(t, err)
+		assert.Assert(t, time.Since(shortStart) < 200*time.Millisecond)
+
+		wg.Wait()
+		assert.Assert(t, time.Since(start) >= 600*time.Millisecond)
+		assert.Equal(t, server.Calls("PullImage"), defaultPoolSize+1)
+	})
+}
+
+func TestWaitContainerTransient(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		pod := newTestPod("web", "nginx")
+		assert.NilError(t, p.Create(ctx, pod))
+		b := p.(podman).b
+
+		server.InjectFault("WaitContainer", podmantest.Fault{Drop: true, Times: 1})
+		_, err := b.WaitContainer(ctx, "default-web-nginx")
+		assert.Assert(t, errors.IsTransient(err), "expected transient error, got %v", err)
+
+		_, err = b.WaitContainer(ctx, "default-web-missing")
+		_, notFound := err.(*iopodman.ContainerNotFound)
+		assert.Assert(t, notFound, "expected container not found, got %v", err)
+
+		assert.NilError(t, server.Exit("default-web-nginx", 3))
+		exitCode, err := b.WaitContainer(ctx, "default-web-nginx")
+		assert.NilError(t, err)
+		assert.Equal(t, exitCode, int64(3))
+	})
+}
+
+func TestImagePullFailure(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		pod := newTestPod("web", "nginx")
+
+		server.InjectFault("PullImage", podmantest.Fault{
+			Error: &iopodman.ErrorOccurred{Reason: "manifest unknown"},
+		})
+		err := p.Create(ctx, pod)
+		assert.Assert(t, IsImagePullError(err), "expected image pull error, got %v", err)
+
+		kpod, err := p.Get(ctx, pod)
+		assert.NilError(t, err)
+		waiting := kpod.Status.ContainerStatuses[0].State.Waiting
+		assert.Assert(t, waiting != nil)
+		assert.Assert(t, IsImagePullReason(waiting.Reason), "unexpected reason %s", waiting.Reason)
+	})
+}
+
+func TestParallelPulls(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, p Podman, server *podmantest.Server) {
+		ctx := context.Background()
+		b := p.(podman).b
+		server.InjectFault("PullImage", podmantest.Fault{Delay: 300 * time.Millisecond})
+
+		// anonymous pulls don't wait for each other
+		start := time.Now()
+		var wg sync.WaitGroup
+		for _, image := range []string{"docker.io/library/nginx:1", "docker.io/library/redis:5"} {
+			wg.Add(1)
+			go func(image string) {
+				defer wg.Done()
+				assert.Check(t, b.PullImage(ctx, image, nil))
+			}(image)
+		}
+		wg.Wait()
+		assert.Assert(t, time.Since(start) < 550*time.Millisecond, "pulls took %v", time.Since(start))
+		assert.Equal(t, server.Calls("PullImage"), 2)
+	})
+}
+
+func TestAuthFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "podman-auth")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	authFile := filepath.Join(dir, "auth.json")
+	b := &varlinkBackend{authFile: authFile, authMu: &sync.RWMutex{}, log: zap.NewNop().Sugar()}
+	cred := credentialprovider.AuthConfig{Username: "user", Password: "secret"}
+	readAuths := func(path string) map[string]map[string]string {
+		config := struct {
+			Auths map[string]map[string]string `json:"auths"`
+		}{}
+		data, err := ioutil.ReadFile(path)
+		assert.NilError(t, err)
+		assert.NilError(t, json.Unmarshal(data, &config))
+		return config.Auths
+	}
+	login := map[string]string{"auth": "bG9naW46cGFzcw=="}
+
+	// file not existing before the pull is removed afterwards
+	assert.NilError(t, b.withAuthFile("registry.example.com", cred, func() error {
+		assert.Equal(t, readAuths(authFile)["registry.example.com"]["auth"], "dXNlcjpzZWNyZXQ=")
+		return nil
+	}))
+	_, err = os.Stat(authFile)
+	assert.Assert(t, os.IsNotExist(err), "expected auth file to be removed, got %v", err)
+
+	// podman login during the pull is kept
+	assert.NilError(t, b.withAuthFile("registry.example.com", cred, func() error {
+		data, err := json.Marshal(map[string]interface{}{"auths": map[string]interface{}{"registry.example.com": login}})
+		assert.NilError(t, err)
+		return ioutil.WriteFile(authFile, data, 0600)
+	}))
+	assert.DeepEqual(t, readAuths(authFile), map[string]map[string]string{"registry.example.com": login})
+
+	// credential left by pull interrupted by crash is removed by the next
+	// restore, existing login is brought back
+	err = b.withAuthFile("registry.example.com", cred, func() error {
+		data, err := ioutil.ReadFile(authFile)
+		assert.NilError(t, err)
+		restore, err := ioutil.ReadFile(authFile + ".restore")
+		assert.NilError(t, err)
+		crashed := &varlinkBackend{authFile: filepath.Join(dir, "crashed.json"), log: b.log}
+		assert.NilError(t, ioutil.WriteFile(crashed.authFile, data, 0600))
+		assert.NilError(t, ioutil.WriteFile(crashed.restoreFile(), restore, 0600))
+		crashed.restoreAuthFile()
+		assert.DeepEqual(t, readAuths(crashed.authFile), map[string]map[string]string{"registry.example.com": login})
+		_, err = os.Stat(crashed.restoreFile())
+		assert.Assert(t, os.IsNotExist(err), "expected restore file to be removed, got %v", err)
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, readAuths(authFile), map[string]map[string]string{"registry.example.com": login})
+
+	// auth file of remote host can't be written
+	b.tunnel = &sshTunnel{}
+	err = b.PullImage(context.Background(), "registry.example.com/app", &cred)
+	assert.ErrorContains(t, err, "not supported for podman of remote host")
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
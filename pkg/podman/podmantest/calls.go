@@ -0,0 +1,395 @@
+package podmantest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+)
+
+// Calls of podman API on top of Server state, shared by varlink and REST
+// API. Failures are returned as iopodman errors, which either API replies
+// as errors of the same meaning
+
+func podNotFound(name string) error {
+	return &iopodman.PodNotFound{Name: name, Reason: "no such pod"}
+}
+
+func containerNotFound(name string) error {
+	return &iopodman.ContainerNotFound{Id: name, Reason: "no such container"}
+}
+
+func errorOccurred(format string, args ...interface{}) error {
+	return &iopodman.ErrorOccurred{Reason: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) podCreate(create iopodman.PodCreate) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if create.Name != "" && s.pod(create.Name) != nil {
+		return "", errorOccurred("pod %s already exists", create.Name)
+	}
+	p := &pod{
+		id:      newID(),
+		name:    create.Name,
+		create:  create,
+		created: time.Now(),
+	}
+	if p.name == "" {
+		p.name = p.id[:12]
+	}
+	if create.Infra {
+		infra := &container{
+			id:      newID(),
+			podID:   p.id,
+			image:   infraImage,
+			infra:   true,
+			created: p.created,
+			status:  statusConfigured,
+		}
+		infra.name = infra.id[:12] + "-infra"
+		s.containers[infra.id] = infra
+		p.infraID = infra.id
+		p.containers = append(p.containers, infra.id)
+	}
+	s.pods[p.id] = p
+	s.emit(iopodman.Event{Type: "pod", Status: "create", Id: p.id, Name: p.name})
+	return p.id, nil
+}
+
+func (s *Server) podGet(name string) (iopodman.ListPodData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.pod(name)
+	if p == nil {
+		return iopodman.ListPodData{}, podNotFound(name)
+	}
+	return s.listPodData(p), nil
+}
+
+func (s *Server) podList() []iopodman.ListPodData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pods := []iopodman.ListPodData{}
+	for _, p := range s.pods {
+		pods = append(pods, s.listPodData(p))
+	}
+	return pods
+}
+
+func (s *Server) podInspect(name string) (*converter.PodmanPod, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.pod(name)
+	if p == nil {
+		return nil, podNotFound(name)
+	}
+	data, err := s.inspectPod(p)
+	if err != nil {
+		return nil, errorOccurred("%v", err)
+	}
+	return data, nil
+}
+
+func (s *Server) podStart(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.pod(name)
+	if p == nil {
+		return "", podNotFound(name)
+	}
+	for _, id := range p.containers {
+		if ctr := s.containers[id]; ctr.status != statusRunning {
+			s.startContainer(ctr)
+		}
+	}
+	return p.id, nil
+}
+
+func (s *Server) podStop(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.pod(name)
+	if p == nil {
+		return "", podNotFound(name)
+	}
+	for _, id := range p.containers {
+		if ctr := s.containers[id]; ctr.status == statusRunning {
+			s.stopContainer(ctr, stopExitCode)
+		}
+	}
+	return p.id, nil
+}
+
+func (s *Server) podRemove(name string, force bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.pod(name)
+	if p == nil {
+		return "", podNotFound(name)
+	}
+	if !force && s.podStatus(p) == "Running" {
+		return "", errorOccurred("pod %s has running containers", p.name)
+	}
+	for _, id := range p.containers {
+		s.removeContainer(s.containers[id])
+	}
+	delete(s.pods, p.id)
+	s.emit(iopodman.Event{Type: "pod", Status: "remove", Id: p.id, Name: p.name})
+	return p.id, nil
+}
+
+func (s *Server) containerCreate(create iopodman.Create) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(create.Args) == 0 {
+		return "", errorOccurred("container image is not set")
+	}
+	image := create.Args[0]
+	if !s.images[image] {
+		return "", &iopodman.ImageNotFound{Id: image, Reason: "no such image"}
+	}
+	ctr := &container{
+		id:      newID(),
+		image:   image,
+		create:  create,
+		created: time.Now(),
+		status:  statusConfigured,
+	}
+	ctr.name = ctr.id[:12]
+	if create.Name != nil && *create.Name != "" {
+		ctr.name = *create.Name
+	}
+	if s.container(ctr.name) != nil {
+		return "", errorOccurred("container %s already exists", ctr.name)
+	}
+	if create.Pod != nil && *create.Pod != "" {
+		p := s.pod(*create.Pod)
+		if p == nil {
+			return "", podNotFound(*create.Pod)
+		}
+		ctr.podID = p.id
+		p.containers = append(p.containers, ctr.id)
+	}
+	s.containers[ctr.id] = ctr
+	s.emit(iopodman.Event{Type: "container", Status: "create", Id: ctr.id, Name: ctr.name, Image: ctr.image})
+	return ctr.id, nil
+}
+
+func (s *Server) containerExists(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.container(name) != nil
+}
+
+func (s *Server) containerInspect(name string) (*converter.PodmanContainerData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctr := s.container(name)
+	if ctr == nil {
+		return nil, containerNotFound(name)
+	}
+	return s.inspectContainer(ctr), nil
+}
+
+func (s *Server) containerGet(name string) (iopodman.Container, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctr := s.container(name)
+	if ctr == nil {
+		return iopodman.Container{}, containerNotFound(name)
+	}
+	return iopodman.Container{
+		Id:               ctr.id,
+		Image:            ctr.image,
+		Names:            ctr.name,
+		Createdat:        ctr.created.Format(time.RFC3339Nano),
+		Status:           ctr.status,
+		Rwsize:           rwSize,
+		Containerrunning: ctr.status == statusRunning,
+	}, nil
+}
+
+func (s *Server) containerStart(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctr := s.container(name)
+	if ctr == nil {
+		return "", containerNotFound(name)
+	}
+	if ctr.status != statusRunning {
+		s.startContainer(ctr)
+	}
+	return ctr.id, nil
+}
+
+func (s *Server) containerStop(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctr := s.container(name)
+	if ctr == nil {
+		return "", containerNotFound(name)
+	}
+	if ctr.status == statusRunning {
+		s.stopContainer(ctr, stopExitCode)
+	}
+	return ctr.id, nil
+}
+
+// containerWait polls container status every interval, the same as podman
+// does, until the container exits
+func (s *Server) containerWait(ctx context.Context, name string, every time.Duration) (int, error) {
+	if every < minWaitInterval {
+		every = minWaitInterval
+	}
+	for {
+		s.mu.Lock()
+		ctr := s.container(name)
+		if ctr == nil {
+			s.mu.Unlock()
+			return 0, containerNotFound(name)
+		}
+		status, exitCode := ctr.status, ctr.exitCode
+		s.mu.Unlock()
+		if status == statusExited {
+			return exitCode, nil
+		}
+
+		select {
+		case <-time.After(every):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-s.done:
+			return 0, fmt.Errorf("server closed")
+		}
+	}
+}
+
+func (s *Server) containerStats(name string) (iopodman.ContainerStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctr := s.container(name)
+	if ctr == nil {
+		return iopodman.ContainerStats{}, containerNotFound(name)
+	}
+	if ctr.status != statusRunning {
+		return iopodman.ContainerStats{}, &iopodman.NoContainerRunning{}
+	}
+	stats := s.stats[ctr.name]
+	stats.Id = ctr.id
+	stats.Name = ctr.name
+	return stats, nil
+}
+
+// containerLogs sends log lines of the container not older than since,
+// limited to the last tail lines if tail is set. The first batch is sent,
+// possibly empty, once the container is found. Followed logs are sent in
+// further batches until the container exits
+func (s *Server) containerLogs(ctx context.Context, name string, follow bool, since time.Time, tail int64, send func([]iopodman.LogLine) error) error {
+	s.mu.Lock()
+	ctr := s.container(name)
+	if ctr == nil {
+		s.mu.Unlock()
+		return containerNotFound(name)
+	}
+	var lines []iopodman.LogLine
+	for _, line := range ctr.logs {
+		if t, err := time.Parse(time.RFC3339Nano, line.Time); err == nil && t.Before(since) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if tail > 0 && int64(len(lines)) > tail {
+		lines = lines[int64(len(lines))-tail:]
+	}
+	sent := len(ctr.logs)
+	s.mu.Unlock()
+
+	for {
+		if err := send(lines); err != nil {
+			return err
+		}
+		if !follow {
+			return nil
+		}
+
+		select {
+		case <-time.After(minWaitInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.done:
+			return fmt.Errorf("server closed")
+		}
+		s.mu.Lock()
+		ctr := s.container(name)
+		if ctr == nil {
+			s.mu.Unlock()
+			return nil
+		}
+		lines = append([]iopodman.LogLine{}, ctr.logs[sent:]...)
+		sent = len(ctr.logs)
+		running := ctr.status == statusRunning
+		s.mu.Unlock()
+		if !running && len(lines) == 0 {
+			return nil
+		}
+	}
+}
+
+// containerExec returns the exec function to run in the running container
+func (s *Server) containerExec(name string) (ExecIOFunc, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctr := s.container(name)
+	if ctr == nil {
+		return nil, containerNotFound(name)
+	}
+	if ctr.status != statusRunning {
+		return nil, errorOccurred("container %s is not running", name)
+	}
+	return s.exec, nil
+}
+
+func (s *Server) imageExists(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.images[name]
+}
+
+// imagePull makes any image available. Failed pulls are injected as faults
+func (s *Server) imagePull(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.images[name] = true
+	s.emit(iopodman.Event{Type: "image", Status: "pull", Name: name})
+}
+
+// watchEvents registers event watcher. The returned function unregisters it
+func (s *Server) watchEvents() (<-chan iopodman.Event, func()) {
+	events := make(chan iopodman.Event, 100)
+	s.mu.Lock()
+	s.watchers[events] = struct{}{}
+	s.mu.Unlock()
+	return events, func() {
+		s.mu.Lock()
+		delete(s.watchers, events)
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,860 @@
+package podmantest
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+)
+
+// Stream identifiers of multiplexed REST API streams
+const (
+	restStreamStdout byte = 1
+	restStreamStderr byte = 2
+)
+
+// restPath matches paths of libpod REST API of any version
+var restPath = regexp.MustCompile(`^/v[0-9.]+/libpod(/.*)$`)
+
+// restAPI serves libpod REST API calls used by the provider on top of Server
+// state. Calls are counted, and faults applied, under the names of varlink
+// methods of the same meaning, so tests inject faults the same way for
+// either API
+type restAPI struct {
+	s *Server
+
+	mu    sync.Mutex
+	execs map[string]*restExec
+}
+
+// restExec is exec session created by exec call and run by start call
+type restExec struct {
+	container string
+	cmd       []string
+	stdin     bool
+	tty       bool
+	exitCode  int
+	running   bool
+}
+
+// restPodSpec is pod spec generator sent by the client
+type restPodSpec struct {
+	Name             string            `json:"name"`
+	Labels           map[string]string `json:"labels"`
+	CgroupParent     string            `json:"cgroup_parent"`
+	SharedNamespaces []string          `json:"shared_namespaces"`
+	NoInfra          bool              `json:"no_infra"`
+	InfraCommand     []string          `json:"infra_command"`
+	InfraImage       string            `json:"infra_image"`
+	PortMappings     []struct {
+		HostIP        string `json:"host_ip"`
+		ContainerPort uint16 `json:"container_port"`
+		HostPort      uint16 `json:"host_port"`
+		Protocol      string `json:"protocol"`
+	} `json:"portmappings"`
+}
+
+// restContainerSpec is container spec generator sent by the client
+type restContainerSpec struct {
+	Name       string            `json:"name"`
+	Pod        string            `json:"pod"`
+	Image      string            `json:"image"`
+	Entrypoint []string          `json:"entrypoint"`
+	Command    []string          `json:"command"`
+	Env        map[string]string `json:"env"`
+	Privileged bool              `json:"privileged"`
+	Terminal   bool              `json:"terminal"`
+	NetNS      *struct {
+		NSMode string `json:"nsmode"`
+	} `json:"netns"`
+	Mounts []struct {
+		Destination string   `json:"destination"`
+		Source      string   `json:"source"`
+		Options     []string `json:"options"`
+	} `json:"mounts"`
+	Resources *struct {
+		CPU *struct {
+			Shares *uint64 `json:"shares"`
+			Quota  *int64  `json:"quota"`
+			Period *uint64 `json:"period"`
+		} `json:"cpu"`
+		Memory *struct {
+			Limit *int64 `json:"limit"`
+			Swap  *int64 `json:"swap"`
+		} `json:"memory"`
+	} `json:"resource_limits"`
+	OOMScoreAdj  *int64 `json:"oom_score_adj"`
+	HealthConfig *struct {
+		Test        []string      `json:"Test"`
+		Interval    time.Duration `json:"Interval"`
+		Timeout     time.Duration `json:"Timeout"`
+		StartPeriod time.Duration `json:"StartPeriod"`
+		Retries     int64         `json:"Retries"`
+	} `json:"healthconfig"`
+}
+
+func (a *restAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m := restPath.FindStringSubmatch(r.URL.EscapedPath())
+	if m == nil {
+		writeError(w, http.StatusNotFound, "no such endpoint")
+		return
+	}
+	var path []string
+	for _, segment := range strings.Split(strings.Trim(m[1], "/"), "/") {
+		segment, err := url.PathUnescape(segment)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		path = append(path, segment)
+	}
+
+	get, post := r.Method == http.MethodGet, r.Method == http.MethodPost
+	switch {
+	case get && match(path, "_ping"):
+		w.Write([]byte("OK")) //nolint:errcheck
+	case post && match(path, "pods", "create"):
+		a.createPod(w, r)
+	case get && match(path, "pods", "json"):
+		a.listPods(w)
+	case get && match(path, "pods", "*", "exists"):
+		a.podExists(w, path[1])
+	case get && match(path, "pods", "*", "json"):
+		a.inspectPod(w, path[1])
+	case post && match(path, "pods", "*", "stop"):
+		a.stopPod(w, path[1])
+	case r.Method == http.MethodDelete && match(path, "pods", "*"):
+		a.removePod(w, r, path[1])
+	case post && match(path, "containers", "create"):
+		a.createContainer(w, r)
+	case get && match(path, "containers", "stats"):
+		a.containerStats(w, r)
+	case get && match(path, "containers", "*", "exists"):
+		a.containerExists(w, path[1])
+	case get && match(path, "containers", "*", "json"):
+		a.inspectContainer(w, r, path[1])
+	case post && match(path, "containers", "*", "start"):
+		a.startContainer(w, path[1])
+	case post && match(path, "containers", "*", "stop"):
+		a.stopContainer(w, path[1])
+	case post && match(path, "containers", "*", "wait"):
+		a.waitContainer(w, r, path[1])
+	case get && match(path, "containers", "*", "logs"):
+		a.containerLogs(w, r, path[1])
+	case post && match(path, "containers", "*", "exec"):
+		a.createExec(w, r, path[1])
+	case post && match(path, "exec", "*", "start"):
+		a.startExec(w, r, path[1])
+	case get && match(path, "exec", "*", "json"):
+		a.inspectExec(w, path[1])
+	case post && match(path, "exec", "*", "resize"):
+		a.resizeExec(w, r)
+	case get && match(path, "images", "*", "exists"):
+		a.imageExists(w, path[1])
+	case post && match(path, "images", "pull"):
+		a.pullImage(w, r)
+	case get && match(path, "events"):
+		a.events(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "no such endpoint")
+	}
+}
+
+// match returns true if path segments match the pattern, "*" matches any
+// segment
+func match(path []string, pattern ...string) bool {
+	if len(path) != len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != "*" && p != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// fault counts the call and applies fault injected for the method. It
+// returns true if the call is handled by the fault
+func (a *restAPI) fault(w http.ResponseWriter, method string) bool {
+	fault, ok := a.s.takeFault(method)
+	if !ok {
+		return false
+	}
+	switch {
+	case fault.Drop:
+		drop(w)
+		return true
+	case fault.Error != nil:
+		replyStatus(w, fault.Error)
+		return true
+	}
+	return false
+}
+
+// drop closes the connection in the middle of the reply, as when podman
+// service crashes. Partial reply keeps HTTP client from resending the
+// request on its own
+func drop(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	conn.Write([]byte("HTTP/1.1 500")) //nolint:errcheck
+	conn.Close()
+}
+
+// replyStatus replies iopodman error with status code of the same meaning,
+// missing objects with 404 and stopped containers with 409
+func replyStatus(w http.ResponseWriter, err error) {
+	v := reflect.Indirect(reflect.ValueOf(err))
+	if v.Kind() != reflect.Struct || v.Type().PkgPath() != reflect.TypeOf(iopodman.ErrorOccurred{}).PkgPath() {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	msg := err.Error()
+	if reason := v.FieldByName("Reason"); reason.IsValid() {
+		msg = reason.String()
+	}
+	switch v.Type().Name() {
+	case "PodNotFound", "ContainerNotFound", "ImageNotFound":
+		writeError(w, http.StatusNotFound, msg)
+	case "NoContainerRunning":
+		writeError(w, http.StatusConflict, "container is not running")
+	default:
+		writeError(w, http.StatusInternalServerError, msg)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, struct {
+		Cause    string `json:"cause"`
+		Message  string `json:"message"`
+		Response int    `json:"response"`
+	}{msg, msg, status})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint:errcheck
+}
+
+// writeFrame writes frame of multiplexed stream and flushes it to the client
+func writeFrame(w http.ResponseWriter, stream byte, data string) error {
+	frame := make([]byte, 8, 8+len(data))
+	frame[0] = stream
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(data)))
+	frame = append(frame, data...)
+	if _, err := w.Write(frame); err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
+
+// exists replies existence of object with status code only
+func exists(w http.ResponseWriter, found bool) {
+	if !found {
+		writeError(w, http.StatusNotFound, "no such object")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *restAPI) createPod(w http.ResponseWriter, r *http.Request) {
+	if a.fault(w, "CreatePod") {
+		return
+	}
+	var spec restPodSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	create := iopodman.PodCreate{
+		Name:         spec.Name,
+		Labels:       spec.Labels,
+		CgroupParent: spec.CgroupParent,
+		Share:        spec.SharedNamespaces,
+		Infra:        !spec.NoInfra,
+		InfraCommand: strings.Join(spec.InfraCommand, " "),
+		InfraImage:   spec.InfraImage,
+	}
+	for _, mapping := range spec.PortMappings {
+		publish := fmt.Sprintf("%d:%d", mapping.HostPort, mapping.ContainerPort)
+		if mapping.HostIP != "" {
+			publish = mapping.HostIP + ":" + publish
+		}
+		if mapping.Protocol != "" {
+			publish += "/" + mapping.Protocol
+		}
+		create.Publish = append(create.Publish, publish)
+	}
+	id, err := a.s.podCreate(create)
+	if err != nil {
+		replyStatus(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		ID string `json:"Id"`
+	}{id})
+}
+
+func (a *restAPI) listPods(w http.ResponseWriter) {
+	if a.fault(w, "ListPods") {
+		return
+	}
+	type listPod struct {
+		ID   string `json:"Id"`
+		Name string `json:"Name"`
+	}
+	pods := []listPod{}
+	for _, pod := range a.s.podList() {
+		pods = append(pods, listPod{pod.Id, pod.Name})
+	}
+	writeJSON(w, http.StatusOK, pods)
+}
+
+func (a *restAPI) podExists(w http.ResponseWriter, name string) {
+	if a.fault(w, "GetPod") {
+		return
+	}
+	_, err := a.s.podGet(name)
+	exists(w, err == nil)
+}
+
+func (a *restAPI) inspectPod(w http.ResponseWriter, name string) {
+	if a.fault(w, "InspectPod") {
+		return
+	}
+	data, err := a.s.podInspect(name)
+	if err != nil {
+		replyStatus(w, err)
+		return
+	}
+	type podContainer struct {
+		ID    string `json:"Id"`
+		State string `json:"State"`
+	}
+	containers := []podContainer{}
+	for _, c := range data.Containers {
+		containers = append(containers, podContainer{c.ID, c.State})
+	}
+	writeJSON(w, http.StatusOK, struct {
+		ID               string            `json:"Id"`
+		Name             string            `json:"Name"`
+		Created          time.Time         `json:"Created"`
+		Labels           map[string]string `json:"Labels"`
+		CgroupParent     string            `json:"CgroupParent"`
+		InfraContainerID string            `json:"InfraContainerID"`
+		Containers       []podContainer    `json:"Containers"`
+	}{
+		ID:               data.Config.ID,
+		Name:             data.Config.Name,
+		Created:          data.Config.Created,
+		Labels:           data.Config.Labels,
+		CgroupParent:     data.Config.CgroupParent,
+		InfraContainerID: data.State.InfraContainerID,
+		Containers:       containers,
+	})
+}
+
+func (a *restAPI) stopPod(w http.ResponseWriter, name string) {
+	if a.fault(w, "StopPod") {
+		return
+	}
+	id, err := a.s.podStop(name)
+	if err != nil {
+		replyStatus(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		ID string `json:"Id"`
+	}{id})
+}
+
+func (a *restAPI) removePod(w http.ResponseWriter, r *http.Request, name string) {
+	if a.fault(w, "RemovePod") {
+		return
+	}
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	id, err := a.s.podRemove(name, force)
+	if err != nil {
+		replyStatus(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		ID string `json:"Id"`
+	}{id})
+}
+
+// createContainer converts the spec to varlink create options, so tests
+// read create options of containers the same way for either API
+func (a *restAPI) createContainer(w http.ResponseWriter, r *http.Request) {
+	if a.fault(w, "CreateContainer") {
+		return
+	}
+	var spec restContainerSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	create, err := spec.create()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := a.s.containerCreate(create)
+	if err != nil {
+		replyStatus(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		ID       string   `json:"Id"`
+		Warnings []string `json:"Warnings"`
+	}{id, []string{}})
+}
+
+func (spec *restContainerSpec) create() (iopodman.Create, error) {
+	if spec.Image == "" {
+		return iopodman.Create{}, fmt.Errorf("container image is not set")
+	}
+	create := iopodman.Create{
+		Args:        append(append([]string{spec.Image}, spec.Entrypoint...), spec.Command...),
+		Name:        &spec.Name,
+		Pod:         &spec.Pod,
+		Privileged:  &spec.Privileged,
+		Tty:         &spec.Terminal,
+		OomScoreAdj: spec.OOMScoreAdj,
+	}
+	if len(spec.Entrypoint) > 0 {
+		create.Command = &spec.Entrypoint
+	}
+	if len(spec.Env) > 0 {
+		env := make([]string, 0, len(spec.Env))
+		for k, v := range spec.Env {
+			env = append(env, k+"="+v)
+		}
+		sort.Strings(env)
+		create.Env = &env
+	}
+	if spec.NetNS != nil {
+		create.Net = &spec.NetNS.NSMode
+	}
+	if len(spec.Mounts) > 0 {
+		volumes := make([]string, 0, len(spec.Mounts))
+		for _, mount := range spec.Mounts {
+			volume := mount.Source + ":" + mount.Destination
+			var options []string
+			for _, option := range mount.Options {
+				if option != "rbind" {
+					options = append(options, option)
+				}
+			}
+			if len(options) > 0 {
+				volume += ":" + strings.Join(options, ",")
+			}
+			volumes = append(volumes, volume)
+		}
+		create.Volume = &volumes
+	}
+	if resources := spec.Resources; resources != nil {
+		if cpu := resources.CPU; cpu != nil {
+			create.CpuQuota = cpu.Quota
+			if cpu.Shares != nil {
+				shares := int64(*cpu.Shares)
+				create.CpuShares = &shares
+			}
+			if cpu.Period != nil {
+				period := int64(*cpu.Period)
+				create.CpuPeriod = &period
+			}
+		}
+		if memory := resources.Memory; memory != nil {
+			create.Memory = formatBytes(memory.Limit)
+			create.MemorySwap = formatBytes(memory.Swap)
+		}
+	}
+	if health := spec.HealthConfig; health != nil && len(health.Test) > 0 {
+		command, err := json.Marshal(health.Test[1:])
+		if err != nil {
+			return iopodman.Create{}, err
+		}
+		cmd := string(command)
+		interval, timeout, startPeriod := health.Interval.String(), health.Timeout.String(), health.StartPeriod.String()
+		create.HealthcheckCommand = &cmd
+		create.HealthcheckInterval = &interval
+		create.HealthcheckTimeout = &timeout
+		create.HealthcheckStartPeriod = &startPeriod
+		create.HealthcheckRetries = &health.Retries
+	}
+	return create, nil
+}
+
+func formatBytes(n *int64) *string {
+	if n == nil {
+		return nil
+	}
+	s := strconv.FormatInt(*n, 10)
+	return &s
+}
+
+func (a *restAPI) containerExists(w http.ResponseWriter, name string) {
+	if a.fault(w, "ContainerExists") {
+		return
+	}
+	exists(w, a.s.containerExists(name))
+}
+
+// inspectContainer replies inspect data, with size of writable layer if
+// size is queried
+func (a *restAPI) inspectContainer(w http.ResponseWriter, r *http.Request, name string) {
+	size, _ := strconv.ParseBool(r.URL.Query().Get("size"))
+	if !size {
+		if a.fault(w, "InspectContainer") {
+			return
+		}
+		data, err := a.s.containerInspect(name)
+		if err != nil {
+			replyStatus(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, data)
+		return
+	}
+
+	if a.fault(w, "GetContainer") {
+		return
+	}
+	ctr, err := a.s.containerGet(name)
+	if err != nil {
+		replyStatus(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		ID     string `json:"Id"`
+		SizeRw int64  `json:"SizeRw"`
+	}{ctr.Id, ctr.Rwsize})
+}
+
+func (a *restAPI) startContainer(w http.ResponseWriter, name string) {
+	if a.fault(w, "StartContainer") {
+		return
+	}
+	if _, err := a.s.containerStart(name); err != nil {
+		replyStatus(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *restAPI) stopContainer(w http.ResponseWriter, name string) {
+	if a.fault(w, "StopContainer") {
+		return
+	}
+	if _, err := a.s.containerStop(name); err != nil {
+		replyStatus(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *restAPI) waitContainer(w http.ResponseWriter, r *http.Request, name string) {
+	if a.fault(w, "WaitContainer") {
+		return
+	}
+	exitCode, err := a.s.containerWait(r.Context(), name, 0)
+	if err != nil {
+		replyStatus(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, exitCode)
+}
+
+// containerStats replies 409 for stopped containers, the same as podman
+func (a *restAPI) containerStats(w http.ResponseWriter, r *http.Request) {
+	if a.fault(w, "GetContainerStats") {
+		return
+	}
+	stats, err := a.s.containerStats(r.URL.Query().Get("containers"))
+	if err != nil {
+		replyStatus(w, err)
+		return
+	}
+	type stat struct {
+		ContainerID string  `json:"ContainerID"`
+		Name        string  `json:"Name"`
+		CPU         float64 `json:"CPU"`
+		CPUNano     int64   `json:"CPUNano"`
+		MemUsage    int64   `json:"MemUsage"`
+		MemLimit    int64   `json:"MemLimit"`
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Error interface{} `json:"Error"`
+		Stats []stat      `json:"Stats"`
+	}{
+		Stats: []stat{{stats.Id, stats.Name, stats.Cpu, stats.Cpu_nano, stats.Mem_usage, stats.Mem_limit}},
+	})
+}
+
+// containerLogs streams the log multiplexed, stdout and stderr lines as
+// frames of their streams
+func (a *restAPI) containerLogs(w http.ResponseWriter, r *http.Request, name string) {
+	if a.fault(w, "GetContainersLogs") {
+		return
+	}
+	query := r.URL.Query()
+	follow, _ := strconv.ParseBool(query.Get("follow"))
+	timestamps, _ := strconv.ParseBool(query.Get("timestamps"))
+	tail, _ := strconv.ParseInt(query.Get("tail"), 10, 64)
+	var since time.Time
+	if s := query.Get("since"); s != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	started := false
+	err := a.s.containerLogs(r.Context(), name, follow, since, tail, func(lines []iopodman.LogLine) error {
+		if !started {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			started = true
+		}
+		for _, line := range lines {
+			stream := restStreamStdout
+			if line.Device == "stderr" {
+				stream = restStreamStderr
+			}
+			msg := line.Msg
+			if line.ParseLogType != "P" {
+				msg += "\n"
+			}
+			if timestamps {
+				msg = line.Time + " " + msg
+			}
+			if err := writeFrame(w, stream, msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && !started {
+		replyStatus(w, err)
+	}
+}
+
+func (a *restAPI) createExec(w http.ResponseWriter, r *http.Request, name string) {
+	if a.fault(w, "ExecContainer") {
+		return
+	}
+	var config struct {
+		Cmd         []string `json:"Cmd"`
+		AttachStdin bool     `json:"AttachStdin"`
+		Tty         bool     `json:"Tty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := a.s.containerExec(name); err != nil {
+		replyStatus(w, err)
+		return
+	}
+	id := newID()
+	a.mu.Lock()
+	a.execs[id] = &restExec{container: name, cmd: config.Cmd, stdin: config.AttachStdin, tty: config.Tty}
+	a.mu.Unlock()
+	writeJSON(w, http.StatusCreated, struct {
+		ID string `json:"Id"`
+	}{id})
+}
+
+// startExec runs the exec function of the server with stdin read from the
+// connection hijacked from HTTP and writes its output on the connection,
+// multiplexed unless the session has terminal
+func (a *restAPI) startExec(w http.ResponseWriter, r *http.Request, id string) {
+	a.mu.Lock()
+	session, ok := a.execs[id]
+	if ok {
+		session.running = true
+	}
+	a.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such exec session")
+		return
+	}
+	exec, err := a.s.containerExec(session.container)
+	if err != nil {
+		replyStatus(w, err)
+		return
+	}
+
+	// start options are not used, the body must be read before stdin
+	ioutil.ReadAll(r.Body) //nolint:errcheck
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	defer conn.Close()
+	buf.WriteString("HTTP/1.1 101 UPGRADED\r\n" + //nolint:errcheck
+		"Content-Type: application/vnd.docker.raw-stream\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Upgrade: tcp\r\n\r\n")
+	buf.Flush() //nolint:errcheck
+
+	// stdin is read until the client closes its side of the connection
+	var stdin []byte
+	if session.stdin {
+		stdin, _ = ioutil.ReadAll(buf.Reader)
+	}
+	stdout, stderr, exitCode := exec(session.container, session.cmd, string(stdin), session.tty)
+	a.mu.Lock()
+	session.running = false
+	session.exitCode = exitCode
+	a.mu.Unlock()
+
+	if session.tty {
+		buf.WriteString(stdout) //nolint:errcheck
+		buf.Flush()             //nolint:errcheck
+		return
+	}
+	for _, f := range []struct {
+		stream byte
+		data   string
+	}{
+		{restStreamStdout, stdout},
+		{restStreamStderr, stderr},
+	} {
+		if f.data == "" {
+			continue
+		}
+		header := make([]byte, 8)
+		header[0] = f.stream
+		binary.BigEndian.PutUint32(header[4:8], uint32(len(f.data)))
+		buf.Write(header)       //nolint:errcheck
+		buf.WriteString(f.data) //nolint:errcheck
+	}
+	buf.Flush() //nolint:errcheck
+}
+
+func (a *restAPI) resizeExec(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	a.s.mu.Lock()
+	a.s.resizes = append(a.s.resizes, query.Get("w")+"x"+query.Get("h"))
+	a.s.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (a *restAPI) inspectExec(w http.ResponseWriter, id string) {
+	a.mu.Lock()
+	session, ok := a.execs[id]
+	var exec restExec
+	if ok {
+		exec = *session
+	}
+	a.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such exec session")
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		ExitCode int  `json:"ExitCode"`
+		Running  bool `json:"Running"`
+	}{exec.exitCode, exec.running})
+}
+
+func (a *restAPI) imageExists(w http.ResponseWriter, name string) {
+	if a.fault(w, "ImageExists") {
+		return
+	}
+	exists(w, a.s.imageExists(name))
+}
+
+// pullImage streams pull progress. Failed pulls are reported in the stream,
+// the same as by podman, after the reply status
+func (a *restAPI) pullImage(w http.ResponseWriter, r *http.Request) {
+	fault, ok := a.s.takeFault("PullImage")
+	if ok && fault.Drop {
+		drop(w)
+		return
+	}
+	image := r.URL.Query().Get("reference")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.Encode(struct { //nolint:errcheck
+		Stream string `json:"stream"`
+	}{fmt.Sprintf("Trying to pull %s...\n", image)})
+	w.(http.Flusher).Flush()
+
+	if ok && fault.Error != nil {
+		msg := fault.Error.Error()
+		if e, isErr := fault.Error.(*iopodman.ErrorOccurred); isErr {
+			msg = e.Reason
+		}
+		encoder.Encode(struct { //nolint:errcheck
+			Error string `json:"error"`
+		}{msg})
+		return
+	}
+	a.s.imagePull(image)
+	encoder.Encode(struct { //nolint:errcheck
+		ID string `json:"id"`
+	}{newID()})
+}
+
+// events streams events until the client goes away or the server is closed
+func (a *restAPI) events(w http.ResponseWriter, r *http.Request) {
+	if a.fault(w, "GetEvents") {
+		return
+	}
+	events, stop := a.s.watchEvents()
+	defer stop()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case event := <-events:
+			err := encoder.Encode(struct {
+				Type   string `json:"Type"`
+				Action string `json:"Action"`
+				Actor  struct {
+					ID         string            `json:"ID"`
+					Attributes map[string]string `json:"Attributes"`
+				} `json:"Actor"`
+			}{
+				Type:   event.Type,
+				Action: event.Status,
+				Actor: struct {
+					ID         string            `json:"ID"`
+					Attributes map[string]string `json:"Attributes"`
+				}{event.Id, map[string]string{"name": event.Name, "image": event.Image}},
+			})
+			if err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		case <-a.s.done:
+			return
+		}
+	}
+}
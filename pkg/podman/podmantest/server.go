@@ -0,0 +1,410 @@
+// Package podmantest provides an in-process fake of podman varlink API and
+// libpod REST API for tests, in the spirit of net/http/httptest. The fake
+// keeps pods, containers and images in memory, so nothing runs on the host,
+// and can be told to fail or delay calls to test error handling
+package podmantest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/varlink/go/varlink"
+
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+)
+
+// Fault changes the outcome of podman calls of a method
+type Fault struct {
+	// Error is replied instead of the result. iopodman error types are
+	// replied as podman errors of the same name, other errors as
+	// ErrorOccurred
+	Error error
+	// Delay delays the call, before anything is replied
+	Delay time.Duration
+	// Drop closes the connection without reply, as when podman service
+	// crashes
+	Drop bool
+	// Times is the number of calls the fault applies to. Zero applies it
+	// to all calls until ClearFaults
+	Times int
+}
+
+// Server is a fake podman service serving varlink API and libpod REST API
+// on temporary unix sockets. Both APIs share the state
+type Server struct {
+	// Socket is the varlink address of the server, to be used as podman
+	// socket
+	Socket string
+	// RESTSocket is the REST API address of the server, to be used as
+	// podman socket instead of Socket
+	RESTSocket string
+
+	dir      string
+	service  *varlink.Service
+	listener net.Listener
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopped  chan struct{}
+	rest     *httptest.Server
+
+	mu         sync.Mutex
+	pods       map[string]*pod
+	containers map[string]*container
+	images     map[string]bool
+	faults     map[string]*Fault
+	calls      map[string]int
+	exec       ExecIOFunc
+	resizes    []string
+	stats      map[string]iopodman.ContainerStats
+	watchers   map[chan iopodman.Event]struct{}
+	nextIP     int
+}
+
+// ExecFunc runs command of exec call in the container and returns its
+// output and exit code
+type ExecFunc func(container string, cmd []string) (stdout, stderr string, exitCode int)
+
+// ExecIOFunc is like ExecFunc, but also gets stdin of the session, read until
+// the client closes it, and whether the session has terminal. Output of
+// sessions with terminal is written unmultiplexed, stderr is dropped
+type ExecIOFunc func(container string, cmd []string, stdin string, tty bool) (stdout, stderr string, exitCode int)
+
+// NewServer starts fake podman service. The server must be closed with
+// Close
+func NewServer() (*Server, error) {
+	dir, err := ioutil.TempDir("", "podmantest")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Socket:     "unix:" + filepath.Join(dir, "io.podman"),
+		RESTSocket: "unix://" + filepath.Join(dir, "podman.sock"),
+		dir:        dir,
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		pods:       map[string]*pod{},
+		containers: map[string]*container{},
+		images:     map[string]bool{},
+		faults:     map[string]*Fault{},
+		calls:      map[string]int{},
+		stats:      map[string]iopodman.ContainerStats{},
+		watchers:   map[chan iopodman.Event]struct{}{},
+		exec: func(string, []string, string, bool) (string, string, int) {
+			return "", "", 0
+		},
+	}
+
+	restListener, err := net.Listen("unix", filepath.Join(dir, "podman.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	s.rest = &httptest.Server{
+		Listener: restListener,
+		Config:   &http.Server{Handler: &restAPI{s: s, execs: map[string]*restExec{}}},
+	}
+
+	s.service, err = varlink.NewService("podmantest", "podman", "1.6.2", "https://podman.io")
+	if err != nil {
+		restListener.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := s.service.RegisterInterface(iopodman.VarlinkNew(&service{s: s})); err != nil {
+		restListener.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	if err := s.service.Bind(ctx, s.Socket); err != nil {
+		cancel()
+		restListener.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	// the listener is closed directly, as Service.Shutdown races with
+	// DoListen
+	s.listener, _ = s.service.GetListener()
+	go func() {
+		defer close(s.stopped)
+		s.service.DoListen(ctx, 0) //nolint:errcheck
+	}()
+	s.rest.Start()
+	return s, nil
+}
+
+// Close stops the server, closes open connections and removes the socket
+func (s *Server) Close() {
+	close(s.done)
+	s.rest.Close()
+	s.cancel()
+	s.listener.Close()
+	<-s.stopped
+	os.RemoveAll(s.dir)
+}
+
+// InjectFault applies the fault to calls of the varlink method, such as
+// "CreatePod", and to REST API calls of the same meaning. Fault injected
+// before replaces it
+func (s *Server) InjectFault(method string, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := fault
+	s.faults[method] = &f
+}
+
+// ClearFaults removes all injected faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = map[string]*Fault{}
+}
+
+// Calls returns the number of calls of the varlink method, and REST API
+// calls of the same meaning, received so far, including failed ones
+func (s *Server) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+// AddImage makes the image available without pull
+func (s *Server) AddImage(image string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[image] = true
+}
+
+// HasImage returns true if the image was added or pulled
+func (s *Server) HasImage(image string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.images[image]
+}
+
+// SetExec sets the function run by exec calls. By default commands output
+// nothing and exit with 0
+func (s *Server) SetExec(fn ExecFunc) {
+	s.SetExecIO(func(container string, cmd []string, stdin string, tty bool) (string, string, int) {
+		return fn(container, cmd)
+	})
+}
+
+// SetExecIO sets the function run by exec calls with stdin and terminal of
+// the session. Stdin is read by REST API exec only
+func (s *Server) SetExecIO(fn ExecIOFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exec = fn
+}
+
+// ExecResizes returns terminal sizes, "<width>x<height>", of REST API exec
+// resize calls received so far
+func (s *Server) ExecResizes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.resizes...)
+}
+
+// SetStats sets stats reported for the running container
+func (s *Server) SetStats(name string, stats iopodman.ContainerStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats[name] = stats
+}
+
+// Log appends stdout line to the log of the container
+func (s *Server) Log(name, msg string) error {
+	return s.log(name, "stdout", msg)
+}
+
+// LogStderr appends stderr line to the log of the container
+func (s *Server) LogStderr(name, msg string) error {
+	return s.log(name, "stderr", msg)
+}
+
+func (s *Server) log(name, device, msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.container(name)
+	if c == nil {
+		return fmt.Errorf("no such container %s", name)
+	}
+	c.logs = append(c.logs, iopodman.LogLine{
+		Device:       device,
+		ParseLogType: "F",
+		Time:         time.Now().Format(time.RFC3339Nano),
+		Msg:          msg,
+		Cid:          c.id,
+	})
+	return nil
+}
+
+// Exit makes the running container exit with the exit code, as if its
+// process ended
+func (s *Server) Exit(name string, exitCode int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.container(name)
+	if c == nil {
+		return fmt.Errorf("no such container %s", name)
+	}
+	if c.status != statusRunning {
+		return fmt.Errorf("container %s is not running", name)
+	}
+	s.stopContainer(c, exitCode)
+	return nil
+}
+
+// OOMKill makes the running container exit as killed for exceeding its
+// memory limit
+func (s *Server) OOMKill(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.container(name)
+	if c == nil {
+		return fmt.Errorf("no such container %s", name)
+	}
+	if c.status != statusRunning {
+		return fmt.Errorf("container %s is not running", name)
+	}
+	c.oomKilled = true
+	s.emit(iopodman.Event{Type: "container", Status: "oom", Id: c.id, Name: c.name, Image: c.image})
+	s.stopContainer(c, 137)
+	return nil
+}
+
+// SetHealth sets healthcheck status of the running container, as if podman
+// ran its healthcheck
+func (s *Server) SetHealth(name, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.container(name)
+	if c == nil {
+		return fmt.Errorf("no such container %s", name)
+	}
+	if c.status != statusRunning {
+		return fmt.Errorf("container %s is not running", name)
+	}
+	c.health = status
+	s.emit(iopodman.Event{Type: "container", Status: "health_status", Id: c.id, Name: c.name, Image: c.image})
+	return nil
+}
+
+// Pods returns names of all pods
+func (s *Server) Pods() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.pods))
+	for _, p := range s.pods {
+		names = append(names, p.name)
+	}
+	return names
+}
+
+// Pod returns create options of the pod, as received from the client
+func (s *Server) Pod(name string) (iopodman.PodCreate, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pod(name)
+	if p == nil {
+		return iopodman.PodCreate{}, false
+	}
+	return p.create, true
+}
+
+// ContainerStatus returns podman status of the container, such as
+// "configured", "running" or "exited"
+func (s *Server) ContainerStatus(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.container(name)
+	if c == nil {
+		return "", false
+	}
+	return c.status, true
+}
+
+// Container returns create options of the container, as received from the
+// client
+func (s *Server) Container(name string) (iopodman.Create, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.container(name)
+	if c == nil {
+		return iopodman.Create{}, false
+	}
+	return c.create, true
+}
+
+// takeFault counts the call and returns fault injected for the method, once
+// its delay passes
+func (s *Server) takeFault(method string) (Fault, bool) {
+	s.mu.Lock()
+	s.calls[method]++
+	f, ok := s.faults[method]
+	var fault Fault
+	if ok {
+		fault = *f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				delete(s.faults, method)
+			}
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		return fault, false
+	}
+
+	if fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-s.done:
+		}
+	}
+	return fault, true
+}
+
+// fault counts the call and applies fault injected for the method. It
+// returns true if the call is handled by the fault, with the error to return
+// from the method
+func (s *Server) fault(ctx context.Context, c iopodman.VarlinkCall, method string) (bool, error) {
+	fault, ok := s.takeFault(method)
+	if !ok {
+		return false, nil
+	}
+	switch {
+	case fault.Drop:
+		// error returned from the method closes the connection
+		return true, fmt.Errorf("connection dropped by injected fault")
+	case fault.Error != nil:
+		return true, replyError(ctx, c, fault.Error)
+	}
+	return false, nil
+}
+
+// replyError replies iopodman error as podman error of the same name and
+// other errors as ErrorOccurred
+func replyError(ctx context.Context, c iopodman.VarlinkCall, err error) error {
+	v := reflect.ValueOf(err)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Type().PkgPath() == reflect.TypeOf(iopodman.ErrorOccurred{}).PkgPath() {
+		return c.ReplyError(ctx, "io.podman."+v.Type().Name(), err)
+	}
+	return c.ReplyErrorOccurred(ctx, err.Error())
+}
@@ -0,0 +1,335 @@
+package podmantest
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+)
+
+// service implements methods of io.podman interface used by the provider on
+// top of Server state. Other methods reply MethodNotImplemented
+type service struct {
+	iopodman.VarlinkInterface
+	s *Server
+}
+
+// Stream identifiers of upgraded exec connections
+const (
+	streamStdout byte = 0
+	streamStderr byte = 2
+	streamQuit   byte = 4
+)
+
+// minWaitInterval limits how often WaitContainer checks container status
+const minWaitInterval = 10 * time.Millisecond
+
+func (sv *service) CreatePod(ctx context.Context, c iopodman.VarlinkCall, create iopodman.PodCreate) error {
+	if handled, err := sv.s.fault(ctx, c, "CreatePod"); handled {
+		return err
+	}
+	id, err := sv.s.podCreate(create)
+	if err != nil {
+		return replyError(ctx, c, err)
+	}
+	return c.ReplyCreatePod(ctx, id)
+}
+
+func (sv *service) GetPod(ctx context.Context, c iopodman.VarlinkCall, name string) error {
+	if handled, err := sv.s.fault(ctx, c, "GetPod"); handled {
+		return err
+	}
+	pod, err := sv.s.podGet(name)
+	if err != nil {
+		return replyError(ctx, c, err)
+	}
+	return c.ReplyGetPod(ctx, pod)
+}
+
+func (sv *service) ListPods(ctx context.Context, c iopodman.VarlinkCall) error {
+	if handled, err := sv.s.fault(ctx, c, "ListPods"); handled {
+		return err
+	}
+	return c.ReplyListPods(ctx, sv.s.podList())
+}
+
+func (sv *service) InspectPod(ctx context.Context, c iopodman.VarlinkCall, name string) error {
+	if handled, err := sv.s.fault(ctx, c, "InspectPod"); handled {
+		return err
+	}
+	data, err := sv.s.podInspect(name)
+	if err != nil {
+		return replyError(ctx, c, err)
+	}
+	out, err := json.Marshal(data)
+	if err != nil {
+		return c.ReplyErrorOccurred(ctx, err.Error())
+	}
+	return c.ReplyInspectPod(ctx, string(out))
+}
+
+func (sv *service) StartPod(ctx context.Context, c iopodman.VarlinkCall, name string) error {
+	if handled, err := sv.s.fault(ctx, c, "StartPod"); handled {
+		return err
+	}
+	id, err := sv.s.podStart(name)
+	if err != nil {
+		return replyError(ctx, c, err)
+	}
+	return c.ReplyStartPod(ctx, id)
+}
+
+func (sv *service) StopPod(ctx context.Context, c iopodman.VarlinkCall, name string, timeout int64) error {
+	if handled, err := sv.s.fault(ctx, c, "StopPod"); handled {
+		return err
+	}
+	id, err := sv.s.podStop(name)
+	if err != nil {
+		return replyError(ctx, c, err)
+	}
+	return c.ReplyStopPod(ctx, id)
+}
+
+func (sv *service) RemovePod(ctx context.Context, c iopodman.VarlinkCall, name string, force bool) error {
+	if handled, err := sv.s.fault(ctx, c, "RemovePod"); handled {
+		return err
+	}
+	id, err := sv.s.podRemove(name, force)
+	if err != nil {
+		return replyError(ctx, c, err)
+	}
+	return c.ReplyRemovePod(ctx, id)
+}
+
+func (sv *service) CreateContainer(ctx context.Context, c iopodman.VarlinkCall, create iopodman.Create) error {
+	if handled, err := sv.s.fault(ctx, c, "CreateContainer"); handled {
+		return err
+	}
+	id, err := sv.s.containerCreate(create)
+	if err != nil {
+		return replyError(ctx, c, err)
+	}
+	return c.ReplyCreateContainer(ctx, id)
+}
+
+func (sv *service) ContainerExists(ctx context.Context, c iopodman.VarlinkCall, name string) error {
+	if handled, err := sv.s.fault(ctx, c, "ContainerExists"); handled {
+		return err
+	}
+	// podman replies 0 for existing containers
+	if !sv.s.containerExists(name) {
+		return c.ReplyContainerExists(ctx, 1)
+	}
+	return c.ReplyContainerExists(ctx, 0)
+}
+
+func (sv *service) InspectContainer(ctx context.Context, c iopodman.VarlinkCall, name string) error {
+	if handled, err := sv.s.fault(ctx, c, "InspectContainer"); handled {
+		return err
+	}
+	data, err := sv.s.containerInspect(name)
+	if err != nil {
+		return replyError(ctx, c, err)
+	}
+	out, err := json.Marshal(data)
+	if err != nil {
+		return c.ReplyErrorOccurred(ctx, err.Error())
+	}
+	return c.ReplyInspectContainer(ctx, string(out))
+}
+
+func (sv *service) GetContainer(ctx context.Context, c iopodman.VarlinkCall, name string) error {
+	if handled, err := sv.s.fault(ctx, c, "GetContainer"); handled {
+		return err
+	}
+	ctr, err := sv.s.containerGet(name)
+	if err != nil {
+		return replyError(ctx, c, err)
+	}
+	return c.ReplyGetContainer(ctx, ctr)
+}
+
+func (sv *service) StartContainer(ctx context.Context, c iopodman.VarlinkCall, name string) error {
+	if handled, err := sv.s.fault(ctx, c, "StartContainer"); handled {
+		return err
+	}
+	id, err := sv.s.containerStart(name)
+	if err != nil {
+		return replyError(ctx, c, err)
+	}
+	return c.ReplyStartContainer(ctx, id)
+}
+
+func (sv *service) StopContainer(ctx context.Context, c iopodman.VarlinkCall, name string, timeout int64) error {
+	if handled, err := sv.s.fault(ctx, c, "StopContainer"); handled {
+		return err
+	}
+	id, err := sv.s.containerStop(name)
+	if err != nil {
+		return replyError(ctx, c, err)
+	}
+	return c.ReplyStopContainer(ctx, id)
+}
+
+// WaitContainer polls container status every interval milliseconds
+func (sv *service) WaitContainer(ctx context.Context, c iopodman.VarlinkCall, name string, interval int64) error {
+	if handled, err := sv.s.fault(ctx, c, "WaitContainer"); handled {
+		return err
+	}
+	exitCode, err := sv.s.containerWait(ctx, name, time.Duration(interval)*time.Millisecond)
+	if err != nil {
+		if _, ok := err.(*iopodman.ContainerNotFound); ok {
+			return replyError(ctx, c, err)
+		}
+		return err
+	}
+	return c.ReplyWaitContainer(ctx, int64(exitCode))
+}
+
+func (sv *service) GetContainerStats(ctx context.Context, c iopodman.VarlinkCall, name string) error {
+	if handled, err := sv.s.fault(ctx, c, "GetContainerStats"); handled {
+		return err
+	}
+	stats, err := sv.s.containerStats(name)
+	if err != nil {
+		return replyError(ctx, c, err)
+	}
+	return c.ReplyGetContainerStats(ctx, stats)
+}
+
+func (sv *service) ImageExists(ctx context.Context, c iopodman.VarlinkCall, name string) error {
+	if handled, err := sv.s.fault(ctx, c, "ImageExists"); handled {
+		return err
+	}
+	// podman replies 0 for existing images
+	if !sv.s.imageExists(name) {
+		return c.ReplyImageExists(ctx, 1)
+	}
+	return c.ReplyImageExists(ctx, 0)
+}
+
+func (sv *service) PullImage(ctx context.Context, c iopodman.VarlinkCall, name string) error {
+	if handled, err := sv.s.fault(ctx, c, "PullImage"); handled {
+		return err
+	}
+	sv.s.imagePull(name)
+	return c.ReplyPullImage(ctx, iopodman.MoreResponse{Id: newID()})
+}
+
+// GetContainersLogs streams log of a single container. Followed logs are
+// streamed until the container exits
+func (sv *service) GetContainersLogs(ctx context.Context, c iopodman.VarlinkCall, names []string, follow, latest bool, since string, tail int64, timestamps bool) error {
+	if handled, err := sv.s.fault(ctx, c, "GetContainersLogs"); handled {
+		return err
+	}
+	if !c.WantsMore() {
+		return c.ReplyWantsMoreRequired(ctx, "GetContainersLogs requires more")
+	}
+	if len(names) != 1 {
+		return c.ReplyErrorOccurred(ctx, "logs of exactly one container are supported")
+	}
+
+	var sinceTime time.Time
+	if since != "" {
+		var err error
+		if sinceTime, err = time.Parse(time.RFC3339Nano, since); err != nil {
+			return c.ReplyErrorOccurred(ctx, err.Error())
+		}
+	}
+
+	err := sv.s.containerLogs(ctx, names[0], follow, sinceTime, tail, func(lines []iopodman.LogLine) error {
+		c.Continues = true
+		for _, line := range lines {
+			if err := c.ReplyGetContainersLogs(ctx, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if _, ok := err.(*iopodman.ContainerNotFound); ok {
+		return replyError(ctx, c, err)
+	}
+	if err != nil {
+		return err
+	}
+	c.Continues = false
+	return c.ReplyGetContainersLogs(ctx, iopodman.LogLine{})
+}
+
+// GetEvents streams events until the client goes away or the server is
+// closed
+func (sv *service) GetEvents(ctx context.Context, c iopodman.VarlinkCall, filter []string, since, until string) error {
+	if handled, err := sv.s.fault(ctx, c, "GetEvents"); handled {
+		return err
+	}
+	if !c.WantsMore() {
+		return c.ReplyWantsMoreRequired(ctx, "GetEvents requires more")
+	}
+	events, stop := sv.s.watchEvents()
+	defer stop()
+
+	c.Continues = true
+	for {
+		select {
+		case event := <-events:
+			if err := c.ReplyGetEvents(ctx, event); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-sv.s.done:
+			return fmt.Errorf("server closed")
+		}
+	}
+}
+
+// ExecContainer runs the exec function of the server and writes its output
+// and exit code as podman stream frames on the upgraded connection. Stdin
+// and resize frames are not read, as varlink service gives no access to the
+// upgraded connection
+func (sv *service) ExecContainer(ctx context.Context, c iopodman.VarlinkCall, opts iopodman.ExecOpts) error {
+	if handled, err := sv.s.fault(ctx, c, "ExecContainer"); handled {
+		return err
+	}
+	if !c.WantsUpgrade() {
+		return c.ReplyErrorOccurred(ctx, "ExecContainer requires upgrade")
+	}
+	exec, err := sv.s.containerExec(opts.Name)
+	if err != nil {
+		return replyError(ctx, c, err)
+	}
+
+	if err := c.ReplyExecContainer(ctx); err != nil {
+		return err
+	}
+	stdout, stderr, exitCode := exec(opts.Name, opts.Cmd, "", opts.Tty)
+	if opts.Tty {
+		stderr = ""
+	}
+	frames := []struct {
+		dest byte
+		data string
+	}{
+		{streamStdout, stdout},
+		{streamStderr, stderr},
+		{streamQuit, strconv.Itoa(exitCode)},
+	}
+	for _, f := range frames {
+		if f.data == "" && f.dest != streamQuit {
+			continue
+		}
+		frame := make([]byte, 8, 8+len(f.data))
+		frame[0] = f.dest
+		binary.BigEndian.PutUint32(frame[4:8], uint32(len(f.data)))
+		frame = append(frame, f.data...)
+		if _, err := c.Conn.Write(ctx, frame); err != nil {
+			return err
+		}
+	}
+	// upgraded connection carries no further calls, the error closes it
+	return fmt.Errorf("exec session finished")
+}
@@ -0,0 +1,295 @@
+package podmantest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+)
+
+// podman container statuses
+const (
+	statusConfigured = "configured"
+	statusRunning    = "running"
+	statusExited     = "exited"
+)
+
+const (
+	// infraImage is the image reported for infra containers
+	infraImage = "k8s.gcr.io/pause:3.1"
+	// stopExitCode is the exit code of stopped containers, as of processes
+	// terminated by SIGTERM
+	stopExitCode = 143
+	// rwSize is the size reported for writable layer of every container
+	rwSize = 4096
+)
+
+type pod struct {
+	id         string
+	name       string
+	create     iopodman.PodCreate
+	created    time.Time
+	infraID    string
+	containers []string
+}
+
+type container struct {
+	id         string
+	name       string
+	podID      string
+	image      string
+	create     iopodman.Create
+	infra      bool
+	created    time.Time
+	status     string
+	exitCode   int
+	oomKilled  bool
+	startedAt  time.Time
+	finishedAt time.Time
+	pid        int
+	health     string
+	ip         string
+	logs       []iopodman.LogLine
+}
+
+func newID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// pod returns pod by name or ID. Callers hold s.mu
+func (s *Server) pod(name string) *pod {
+	if p, ok := s.pods[name]; ok {
+		return p
+	}
+	for _, p := range s.pods {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// container returns container by name or ID. Callers hold s.mu
+func (s *Server) container(name string) *container {
+	if c, ok := s.containers[name]; ok {
+		return c
+	}
+	for _, c := range s.containers {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *Server) startContainer(c *container) {
+	if c.podID != "" {
+		// podman starts infra container with the first container of the
+		// pod
+		if p := s.pods[c.podID]; p != nil && p.infraID != "" && p.infraID != c.id {
+			if infra := s.containers[p.infraID]; infra.status != statusRunning {
+				s.startContainer(infra)
+			}
+		}
+	}
+	if c.infra && c.ip == "" {
+		s.nextIP++
+		c.ip = fmt.Sprintf("10.88.%d.%d", s.nextIP/250, s.nextIP%250+2)
+	}
+	c.status = statusRunning
+	c.exitCode = 0
+	c.oomKilled = false
+	c.startedAt = time.Now()
+	c.finishedAt = time.Time{}
+	c.pid = 1000 + len(s.containers)
+	// podman restarts healthcheck of started containers
+	c.health = ""
+	if c.create.HealthcheckCommand != nil {
+		c.health = converter.HealthStarting
+	}
+	s.emit(iopodman.Event{Type: "container", Status: "start", Id: c.id, Name: c.name, Image: c.image})
+}
+
+func (s *Server) stopContainer(c *container, exitCode int) {
+	c.status = statusExited
+	c.exitCode = exitCode
+	c.finishedAt = time.Now()
+	c.pid = 0
+	s.emit(iopodman.Event{Type: "container", Status: "died", Id: c.id, Name: c.name, Image: c.image})
+}
+
+func (s *Server) removeContainer(c *container) {
+	if c.status == statusRunning {
+		s.stopContainer(c, stopExitCode)
+	}
+	delete(s.containers, c.id)
+	delete(s.stats, c.name)
+	s.emit(iopodman.Event{Type: "container", Status: "remove", Id: c.id, Name: c.name, Image: c.image})
+}
+
+// emit sends the event to all event watchers. Watchers not keeping up miss
+// events, the same as clients of podman
+func (s *Server) emit(event iopodman.Event) {
+	event.Time = time.Now().Format(time.RFC3339Nano)
+	for ch := range s.watchers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (s *Server) podStatus(p *pod) string {
+	var running, exited int
+	for _, id := range p.containers {
+		switch s.containers[id].status {
+		case statusRunning:
+			running++
+		case statusExited:
+			exited++
+		}
+	}
+	switch {
+	case running > 0:
+		return "Running"
+	case exited > 0 && exited == len(p.containers):
+		return "Exited"
+	}
+	return "Created"
+}
+
+func (s *Server) listPodData(p *pod) iopodman.ListPodData {
+	data := iopodman.ListPodData{
+		Id:                 p.id,
+		Name:               p.name,
+		Createdat:          p.created.Format(time.RFC3339Nano),
+		Cgroup:             p.create.CgroupParent,
+		Status:             s.podStatus(p),
+		Labels:             p.create.Labels,
+		Numberofcontainers: strconv.Itoa(len(p.containers)),
+	}
+	for _, id := range p.containers {
+		c := s.containers[id]
+		data.Containersinfo = append(data.Containersinfo, iopodman.ListPodContainerInfo{
+			Name:   c.name,
+			Id:     c.id,
+			Status: c.status,
+		})
+	}
+	return data
+}
+
+func (s *Server) inspectPod(p *pod) (*converter.PodmanPod, error) {
+	data := &converter.PodmanPod{}
+	data.Config.ID = p.id
+	data.Config.Name = p.name
+	data.Config.Labels = p.create.Labels
+	data.Config.CgroupParent = p.create.CgroupParent
+	data.Config.Created = p.created
+	data.Config.InfraConfig.MakeInfraContainer = p.create.Infra
+	for _, publish := range p.create.Publish {
+		mapping, err := parsePublish(publish)
+		if err != nil {
+			return nil, err
+		}
+		data.Config.InfraConfig.InfraPortBindings = append(data.Config.InfraConfig.InfraPortBindings, mapping)
+	}
+	data.State.InfraContainerID = p.infraID
+	for _, id := range p.containers {
+		data.Containers = append(data.Containers, struct {
+			ID    string `json:"id"`
+			State string `json:"state"`
+		}{ID: id, State: s.containers[id].status})
+	}
+	return data, nil
+}
+
+func (s *Server) inspectContainer(c *container) *converter.PodmanContainerData {
+	data := &converter.PodmanContainerData{
+		ID:        c.id,
+		Name:      c.name,
+		Created:   c.created,
+		Image:     c.image,
+		ImageName: c.image,
+		Pod:       c.podID,
+		IsInfra:   c.infra,
+	}
+	if len(c.create.Args) > 1 {
+		data.Path = c.create.Args[1]
+		data.Args = c.create.Args[2:]
+		data.Config.Cmd = c.create.Args[1:]
+	}
+	data.Config.Image = c.image
+	if c.create.Env != nil {
+		data.Config.Env = *c.create.Env
+	}
+	if c.create.Tty != nil {
+		data.Config.Tty = *c.create.Tty
+	}
+	if c.create.Privileged != nil {
+		data.HostConfig.Privileged = *c.create.Privileged
+	}
+	if c.create.Volume != nil {
+		data.HostConfig.Binds = *c.create.Volume
+	}
+	if c.create.OomScoreAdj != nil {
+		data.HostConfig.OomScoreAdj = int(*c.create.OomScoreAdj)
+	}
+	if c.create.CpuShares != nil {
+		data.HostConfig.CPUShares = int(*c.create.CpuShares)
+	}
+	if c.create.Memory != nil {
+		memory, _ := strconv.Atoi(*c.create.Memory)
+		data.HostConfig.Memory = memory
+	}
+
+	data.State.Status = c.status
+	data.State.Running = c.status == statusRunning
+	data.State.OOMKilled = c.oomKilled
+	data.State.ExitCode = c.exitCode
+	data.State.Pid = c.pid
+	data.State.StartedAt = c.startedAt
+	data.State.FinishedAt = c.finishedAt
+	data.State.Healthcheck.Status = c.health
+	data.NetworkSettings.IPAddress = c.ip
+	return data
+}
+
+// parsePublish parses port binding of pod create options in the
+// "[hostIP:]hostPort:containerPort/protocol" form
+func parsePublish(publish string) (converter.PortMapping, error) {
+	mapping := converter.PortMapping{Protocol: "tcp"}
+	ports := publish
+	if i := strings.LastIndex(ports, "/"); i >= 0 {
+		mapping.Protocol = ports[i+1:]
+		ports = ports[:i]
+	}
+	words := strings.Split(ports, ":")
+	if len(words) < 2 {
+		return mapping, fmt.Errorf("invalid port binding %q", publish)
+	}
+	if len(words) > 2 {
+		mapping.HostIP = strings.Join(words[:len(words)-2], ":")
+		words = words[len(words)-2:]
+	}
+	hostPort, err := strconv.ParseInt(words[0], 10, 32)
+	if err != nil {
+		return mapping, fmt.Errorf("invalid port binding %q: %v", publish, err)
+	}
+	containerPort, err := strconv.ParseInt(words[1], 10, 32)
+	if err != nil {
+		return mapping, fmt.Errorf("invalid port binding %q: %v", publish, err)
+	}
+	mapping.HostPort = int32(hostPort)
+	mapping.ContainerPort = int32(containerPort)
+	return mapping, nil
+}
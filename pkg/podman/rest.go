@@ -0,0 +1,477 @@
+package podman
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"k8s.io/kubernetes/pkg/credentialprovider"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+	"github.com/virtual-kubelet/podman/pkg/util/errors"
+)
+
+const (
+	// restAPIVersion is the libpod REST API version used, podman 2.0
+	restAPIVersion = "v2.0.0"
+)
+
+// restBackend runs calls of libpod REST API of podman 2 and newer over unix
+// socket. API errors are translated to iopodman errors the varlink API
+// returns, so both backends report failures the same way. Short and long
+// calls are limited by lanes of the same size as varlink connection pool
+type restBackend struct {
+	socket  string
+	http    *http.Client
+	short   *lane
+	long    *lane
+	timeout time.Duration
+	retries int
+	log     *zap.SugaredLogger
+}
+
+// apiError is error response of libpod API
+type apiError struct {
+	StatusCode int
+	Message    string `json:"message"`
+	Cause      string `json:"cause"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("podman API error %d: %s", e.StatusCode, e.Message)
+}
+
+// newRESTBackend connects to libpod REST API socket, unix:///path
+func newRESTBackend(ctx context.Context, cfg *Config) (*restBackend, error) {
+	socket := strings.TrimPrefix(*cfg.Socket, "unix://")
+	b := &restBackend{
+		socket: socket,
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+				MaxIdleConnsPerHost: cfg.PoolSize + cfg.LongPoolSize,
+			},
+		},
+		short:   newLane("short", cfg.PoolSize),
+		long:    newLane("long", cfg.LongPoolSize),
+		timeout: cfg.CallTimeout,
+		retries: defaultRetries,
+		log:     cfg.Log,
+	}
+	// fail early when podman is not reachable
+	resp, err := b.do(ctx, http.MethodGet, "/_ping", nil, nil)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	return b, nil
+}
+
+// Close closes idle connections
+func (b *restBackend) Close() error {
+	b.http.Transport.(*http.Transport).CloseIdleConnections()
+	return nil
+}
+
+// do sends request to libpod API. Connection failures are returned as
+// transient errors and error responses as apiError. Body of successful
+// response must be closed by the caller
+func (b *restBackend) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := b.request(ctx, method, path, query, reader)
+	if err != nil {
+		return nil, err
+	}
+	return b.send(req)
+}
+
+func (b *restBackend) request(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := url.URL{
+		Scheme:   "http",
+		Host:     "d",
+		Path:     "/" + restAPIVersion + "/libpod" + path,
+		RawQuery: query.Encode(),
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req.WithContext(ctx), nil
+}
+
+func (b *restBackend) send(req *http.Request) (*http.Response, error) {
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return nil, errors.Transient(err)
+	}
+	// 304 is returned for containers already in the requested state
+	if resp.StatusCode < 300 || resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	apiErr := &apiError{StatusCode: resp.StatusCode}
+	data, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(data, apiErr); err != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	return nil, apiErr
+}
+
+// call runs short call with the per call timeout and decodes JSON response
+// into out, if it is set
+func (b *restBackend) call(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+	if err := b.short.wait(ctx); err != nil {
+		return err
+	}
+	defer b.short.done()
+	resp, err := b.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Transient(err)
+	}
+	return nil
+}
+
+// callLong runs long running call in the long lane, limited only by ctx.
+// Failures other than API errors are returned as transient errors
+func (b *restBackend) callLong(ctx context.Context, fn func(context.Context) error) error {
+	if err := b.long.wait(ctx); err != nil {
+		return err
+	}
+	defer b.long.done()
+	err := fn(ctx)
+	if _, replied := err.(*apiError); err == nil || replied || errors.IsReply(err) {
+		return err
+	}
+	return errors.Transient(err)
+}
+
+// get is call for reads, retried on transient failures with back-off
+func (b *restBackend) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	backOff := initialRetryBackOff
+	for attempt := 0; ; attempt++ {
+		err := b.call(ctx, http.MethodGet, path, query, nil, out)
+		if !errors.IsTransient(err) || attempt >= b.retries {
+			return err
+		}
+		select {
+		case <-time.After(backOff):
+		case <-ctx.Done():
+			return err
+		}
+		backOff *= 2
+	}
+}
+
+// exists runs exists call, which reports objects with status code only
+func (b *restBackend) exists(ctx context.Context, path string) (bool, error) {
+	err := b.get(ctx, path, nil, nil)
+	if err == nil {
+		return true, nil
+	}
+	if isStatus(err, http.StatusNotFound) {
+		return false, nil
+	}
+	return false, err
+}
+
+func isStatus(err error, status int) bool {
+	apiErr, ok := err.(*apiError)
+	return ok && apiErr.StatusCode == status
+}
+
+// replyError translates API error to iopodman error of the same meaning
+func replyError(err error, notFound func(reason string) error) error {
+	apiErr, ok := err.(*apiError)
+	if !ok {
+		return err
+	}
+	if apiErr.StatusCode == http.StatusNotFound && notFound != nil {
+		return notFound(apiErr.Message)
+	}
+	return &iopodman.ErrorOccurred{Reason: apiErr.Message}
+}
+
+func podNotFound(name string) func(string) error {
+	return func(reason string) error {
+		return &iopodman.PodNotFound{Name: name, Reason: reason}
+	}
+}
+
+func containerNotFound(name string) func(string) error {
+	return func(reason string) error {
+		return &iopodman.ContainerNotFound{Id: name, Reason: reason}
+	}
+}
+
+func imageNotFound(name string) func(string) error {
+	return func(reason string) error {
+		return &iopodman.ImageNotFound{Id: name, Reason: reason}
+	}
+}
+
+func (b *restBackend) CreatePod(ctx context.Context, create iopodman.PodCreate) (string, error) {
+	spec, err := podSpec(create)
+	if err != nil {
+		return "", err
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := b.call(ctx, http.MethodPost, "/pods/create", nil, spec, &created); err != nil {
+		return "", replyError(err, nil)
+	}
+	return create.Name, nil
+}
+
+func (b *restBackend) PodExists(ctx context.Context, name string) (bool, error) {
+	exists, err := b.exists(ctx, "/pods/"+url.PathEscape(name)+"/exists")
+	return exists, replyError(err, nil)
+}
+
+// restPod is inspect data of libpod REST API pod
+type restPod struct {
+	ID               string            `json:"Id"`
+	Name             string            `json:"Name"`
+	Created          time.Time         `json:"Created"`
+	Labels           map[string]string `json:"Labels"`
+	CgroupParent     string            `json:"CgroupParent"`
+	CgroupPath       string            `json:"CgroupPath"`
+	InfraContainerID string            `json:"InfraContainerID"`
+	Containers       []struct {
+		ID    string `json:"Id"`
+		State string `json:"State"`
+	} `json:"Containers"`
+}
+
+// InspectPod converts REST inspect data to the shape of varlink inspect data
+func (b *restBackend) InspectPod(ctx context.Context, name string) (*converter.PodmanPod, error) {
+	var pod restPod
+	if err := b.get(ctx, "/pods/"+url.PathEscape(name)+"/json", nil, &pod); err != nil {
+		return nil, replyError(err, podNotFound(name))
+	}
+	pPod := &converter.PodmanPod{}
+	pPod.Config.ID = pod.ID
+	pPod.Config.Name = pod.Name
+	pPod.Config.Labels = pod.Labels
+	pPod.Config.CgroupParent = pod.CgroupParent
+	pPod.Config.Created = pod.Created
+	pPod.State.CgroupPath = pod.CgroupPath
+	pPod.State.InfraContainerID = pod.InfraContainerID
+	for _, c := range pod.Containers {
+		pPod.Containers = append(pPod.Containers, struct {
+			ID    string `json:"id"`
+			State string `json:"state"`
+		}{ID: c.ID, State: c.State})
+	}
+	return pPod, nil
+}
+
+func (b *restBackend) ListPods(ctx context.Context) ([]string, error) {
+	var pods []struct {
+		Name string `json:"Name"`
+	}
+	if err := b.get(ctx, "/pods/json", nil, &pods); err != nil {
+		return nil, replyError(err, nil)
+	}
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	return names, nil
+}
+
+// StopPod is not limited by the call timeout, it lasts up to the pod grace
+// period
+func (b *restBackend) StopPod(ctx context.Context, name string, timeout int64) error {
+	query := url.Values{"t": {strconv.FormatInt(timeout, 10)}}
+	err := b.callLong(ctx, func(ctx context.Context) error {
+		resp, err := b.do(ctx, http.MethodPost, "/pods/"+url.PathEscape(name)+"/stop", query, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	})
+	return replyError(err, podNotFound(name))
+}
+
+func (b *restBackend) RemovePod(ctx context.Context, name string) error {
+	query := url.Values{"force": {"true"}}
+	err := b.call(ctx, http.MethodDelete, "/pods/"+url.PathEscape(name), query, nil, nil)
+	return replyError(err, podNotFound(name))
+}
+
+func (b *restBackend) CreateContainer(ctx context.Context, create iopodman.Create) error {
+	spec, err := containerSpec(create)
+	if err != nil {
+		return err
+	}
+	err = b.call(ctx, http.MethodPost, "/containers/create", nil, spec, nil)
+	return replyError(err, imageNotFound(spec.Image))
+}
+
+func (b *restBackend) ContainerExists(ctx context.Context, name string) (bool, error) {
+	exists, err := b.exists(ctx, "/containers/"+url.PathEscape(name)+"/exists")
+	return exists, replyError(err, nil)
+}
+
+// InspectContainer decodes REST inspect data directly, it has the same shape
+// as varlink inspect data
+func (b *restBackend) InspectContainer(ctx context.Context, name string) (*converter.PodmanContainerData, error) {
+	var container converter.PodmanContainerData
+	if err := b.get(ctx, "/containers/"+url.PathEscape(name)+"/json", nil, &container); err != nil {
+		return nil, replyError(err, containerNotFound(name))
+	}
+	return &container, nil
+}
+
+func (b *restBackend) ContainerSize(ctx context.Context, name string) (int64, error) {
+	var container struct {
+		SizeRw int64 `json:"SizeRw"`
+	}
+	query := url.Values{"size": {"true"}}
+	if err := b.get(ctx, "/containers/"+url.PathEscape(name)+"/json", query, &container); err != nil {
+		return 0, replyError(err, containerNotFound(name))
+	}
+	return container.SizeRw, nil
+}
+
+func (b *restBackend) StartContainer(ctx context.Context, name string) error {
+	err := b.call(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/start", nil, nil, nil)
+	return replyError(err, containerNotFound(name))
+}
+
+// StopContainer is not limited by the call timeout, it lasts up to the pod
+// grace period
+func (b *restBackend) StopContainer(ctx context.Context, name string, timeout int64) error {
+	query := url.Values{"timeout": {strconv.FormatInt(timeout, 10)}}
+	err := b.callLong(ctx, func(ctx context.Context) error {
+		resp, err := b.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/stop", query, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	})
+	return replyError(err, containerNotFound(name))
+}
+
+func (b *restBackend) ContainerStats(ctx context.Context, name string) (*iopodman.ContainerStats, error) {
+	var reply struct {
+		Error interface{} `json:"Error"`
+		Stats []struct {
+			CPU      float64 `json:"CPU"`
+			CPUNano  int64   `json:"CPUNano"`
+			MemUsage int64   `json:"MemUsage"`
+			MemLimit int64   `json:"MemLimit"`
+		} `json:"Stats"`
+	}
+	query := url.Values{
+		"containers": {name},
+		"stream":     {"false"},
+	}
+	err := b.get(ctx, "/containers/stats", query, &reply)
+	if isStatus(err, http.StatusConflict) {
+		return nil, &iopodman.NoContainerRunning{}
+	}
+	if err != nil {
+		return nil, replyError(err, containerNotFound(name))
+	}
+	if reply.Error != nil || len(reply.Stats) == 0 {
+		return nil, &iopodman.NoContainerRunning{}
+	}
+	stat := reply.Stats[0]
+	return &iopodman.ContainerStats{
+		Name:      name,
+		Cpu:       stat.CPU,
+		Cpu_nano:  stat.CPUNano,
+		Mem_usage: stat.MemUsage,
+		Mem_limit: stat.MemLimit,
+	}, nil
+}
+
+func (b *restBackend) ImageExists(ctx context.Context, image string) (bool, error) {
+	exists, err := b.exists(ctx, "/images/"+url.PathEscape(image)+"/exists")
+	return exists, replyError(err, nil)
+}
+
+// PullImage passes registry credentials in X-Registry-Auth header. Pull
+// progress is streamed and failures are reported in the stream
+func (b *restBackend) PullImage(ctx context.Context, image string, auth *credentialprovider.AuthConfig) error {
+	query := url.Values{"reference": {image}}
+	req, err := b.request(ctx, http.MethodPost, "/images/pull", query, nil)
+	if err != nil {
+		return err
+	}
+	if auth != nil {
+		data, err := json.Marshal(struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}{auth.Username, auth.Password})
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Registry-Auth", base64.URLEncoding.EncodeToString(data))
+	}
+	return b.callLong(ctx, func(ctx context.Context) error {
+		resp, err := b.send(req)
+		if err != nil {
+			return replyError(err, imageNotFound(image))
+		}
+		defer resp.Body.Close()
+
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var report struct {
+				Error string `json:"error"`
+			}
+			if err := decoder.Decode(&report); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			if report.Error != "" {
+				return &iopodman.ErrorOccurred{Reason: report.Error}
+			}
+		}
+	})
+}
@@ -0,0 +1,296 @@
+package podman
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+)
+
+// restPodSpec is pod spec generator of libpod REST API
+type restPodSpec struct {
+	Name             string            `json:"name"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	CgroupParent     string            `json:"cgroup_parent,omitempty"`
+	SharedNamespaces []string          `json:"shared_namespaces,omitempty"`
+	NoInfra          bool              `json:"no_infra"`
+	InfraCommand     []string          `json:"infra_command,omitempty"`
+	InfraImage       string            `json:"infra_image,omitempty"`
+	PortMappings     []restPortMapping `json:"portmappings,omitempty"`
+}
+
+type restPortMapping struct {
+	HostIP        string `json:"host_ip,omitempty"`
+	ContainerPort uint16 `json:"container_port"`
+	HostPort      uint16 `json:"host_port"`
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+// restContainerSpec is container spec generator of libpod REST API
+type restContainerSpec struct {
+	Name         string            `json:"name,omitempty"`
+	Pod          string            `json:"pod,omitempty"`
+	Image        string            `json:"image"`
+	Entrypoint   []string          `json:"entrypoint,omitempty"`
+	Command      []string          `json:"command,omitempty"`
+	Env          map[string]string `json:"env,omitempty"`
+	Privileged   bool              `json:"privileged,omitempty"`
+	Terminal     bool              `json:"terminal,omitempty"`
+	NetNS        *restNamespace    `json:"netns,omitempty"`
+	Mounts       []restMount       `json:"mounts,omitempty"`
+	Resources    *restResources    `json:"resource_limits,omitempty"`
+	OOMScoreAdj  *int64            `json:"oom_score_adj,omitempty"`
+	HealthConfig *restHealthConfig `json:"healthconfig,omitempty"`
+}
+
+type restNamespace struct {
+	NSMode string `json:"nsmode"`
+}
+
+type restMount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Source      string   `json:"source"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type restResources struct {
+	CPU    *restCPU    `json:"cpu,omitempty"`
+	Memory *restMemory `json:"memory,omitempty"`
+}
+
+type restCPU struct {
+	Shares *uint64 `json:"shares,omitempty"`
+	Quota  *int64  `json:"quota,omitempty"`
+	Period *uint64 `json:"period,omitempty"`
+}
+
+type restMemory struct {
+	Limit *int64 `json:"limit,omitempty"`
+	Swap  *int64 `json:"swap,omitempty"`
+}
+
+// restHealthConfig durations are in nanoseconds
+type restHealthConfig struct {
+	Test        []string      `json:"Test"`
+	Interval    time.Duration `json:"Interval"`
+	Timeout     time.Duration `json:"Timeout"`
+	StartPeriod time.Duration `json:"StartPeriod"`
+	Retries     int64         `json:"Retries"`
+}
+
+// podSpec converts varlink pod create options to REST pod spec
+func podSpec(create iopodman.PodCreate) (*restPodSpec, error) {
+	spec := &restPodSpec{
+		Name:             create.Name,
+		Labels:           create.Labels,
+		CgroupParent:     create.CgroupParent,
+		SharedNamespaces: create.Share,
+		NoInfra:          !create.Infra,
+		InfraImage:       create.InfraImage,
+	}
+	if create.InfraCommand != "" {
+		spec.InfraCommand = []string{create.InfraCommand}
+	}
+	for _, publish := range create.Publish {
+		mapping, err := parsePublish(publish)
+		if err != nil {
+			return nil, err
+		}
+		spec.PortMappings = append(spec.PortMappings, mapping)
+	}
+	return spec, nil
+}
+
+// parsePublish parses port binding in the "[hostIP:]hostPort:containerPort/protocol"
+// form
+func parsePublish(publish string) (restPortMapping, error) {
+	var mapping restPortMapping
+	ports := publish
+	if i := strings.LastIndex(ports, "/"); i >= 0 {
+		mapping.Protocol = ports[i+1:]
+		ports = ports[:i]
+	}
+	words := strings.Split(ports, ":")
+	if len(words) < 2 {
+		return mapping, fmt.Errorf("invalid port binding %q", publish)
+	}
+	if len(words) > 2 {
+		mapping.HostIP = strings.Join(words[:len(words)-2], ":")
+		words = words[len(words)-2:]
+	}
+	hostPort, err := strconv.ParseUint(words[0], 10, 16)
+	if err != nil {
+		return mapping, fmt.Errorf("invalid host port of port binding %q: %v", publish, err)
+	}
+	containerPort, err := strconv.ParseUint(words[1], 10, 16)
+	if err != nil {
+		return mapping, fmt.Errorf("invalid container port of port binding %q: %v", publish, err)
+	}
+	mapping.HostPort = uint16(hostPort)
+	mapping.ContainerPort = uint16(containerPort)
+	return mapping, nil
+}
+
+// containerSpec converts varlink container create options, as filled by the
+// converter, to REST container spec. Args hold the image followed by the
+// command and its arguments
+func containerSpec(create iopodman.Create) (*restContainerSpec, error) {
+	if len(create.Args) == 0 {
+		return nil, fmt.Errorf("container image is not set")
+	}
+	spec := &restContainerSpec{
+		Image:      create.Args[0],
+		Name:       stringValue(create.Name),
+		Pod:        stringValue(create.Pod),
+		Privileged: boolValue(create.Privileged),
+		Terminal:   boolValue(create.Tty),
+	}
+
+	args := create.Args[1:]
+	if create.Command != nil && len(*create.Command) > 0 && len(*create.Command) <= len(args) {
+		spec.Entrypoint = args[:len(*create.Command)]
+		args = args[len(*create.Command):]
+	}
+	if len(args) > 0 {
+		spec.Command = args
+	}
+
+	if create.Env != nil && len(*create.Env) > 0 {
+		spec.Env = make(map[string]string, len(*create.Env))
+		for _, env := range *create.Env {
+			words := strings.SplitN(env, "=", 2)
+			if len(words) == 2 {
+				spec.Env[words[0]] = words[1]
+			} else {
+				spec.Env[words[0]] = ""
+			}
+		}
+	}
+
+	if create.Net != nil && *create.Net == "host" {
+		spec.NetNS = &restNamespace{NSMode: "host"}
+	}
+
+	if create.Volume != nil {
+		for _, volume := range *create.Volume {
+			words := strings.Split(volume, ":")
+			if len(words) < 2 {
+				return nil, fmt.Errorf("invalid volume %q", volume)
+			}
+			mount := restMount{
+				Source:      words[0],
+				Destination: words[1],
+				Type:        "bind",
+				Options:     []string{"rbind"},
+			}
+			if len(words) > 2 {
+				mount.Options = append(mount.Options, strings.Split(words[2], ",")...)
+			}
+			spec.Mounts = append(spec.Mounts, mount)
+		}
+	}
+
+	resources, err := containerResources(create)
+	if err != nil {
+		return nil, err
+	}
+	spec.Resources = resources
+	spec.OOMScoreAdj = create.OomScoreAdj
+
+	health, err := containerHealthConfig(create)
+	if err != nil {
+		return nil, err
+	}
+	spec.HealthConfig = health
+	return spec, nil
+}
+
+func containerResources(create iopodman.Create) (*restResources, error) {
+	var resources restResources
+	if create.CpuShares != nil || create.CpuQuota != nil || create.CpuPeriod != nil {
+		resources.CPU = &restCPU{Quota: create.CpuQuota}
+		if create.CpuShares != nil {
+			shares := uint64(*create.CpuShares)
+			resources.CPU.Shares = &shares
+		}
+		if create.CpuPeriod != nil {
+			period := uint64(*create.CpuPeriod)
+			resources.CPU.Period = &period
+		}
+	}
+	if create.Memory != nil || create.MemorySwap != nil {
+		resources.Memory = &restMemory{}
+		var err error
+		if resources.Memory.Limit, err = parseBytes(create.Memory); err != nil {
+			return nil, err
+		}
+		if resources.Memory.Swap, err = parseBytes(create.MemorySwap); err != nil {
+			return nil, err
+		}
+	}
+	if resources.CPU == nil && resources.Memory == nil {
+		return nil, nil
+	}
+	return &resources, nil
+}
+
+func containerHealthConfig(create iopodman.Create) (*restHealthConfig, error) {
+	if create.HealthcheckCommand == nil {
+		return nil, nil
+	}
+	// healthcheck command is JSON array, run as exec form
+	var command []string
+	if err := json.Unmarshal([]byte(*create.HealthcheckCommand), &command); err != nil {
+		return nil, fmt.Errorf("invalid healthcheck command: %v", err)
+	}
+	health := &restHealthConfig{
+		Test: append([]string{"CMD"}, command...),
+	}
+	var err error
+	if health.Interval, err = parseDuration(create.HealthcheckInterval); err != nil {
+		return nil, err
+	}
+	if health.Timeout, err = parseDuration(create.HealthcheckTimeout); err != nil {
+		return nil, err
+	}
+	if health.StartPeriod, err = parseDuration(create.HealthcheckStartPeriod); err != nil {
+		return nil, err
+	}
+	if create.HealthcheckRetries != nil {
+		health.Retries = *create.HealthcheckRetries
+	}
+	return health, nil
+}
+
+func parseBytes(s *string) (*int64, error) {
+	if s == nil {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(*s, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid byte count %q: %v", *s, err)
+	}
+	return &n, nil
+}
+
+func parseDuration(s *string) (time.Duration, error) {
+	if s == nil || *s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(*s)
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func boolValue(b *bool) bool {
+	return b != nil && *b
+}
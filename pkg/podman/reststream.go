@@ -0,0 +1,320 @@
+package podman
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"github.com/virtual-kubelet/podman/pkg/util/errors"
+)
+
+// Stream identifiers of multiplexed REST API streams. Every frame starts
+// with 8 bytes header: stream byte, 3 bytes padding and big endian uint32
+// payload length
+const (
+	restStreamStdin byte = iota
+	restStreamStdout
+	restStreamStderr
+)
+
+// WaitContainer is a long call, it blocks for container lifetime
+func (b *restBackend) WaitContainer(ctx context.Context, name string) (int64, error) {
+	var exitCode int64
+	err := b.callLong(ctx, func(ctx context.Context) error {
+		resp, err := b.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/wait", nil, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		return json.NewDecoder(resp.Body).Decode(&exitCode)
+	})
+	return exitCode, replyError(err, containerNotFound(name))
+}
+
+// ContainerLogs demultiplexes stdout and stderr of the container into single
+// stream. Timestamps are rendered by podman in the same format as by kubelet
+func (b *restBackend) ContainerLogs(ctx context.Context, ctrName string, opts ContainerLogOpts) (io.ReadCloser, error) {
+	query := url.Values{
+		"stdout":     {"true"},
+		"stderr":     {"true"},
+		"follow":     {strconv.FormatBool(opts.Follow)},
+		"timestamps": {strconv.FormatBool(opts.Timestamps)},
+	}
+	if opts.Tail > 0 {
+		query.Set("tail", strconv.Itoa(opts.Tail))
+	}
+	if !opts.Since.IsZero() {
+		query.Set("since", opts.Since.Format(time.RFC3339Nano))
+	}
+
+	// the stream holds slot of the long lane until it ends or is closed
+	if err := b.long.wait(ctx); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	// missing containers are reported in the response status, before the
+	// log stream starts
+	resp, err := b.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(ctrName)+"/logs", query, nil)
+	if err != nil {
+		cancel()
+		b.long.done()
+		return nil, replyError(err, containerNotFound(ctrName))
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		defer b.long.done()
+		defer resp.Body.Close()
+		w.CloseWithError(demuxStream(bufio.NewReader(resp.Body), w, w))
+	}()
+
+	return &logReader{
+		Reader: r,
+		close: func() error {
+			cancel()
+			return r.Close()
+		},
+	}, nil
+}
+
+// restExec is exec session inspect data
+type restExec struct {
+	ExitCode int  `json:"ExitCode"`
+	Running  bool `json:"Running"`
+}
+
+// Exec creates exec session and starts it on dedicated connection hijacked
+// from HTTP, which carries the process streams
+func (b *restBackend) Exec(ctx context.Context, name string, cmd []string, attach api.AttachIO) error {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := b.call(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/exec", nil, struct {
+		Cmd          []string `json:"Cmd"`
+		AttachStdin  bool     `json:"AttachStdin"`
+		AttachStdout bool     `json:"AttachStdout"`
+		AttachStderr bool     `json:"AttachStderr"`
+		Tty          bool     `json:"Tty"`
+	}{cmd, attach.Stdin() != nil, attach.Stdout() != nil, attach.Stderr() != nil, attach.TTY()}, &created)
+	if err != nil {
+		return errors.VKError(replyError(err, containerNotFound(name)))
+	}
+	execPath := "/exec/" + url.PathEscape(created.ID)
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", b.socket)
+	if err != nil {
+		return errors.Transient(err)
+	}
+	defer conn.Close()
+
+	reader, err := b.hijack(ctx, conn, execPath+"/start", struct {
+		Detach bool `json:"Detach"`
+		Tty    bool `json:"Tty"`
+	}{false, attach.TTY()})
+	if err != nil {
+		return errors.VKError(replyError(err, containerNotFound(name)))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// unblock stream reader when client goes away
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	if stdin := attach.Stdin(); stdin != nil {
+		go func() {
+			io.Copy(conn, stdin) //nolint:errcheck
+			if unixConn, ok := conn.(*net.UnixConn); ok {
+				unixConn.CloseWrite() //nolint:errcheck
+			}
+		}()
+	}
+
+	if attach.TTY() && attach.Resize() != nil {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case size, ok := <-attach.Resize():
+					if !ok {
+						return
+					}
+					query := url.Values{
+						"h": {strconv.Itoa(int(size.Height))},
+						"w": {strconv.Itoa(int(size.Width))},
+					}
+					if err := b.call(ctx, http.MethodPost, execPath+"/resize", query, nil, nil); err != nil {
+						b.log.Debug("exec resize failed ", "container ", name, " error ", err)
+					}
+				}
+			}
+		}()
+	}
+
+	if attach.TTY() {
+		if stdout := attach.Stdout(); stdout != nil {
+			_, err = io.Copy(stdout, reader)
+		}
+	} else {
+		err = demuxStream(reader, attach.Stdout(), attach.Stderr())
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	exitCode, err := b.execExitCode(ctx, execPath)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return utilexec.CodeExitError{
+			Err:  fmt.Errorf("command terminated with non-zero exit code %d", exitCode),
+			Code: exitCode,
+		}
+	}
+	return nil
+}
+
+// hijack sends the request on conn and returns reader of the raw stream
+// which follows the response headers
+func (b *restBackend) hijack(ctx context.Context, conn net.Conn, path string, body interface{}) (*bufio.Reader, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := b.request(ctx, http.MethodPost, path, nil, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	if err := req.Write(conn); err != nil {
+		return nil, errors.Transient(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, errors.Transient(err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		apiErr := &apiError{StatusCode: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(apiErr) //nolint:errcheck
+		return nil, apiErr
+	}
+	return reader, nil
+}
+
+// execExitCode returns exit code of finished exec session. The stream may
+// close shortly before podman records the exit code
+func (b *restBackend) execExitCode(ctx context.Context, execPath string) (int, error) {
+	backOff := initialRetryBackOff
+	for attempt := 0; ; attempt++ {
+		var exec restExec
+		if err := b.get(ctx, execPath+"/json", nil, &exec); err != nil {
+			return 0, replyError(err, nil)
+		}
+		if !exec.Running || attempt >= b.retries {
+			return exec.ExitCode, nil
+		}
+		select {
+		case <-time.After(backOff):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		backOff *= 2
+	}
+}
+
+// demuxStream copies multiplexed stream to stdout and stderr until the
+// stream ends. Either writer can be nil to drop its stream
+func demuxStream(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:8]))
+		var w io.Writer
+		switch header[0] {
+		case restStreamStdout, restStreamStdin:
+			w = stdout
+		case restStreamStderr:
+			w = stderr
+		}
+		if w == nil {
+			w = ioutil.Discard
+		}
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
+
+// Events is not limited by the call timeout, the stream stays open for as
+// long as events are watched
+func (b *restBackend) Events(ctx context.Context, fn func(Event)) error {
+	query := url.Values{"stream": {"true"}}
+	resp, err := b.do(ctx, http.MethodGet, "/events", query, nil)
+	if err != nil {
+		return replyError(err, nil)
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Type   string `json:"Type"`
+			Action string `json:"Action"`
+			Status string `json:"status"`
+			Actor  struct {
+				Attributes map[string]string `json:"Attributes"`
+			} `json:"Actor"`
+		}
+		if err := decoder.Decode(&event); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if event.Type != EventTypeContainer && event.Type != EventTypePod {
+			continue
+		}
+		status := event.Action
+		if status == "" {
+			status = event.Status
+		}
+		fn(Event{
+			Type:   event.Type,
+			Status: status,
+			Name:   event.Actor.Attributes["name"],
+		})
+	}
+}
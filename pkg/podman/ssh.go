@@ -0,0 +1,275 @@
+package podman
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// defaultSSHKeepalive is the interval of keepalive requests of SSH
+	// connections to remote podman hosts
+	defaultSSHKeepalive = 30 * time.Second
+	// sshDialTimeout limits establishing of SSH connection
+	sshDialTimeout = 30 * time.Second
+	defaultSSHPort = "22"
+)
+
+// IsSSHSocket returns true if the socket is podman socket of remote host
+// reached over SSH, ssh://user@host[:port]/run/podman/io.podman
+func IsSSHSocket(socket string) bool {
+	return strings.HasPrefix(socket, "ssh://")
+}
+
+// SSHHostIP returns address of the remote host of ssh:// socket. Host names
+// are resolved, IPv4 addresses are preferred
+func SSHHostIP(socket string) (string, error) {
+	u, err := url.Parse(socket)
+	if err != nil {
+		return "", fmt.Errorf("invalid socket %q: %v", socket, err)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return "", fmt.Errorf("host missing in socket %q", socket)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return "", fmt.Errorf("error resolving host of socket %q: %v", socket, err)
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
+	}
+	return ips[0].String(), nil
+}
+
+// sshTunnel forwards connections of local unix socket to podman socket of
+// remote host over SSH. Pooled connections, exec streams and event watches
+// all dial the local socket, so they work the same as with local podman.
+// SSH connection is established on first use, kept alive and established
+// again once it breaks. Forwarded connections break with it and are
+// redialed by their users
+type sshTunnel struct {
+	addr         string
+	remoteSocket string
+	config       *ssh.ClientConfig
+	keepalive    time.Duration
+	dir          string
+	listener     net.Listener
+	done         chan struct{}
+	log          *zap.SugaredLogger
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// newSSHTunnel starts forwarding of local socket to the podman socket of the
+// ssh:// address. Host keys are verified against knownHostsFile
+func newSSHTunnel(socket, identityFile, knownHostsFile string, keepalive time.Duration, log *zap.SugaredLogger) (*sshTunnel, error) {
+	u, err := url.Parse(socket)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket %q: %v", socket, err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("user missing in socket %q", socket)
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("podman socket path missing in socket %q", socket)
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultSSHPort
+	}
+
+	key, err := ioutil.ReadFile(identityFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH identity file %s: %v", identityFile, err)
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+	if keepalive <= 0 {
+		keepalive = defaultSSHKeepalive
+	}
+
+	dir, err := ioutil.TempDir("", "vkubelet-ssh")
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", filepath.Join(dir, "io.podman"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	t := &sshTunnel{
+		addr:         net.JoinHostPort(u.Hostname(), port),
+		remoteSocket: u.Path,
+		config: &ssh.ClientConfig{
+			User:            u.User.Username(),
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         sshDialTimeout,
+		},
+		keepalive: keepalive,
+		dir:       dir,
+		listener:  listener,
+		done:      make(chan struct{}),
+		log:       log,
+	}
+	go t.serve()
+	go t.keepaliveLoop()
+	return t, nil
+}
+
+// Address returns varlink address of the local end of the tunnel
+func (t *sshTunnel) Address() string {
+	return "unix:" + t.listener.Addr().String()
+}
+
+// Close stops the tunnel and closes SSH connection
+func (t *sshTunnel) Close() error {
+	err := t.listener.Close()
+	close(t.done)
+	t.mu.Lock()
+	if t.client != nil {
+		t.client.Close()
+		t.client = nil
+	}
+	t.mu.Unlock()
+	os.RemoveAll(t.dir)
+	return err
+}
+
+func (t *sshTunnel) serve() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.forward(conn)
+	}
+}
+
+// forward copies data between local connection and new connection to the
+// remote podman socket until either side closes
+func (t *sshTunnel) forward(local net.Conn) {
+	defer local.Close()
+	remote, err := t.dialRemote()
+	if err != nil {
+		t.log.Error("error dialing remote podman socket", " host ", t.addr, " err ", err.Error())
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local) //nolint:errcheck
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote) //nolint:errcheck
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func (t *sshTunnel) dialRemote() (net.Conn, error) {
+	client, err := t.getClient()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.Dial("unix", t.remoteSocket)
+	if err != nil {
+		// channel rejected by the server, e.g. podman socket is not
+		// running, doesn't mean SSH connection is broken
+		if _, rejected := err.(*ssh.OpenChannelError); !rejected {
+			t.reset(client)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// getClient returns SSH connection, establishing new one if there is none
+func (t *sshTunnel) getClient() (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil {
+		return t.client, nil
+	}
+	client, err := ssh.Dial("tcp", t.addr, t.config)
+	if err != nil {
+		return nil, err
+	}
+	t.log.Info("connected to remote podman host ", "host ", t.addr)
+	t.client = client
+	return client, nil
+}
+
+// reset closes broken SSH connection, so next dial establishes new one
+func (t *sshTunnel) reset(client *ssh.Client) {
+	t.mu.Lock()
+	if t.client == client {
+		t.client = nil
+	}
+	t.mu.Unlock()
+	client.Close()
+}
+
+// keepaliveLoop sends keepalive requests over SSH connection. Connections
+// not answering within the interval are closed, so dead hosts are detected
+// without waiting for TCP timeouts
+func (t *sshTunnel) keepaliveLoop() {
+	ticker := time.NewTicker(t.keepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.done:
+			return
+		}
+		t.mu.Lock()
+		client := t.client
+		t.mu.Unlock()
+		if client == nil {
+			continue
+		}
+
+		errc := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			errc <- err
+		}()
+		var err error
+		select {
+		case err = <-errc:
+		case <-time.After(t.keepalive):
+			err = fmt.Errorf("no reply in %v", t.keepalive)
+		}
+		if err != nil {
+			t.log.Info("SSH keepalive failed, reconnecting ", "host ", t.addr, " err ", err.Error())
+			t.reset(client)
+		}
+	}
+}
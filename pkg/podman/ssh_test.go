@@ -0,0 +1,457 @@
+package podman
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// sshServer is SSH server forwarding streamlocal channels to unix sockets,
+// the way sshd forwards connections to remote podman socket
+type sshServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.PublicKey
+
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+	accepted int
+	// keepalives counts keepalive requests, which are left unanswered
+	// when ignoreKeepalive is set
+	keepalives      int
+	ignoreKeepalive bool
+}
+
+func newSSHServer(t *testing.T, clientKey ssh.PublicKey) *sshServer {
+	t.Helper()
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	assert.NilError(t, err)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() != "core" || string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, ssh.ErrNoAuth
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+
+	s := &sshServer{
+		listener: listener,
+		config:   config,
+		hostKey:  signer.PublicKey(),
+		conns:    map[net.Conn]bool{},
+	}
+	go s.serve()
+	return s
+}
+
+func (s *sshServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.accepted++
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *sshServer) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	go func() {
+		for req := range reqs {
+			if req.Type == "keepalive@openssh.com" {
+				s.mu.Lock()
+				s.keepalives++
+				ignore := s.ignoreKeepalive
+				s.mu.Unlock()
+				if ignore {
+					continue
+				}
+			}
+			req.Reply(true, nil) //nolint:errcheck
+		}
+	}()
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "direct-streamlocal@openssh.com" {
+			newChannel.Reject(ssh.UnknownChannelType, newChannel.ChannelType()) //nolint:errcheck
+			continue
+		}
+		var msg struct {
+			SocketPath string
+			Reserved0  string
+			Reserved1  uint32
+		}
+		if err := ssh.Unmarshal(newChannel.ExtraData(), &msg); err != nil {
+			newChannel.Reject(ssh.Prohibited, err.Error()) //nolint:errcheck
+			continue
+		}
+		remote, err := net.Dial("unix", msg.SocketPath)
+		if err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error()) //nolint:errcheck
+			continue
+		}
+		channel, channelReqs, err := newChannel.Accept()
+		if err != nil {
+			remote.Close()
+			continue
+		}
+		go ssh.DiscardRequests(channelReqs)
+		go func() {
+			defer channel.Close()
+			defer remote.Close()
+			go io.Copy(remote, channel) //nolint:errcheck
+			io.Copy(channel, remote)    //nolint:errcheck
+		}()
+	}
+}
+
+// drop breaks all SSH connections, as a network failure or sshd restart does
+func (s *sshServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *sshServer) stats() (active, accepted, keepalives int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns), s.accepted, s.keepalives
+}
+
+func (s *sshServer) setIgnoreKeepalive(ignore bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ignoreKeepalive = ignore
+}
+
+func (s *sshServer) Close() {
+	s.listener.Close()
+	s.drop()
+}
+
+// sshEnv holds SSH server, podman socket echoing lines back behind it and
+// client identity and known hosts files
+type sshEnv struct {
+	server         *sshServer
+	podman         net.Listener
+	dir            string
+	socket         string
+	identityFile   string
+	knownHostsFile string
+}
+
+func newSSHEnv(t *testing.T) *sshEnv {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "podman-ssh")
+	assert.NilError(t, err)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	der, err := x509.MarshalECPrivateKey(clientKey)
+	assert.NilError(t, err)
+	identityFile := filepath.Join(dir, "id_ecdsa")
+	assert.NilError(t, ioutil.WriteFile(identityFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+	clientPublicKey, err := ssh.NewPublicKey(&clientKey.PublicKey)
+	assert.NilError(t, err)
+
+	server := newSSHServer(t, clientPublicKey)
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(server.listener.Addr().String())}, server.hostKey)
+	assert.NilError(t, ioutil.WriteFile(knownHostsFile, []byte(line+"\n"), 0600))
+
+	socket := filepath.Join(dir, "io.podman")
+	listener, err := net.Listen("unix", socket)
+	assert.NilError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn) //nolint:errcheck
+			}()
+		}
+	}()
+
+	return &sshEnv{
+		server:         server,
+		podman:         listener,
+		dir:            dir,
+		socket:         socket,
+		identityFile:   identityFile,
+		knownHostsFile: knownHostsFile,
+	}
+}
+
+func (e *sshEnv) url(path string) string {
+	return "ssh://core@" + e.server.listener.Addr().String() + path
+}
+
+func (e *sshEnv) tunnel(t *testing.T, keepalive time.Duration) *sshTunnel {
+	t.Helper()
+	tunnel, err := newSSHTunnel(e.url(e.socket), e.identityFile, e.knownHostsFile, keepalive, zap.NewNop().Sugar())
+	assert.NilError(t, err)
+	return tunnel
+}
+
+func (e *sshEnv) Close() {
+	e.server.Close()
+	e.podman.Close()
+	os.RemoveAll(e.dir)
+}
+
+// echo sends line over the connection and returns the line read back
+func echo(conn net.Conn, line string) (string, error) {
+	if _, err := conn.Write([]byte(line + "\n")); err != nil {
+		return "", err
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func dialTunnel(t *testing.T, tunnel *sshTunnel) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("unix", tunnel.listener.Addr().String())
+	assert.NilError(t, err)
+	return conn
+}
+
+func TestSSHHostIP(t *testing.T) {
+	for _, tc := range []struct {
+		socket string
+		want   string
+		err    string
+	}{
+		{socket: "ssh://core@192.0.2.10/run/podman/io.podman", want: "192.0.2.10"},
+		{socket: "ssh://core@192.0.2.10:2222/run/podman/io.podman", want: "192.0.2.10"},
+		{socket: "ssh://core@[2001:db8::1]:2222/run/podman/io.podman", want: "2001:db8::1"},
+		{socket: "ssh://core@localhost/run/podman/io.podman", want: "127.0.0.1"},
+		{socket: "ssh:///run/podman/io.podman", err: "host missing"},
+		{socket: "ssh://core@host.invalid/run/podman/io.podman", err: "error resolving host"},
+	} {
+		ip, err := SSHHostIP(tc.socket)
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err, tc.socket)
+			continue
+		}
+		assert.NilError(t, err, tc.socket)
+		assert.Equal(t, ip, tc.want, tc.socket)
+	}
+}
+
+func TestNewSSHTunnelErrors(t *testing.T) {
+	env := newSSHEnv(t)
+	defer env.Close()
+	addr := env.server.listener.Addr().String()
+
+	for _, tc := range []struct {
+		name       string
+		socket     string
+		identity   string
+		knownHosts string
+		err        string
+	}{
+		{name: "user", socket: "ssh://" + addr + "/run/podman/io.podman", err: "user missing"},
+		{name: "path", socket: "ssh://core@" + addr, err: "podman socket path missing"},
+		{name: "url", socket: "ssh://core@[::1/run/podman/io.podman", err: "invalid socket"},
+		{name: "identity", identity: filepath.Join(env.dir, "missing"), err: "no such file"},
+		{name: "invalid identity", identity: env.knownHostsFile, err: "invalid SSH identity file"},
+		{name: "known hosts", knownHosts: filepath.Join(env.dir, "missing"), err: "no such file"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			socket, identity, knownHosts := tc.socket, tc.identity, tc.knownHosts
+			if socket == "" {
+				socket = env.url(env.socket)
+			}
+			if identity == "" {
+				identity = env.identityFile
+			}
+			if knownHosts == "" {
+				knownHosts = env.knownHostsFile
+			}
+			_, err := newSSHTunnel(socket, identity, knownHosts, 0, zap.NewNop().Sugar())
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestSSHTunnel(t *testing.T) {
+	env := newSSHEnv(t)
+	defer env.Close()
+	tunnel := env.tunnel(t, 0)
+	assert.Equal(t, tunnel.addr, env.server.listener.Addr().String())
+	assert.Equal(t, tunnel.keepalive, defaultSSHKeepalive)
+	assert.Equal(t, tunnel.Address(), "unix:"+tunnel.listener.Addr().String())
+
+	// SSH connection is established on first use and shared by forwarded
+	// connections
+	_, accepted, _ := env.server.stats()
+	assert.Equal(t, accepted, 0)
+	first, second := dialTunnel(t, tunnel), dialTunnel(t, tunnel)
+	defer first.Close()
+	defer second.Close()
+	line, err := echo(first, "first")
+	assert.NilError(t, err)
+	assert.Equal(t, line, "first\n")
+	line, err = echo(second, "second")
+	assert.NilError(t, err)
+	assert.Equal(t, line, "second\n")
+	active, accepted, _ := env.server.stats()
+	assert.Equal(t, active, 1)
+	assert.Equal(t, accepted, 1)
+
+	// closing the tunnel closes SSH connection and removes local socket
+	assert.NilError(t, tunnel.Close())
+	waitFor(t, func() bool {
+		active, _, _ := env.server.stats()
+		return active == 0
+	})
+	_, err = os.Stat(tunnel.dir)
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestSSHTunnelRemoteSocketMissing(t *testing.T) {
+	env := newSSHEnv(t)
+	defer env.Close()
+	tunnel, err := newSSHTunnel(env.url(filepath.Join(env.dir, "missing")), env.identityFile, env.knownHostsFile, 0, zap.NewNop().Sugar())
+	assert.NilError(t, err)
+	defer tunnel.Close()
+
+	// rejected channel closes the local connection, but keeps SSH connection
+	conn := dialTunnel(t, tunnel)
+	defer conn.Close()
+	_, err = echo(conn, "ping")
+	assert.Assert(t, err != nil)
+	_, err = tunnel.dialRemote()
+	_, rejected := err.(*ssh.OpenChannelError)
+	assert.Assert(t, rejected, "expected rejected channel, got %v", err)
+	active, accepted, _ := env.server.stats()
+	assert.Equal(t, active, 1)
+	assert.Equal(t, accepted, 1)
+}
+
+func TestSSHTunnelUnknownHost(t *testing.T) {
+	env := newSSHEnv(t)
+	defer env.Close()
+	assert.NilError(t, ioutil.WriteFile(env.knownHostsFile, nil, 0600))
+	tunnel := env.tunnel(t, 0)
+	defer tunnel.Close()
+
+	_, err := tunnel.getClient()
+	assert.ErrorContains(t, err, "knownhosts: key is unknown")
+	assert.Assert(t, is.Nil(tunnel.client))
+}
+
+func TestSSHTunnelReconnect(t *testing.T) {
+	env := newSSHEnv(t)
+	defer env.Close()
+	tunnel := env.tunnel(t, 0)
+	defer tunnel.Close()
+
+	conn := dialTunnel(t, tunnel)
+	defer conn.Close()
+	_, err := echo(conn, "before")
+	assert.NilError(t, err)
+
+	// forwarded connections break with SSH connection, users redial them and
+	// the tunnel establishes new SSH connection
+	env.server.drop()
+	_, err = echo(conn, "broken")
+	assert.Assert(t, err != nil)
+	var line string
+	for i := 0; i < 3; i++ {
+		conn := dialTunnel(t, tunnel)
+		line, err = echo(conn, "after")
+		conn.Close()
+		if err == nil {
+			break
+		}
+	}
+	assert.NilError(t, err)
+	assert.Equal(t, line, "after\n")
+	active, accepted, _ := env.server.stats()
+	assert.Equal(t, active, 1)
+	assert.Equal(t, accepted, 2)
+}
+
+func TestSSHTunnelKeepalive(t *testing.T) {
+	env := newSSHEnv(t)
+	defer env.Close()
+	tunnel := env.tunnel(t, 100*time.Millisecond)
+	defer tunnel.Close()
+
+	// answered keepalives keep the connection
+	conn := dialTunnel(t, tunnel)
+	defer conn.Close()
+	_, err := echo(conn, "ping")
+	assert.NilError(t, err)
+	waitFor(t, func() bool {
+		_, _, keepalives := env.server.stats()
+		return keepalives >= 3
+	})
+	active, accepted, _ := env.server.stats()
+	assert.Equal(t, active, 1)
+	assert.Equal(t, accepted, 1)
+	_, err = echo(conn, "pong")
+	assert.NilError(t, err)
+
+	// connection of host not answering keepalives is torn down
+	env.server.setIgnoreKeepalive(true)
+	waitFor(t, func() bool {
+		active, _, _ := env.server.stats()
+		return active == 0
+	})
+	_, err = echo(conn, "broken")
+	assert.Assert(t, err != nil)
+	tunnel.mu.Lock()
+	assert.Assert(t, is.Nil(tunnel.client))
+	tunnel.mu.Unlock()
+
+	// and the next connection establishes new one
+	env.server.setIgnoreKeepalive(false)
+	conn = dialTunnel(t, tunnel)
+	defer conn.Close()
+	_, err = echo(conn, "again")
+	assert.NilError(t, err)
+	_, accepted, _ = env.server.stats()
+	assert.Equal(t, accepted, 2)
+}
@@ -0,0 +1,51 @@
+package podman
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/state"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+)
+
+// ensureState stores the pod in the state store unless it is there already,
+// so Create can be retried without losing restart history
+func (p podman) ensureState(pod *corev1.Pod) error {
+	_, err := p.state.Get(pod.UID)
+	if !errdefs.IsNotFound(err) {
+		return err
+	}
+	return p.state.Put(state.NewPod(pod))
+}
+
+// kubePod returns kubernetes pod podman pod was created for. Pods created by
+// older versions of the provider are moved from podman labels to the state
+// store
+func (p podman) kubePod(pPod *converter.PodmanPod) (*corev1.Pod, error) {
+	if uid := converter.PodUID(*pPod); uid != "" {
+		s, err := p.state.Get(uid)
+		if err != nil {
+			return nil, err
+		}
+		return s.Pod, nil
+	}
+
+	kpod, legacy, err := converter.GetLegacyKubePod(*pPod)
+	if err != nil {
+		return nil, err
+	}
+	if !legacy {
+		return nil, errdefs.NotFoundf("podman pod %s was not created by the provider", pPod.Config.Name)
+	}
+	// labels of existing podman pods can't be changed, the pod is copied
+	// to the state store to keep restart history there
+	_, err = p.state.Get(kpod.UID)
+	if errdefs.IsNotFound(err) {
+		err = p.state.Put(state.NewPod(kpod))
+		p.log.Info("pod state copied from podman labels to state store ", "pod ", pPod.Config.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kpod, nil
+}
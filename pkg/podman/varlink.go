@@ -0,0 +1,264 @@
+package podman
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/varlink/go/varlink"
+	"go.uber.org/zap"
+	"k8s.io/kubernetes/pkg/credentialprovider"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+)
+
+// varlinkBackend runs calls of podman varlink API over pooled connections
+type varlinkBackend struct {
+	c      *client
+	socket string
+	tunnel *sshTunnel
+	// authFile is the registry auth file read by podman service, varlink
+	// API has no other way to pass registry credentials. authMu is held
+	// for reading by anonymous pulls and for writing by pulls with
+	// credentials, which write the file
+	authFile string
+	authMu   *sync.RWMutex
+	log      *zap.SugaredLogger
+}
+
+// newVarlinkBackend connects to podman varlink socket. Sockets of remote
+// hosts are reached through SSH tunnel
+func newVarlinkBackend(ctx context.Context, cfg *Config) (*varlinkBackend, error) {
+	b := &varlinkBackend{
+		socket:   *cfg.Socket,
+		authFile: *cfg.AuthFile,
+		authMu:   &sync.RWMutex{},
+		log:      cfg.Log,
+	}
+	if IsSSHSocket(b.socket) {
+		tunnel, err := newSSHTunnel(b.socket, cfg.SSHIdentityFile, cfg.SSHKnownHostsFile, cfg.SSHKeepalive, cfg.Log)
+		if err != nil {
+			return nil, err
+		}
+		// fail early when the host is not reachable, the same as for
+		// local socket
+		if _, err := tunnel.getClient(); err != nil {
+			tunnel.Close()
+			return nil, err
+		}
+		b.socket = tunnel.Address()
+		b.tunnel = tunnel
+	}
+	b.c = newClient(b.socket, cfg.PoolSize, cfg.LongPoolSize, cfg.CallTimeout, defaultRetries)
+	// fail early when podman is not reachable, the connection is kept in
+	// the pool
+	conn, err := b.c.acquire(ctx, b.c.short)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	b.c.release(b.c.short, conn, false)
+	// credentials left in the auth file by pull interrupted by crash
+	if b.tunnel == nil {
+		b.authMu.Lock()
+		b.restoreAuthFile()
+		b.authMu.Unlock()
+	}
+	return b, nil
+}
+
+// Close closes pooled connections and SSH tunnel of remote podman
+func (b *varlinkBackend) Close() error {
+	err := b.c.Close()
+	if b.tunnel != nil {
+		if tunnelErr := b.tunnel.Close(); err == nil {
+			err = tunnelErr
+		}
+	}
+	return err
+}
+
+func (b *varlinkBackend) CreatePod(ctx context.Context, create iopodman.PodCreate) (name string, err error) {
+	err = b.c.Call(ctx, func(ctx context.Context, conn *varlink.Connection) (err error) {
+		name, err = iopodman.CreatePod().Call(ctx, conn, create)
+		return err
+	})
+	return name, err
+}
+
+func (b *varlinkBackend) PodExists(ctx context.Context, name string) (bool, error) {
+	err := b.c.CallIdempotent(ctx, func(ctx context.Context, conn *varlink.Connection) error {
+		_, err := iopodman.GetPod().Call(ctx, conn, name)
+		return err
+	})
+	if err != nil {
+		if _, ok := err.(*iopodman.PodNotFound); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *varlinkBackend) InspectPod(ctx context.Context, name string) (*converter.PodmanPod, error) {
+	var podmanJSON string
+	err := b.c.CallIdempotent(ctx, func(ctx context.Context, conn *varlink.Connection) (err error) {
+		podmanJSON, err = iopodman.InspectPod().Call(ctx, conn, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return converter.MarshalPodPod(podmanJSON)
+}
+
+func (b *varlinkBackend) ListPods(ctx context.Context) ([]string, error) {
+	var pods []iopodman.ListPodData
+	err := b.c.CallIdempotent(ctx, func(ctx context.Context, conn *varlink.Connection) (err error) {
+		pods, err = iopodman.ListPods().Call(ctx, conn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	return names, nil
+}
+
+func (b *varlinkBackend) StopPod(ctx context.Context, name string, timeout int64) error {
+	return b.c.CallLong(ctx, func(ctx context.Context, conn *varlink.Connection) error {
+		_, err := iopodman.StopPod().Call(ctx, conn, name, timeout)
+		return err
+	})
+}
+
+func (b *varlinkBackend) RemovePod(ctx context.Context, name string) error {
+	return b.c.Call(ctx, func(ctx context.Context, conn *varlink.Connection) error {
+		_, err := iopodman.RemovePod().Call(ctx, conn, name, true)
+		return err
+	})
+}
+
+func (b *varlinkBackend) CreateContainer(ctx context.Context, create iopodman.Create) error {
+	return b.c.Call(ctx, func(ctx context.Context, conn *varlink.Connection) error {
+		_, err := iopodman.CreateContainer().Call(ctx, conn, create)
+		return err
+	})
+}
+
+func (b *varlinkBackend) ContainerExists(ctx context.Context, name string) (bool, error) {
+	var exists int64
+	err := b.c.CallIdempotent(ctx, func(ctx context.Context, conn *varlink.Connection) (err error) {
+		exists, err = iopodman.ContainerExists().Call(ctx, conn, name)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	// podman reports 0 for existing containers
+	return exists == 0, nil
+}
+
+func (b *varlinkBackend) InspectContainer(ctx context.Context, name string) (*converter.PodmanContainerData, error) {
+	var containerJSON string
+	err := b.c.CallIdempotent(ctx, func(ctx context.Context, conn *varlink.Connection) (err error) {
+		containerJSON, err = iopodman.InspectContainer().Call(ctx, conn, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return converter.MarshalPodmanContainer(containerJSON)
+}
+
+func (b *varlinkBackend) ContainerSize(ctx context.Context, name string) (int64, error) {
+	var container iopodman.Container
+	err := b.c.CallIdempotent(ctx, func(ctx context.Context, conn *varlink.Connection) (err error) {
+		container, err = iopodman.GetContainer().Call(ctx, conn, name)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return container.Rwsize, nil
+}
+
+func (b *varlinkBackend) StartContainer(ctx context.Context, name string) error {
+	return b.c.Call(ctx, func(ctx context.Context, conn *varlink.Connection) error {
+		_, err := iopodman.StartContainer().Call(ctx, conn, name)
+		return err
+	})
+}
+
+func (b *varlinkBackend) StopContainer(ctx context.Context, name string, timeout int64) error {
+	return b.c.CallLong(ctx, func(ctx context.Context, conn *varlink.Connection) error {
+		_, err := iopodman.StopContainer().Call(ctx, conn, name, timeout)
+		return err
+	})
+}
+
+// WaitContainer is a long call, it blocks for container lifetime
+func (b *varlinkBackend) WaitContainer(ctx context.Context, name string) (int64, error) {
+	var exitCode int64
+	err := b.c.CallLong(ctx, func(ctx context.Context, conn *varlink.Connection) (err error) {
+		exitCode, err = iopodman.WaitContainer().Call(ctx, conn, name, int64(defaultWaitInterval/time.Millisecond))
+		return err
+	})
+	return exitCode, err
+}
+
+func (b *varlinkBackend) ContainerStats(ctx context.Context, name string) (*iopodman.ContainerStats, error) {
+	var stat iopodman.ContainerStats
+	err := b.c.CallIdempotent(ctx, func(ctx context.Context, conn *varlink.Connection) (err error) {
+		stat, err = iopodman.GetContainerStats().Call(ctx, conn, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &stat, nil
+}
+
+func (b *varlinkBackend) ImageExists(ctx context.Context, image string) (bool, error) {
+	var exists int64
+	err := b.c.CallIdempotent(ctx, func(ctx context.Context, conn *varlink.Connection) (err error) {
+		exists, err = iopodman.ImageExists().Call(ctx, conn, image)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	// podman reports 0 for existing images
+	return exists == 0, nil
+}
+
+// PullImage pulls the image. PullImage method of varlink API takes the image
+// name only, so credentials are passed in the registry auth file podman
+// service reads, there is no per-pull auth file. Anonymous pulls run in
+// parallel, pull with credentials runs alone, so no other pull runs while
+// the file holds its credentials
+func (b *varlinkBackend) PullImage(ctx context.Context, image string, auth *credentialprovider.AuthConfig) error {
+	// auth file of remote host can't be written
+	if auth != nil && b.tunnel != nil {
+		return fmt.Errorf("image pull secrets are not supported for podman of remote host, log in to %s on the host instead", registryHost(image))
+	}
+	pull := func() error {
+		return b.c.CallLong(ctx, func(ctx context.Context, conn *varlink.Connection) error {
+			_, err := iopodman.PullImage().Call(ctx, conn, image)
+			return err
+		})
+	}
+	if auth == nil {
+		b.authMu.RLock()
+		defer b.authMu.RUnlock()
+		return pull()
+	}
+	b.authMu.Lock()
+	defer b.authMu.Unlock()
+	return b.withAuthFile(registryHost(image), *auth, pull)
+}
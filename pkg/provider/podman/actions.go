@@ -3,6 +3,8 @@ package podman
 import (
 	"context"
 
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
 	"github.com/virtual-kubelet/virtual-kubelet/log"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -17,26 +19,38 @@ const (
 	containerNameKey = "containerName"
 )
 
-// UpdatePod accepts a Pod definition and updates its reference.
+// UpdatePod accepts a Pod definition and queues the pod to be recreated with
+// it, the same way CreatePod queues new pods. Restart history of its
+// containers is kept
 func (p *PodmanV0Provider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
 	log.G(ctx).Infof("receive UpdatePod %q", pod.Name)
-	err := p.c.Update(ctx, pod)
-	if err != nil {
-		return err
+	// rejected update leaves the pod running as it is
+	if reason, message := p.admit(ctx, pod); reason != "" {
+		log.G(ctx).Infof("update of pod %s/%s rejected: %s", pod.Namespace, pod.Name, message)
+		return errdefs.InvalidInputf("%s: pod %s", reason, message)
 	}
 
-	pod, err = p.c.Get(ctx, pod)
-	if err != nil {
+	if err := p.volumes.Setup(pod); err != nil {
 		return err
 	}
 
-	p.notifier(pod)
+	key := converter.BuildKey(pod)
+	p.createMu.Lock()
+	pending := pod.DeepCopy()
+	pending.Status = p.pendingStatus(pod)
+	p.creating[key] = pending
+	p.updating[key] = true
+	delete(p.createFailures, key)
+	p.createMu.Unlock()
+
+	p.notify(pending.DeepCopy())
+	p.createQueue.Add(key)
 	return nil
 }
 
 func (p *PodmanV0Provider) ConfigureNode(ctx context.Context, n *v1.Node) {
 	n.Status.Capacity = p.capacity()
-	n.Status.Allocatable = p.capacity()
+	n.Status.Allocatable = p.allocatable()
 	n.Status.Conditions = p.nodeConditions()
 	n.Status.Addresses = p.nodeAddresses()
 	n.Status.DaemonEndpoints = p.nodeDaemonEndpoints()
@@ -58,6 +72,21 @@ func (p *PodmanV0Provider) capacity() v1.ResourceList {
 	}
 }
 
+// allocatable returns node capacity available to pods, which is capacity
+// without system reserved resources
+func (p *PodmanV0Provider) allocatable() v1.ResourceList {
+	allocatable := p.capacity()
+	for name, value := range p.config.SystemReserved {
+		quantity := allocatable[v1.ResourceName(name)]
+		quantity.Sub(resource.MustParse(value))
+		if quantity.Sign() < 0 {
+			quantity = resource.Quantity{}
+		}
+		allocatable[v1.ResourceName(name)] = quantity
+	}
+	return allocatable
+}
+
 // NodeConditions returns a list of conditions (Ready, OutOfDisk, etc), for updates to the node status
 // within Kubernetes.
 func (p *PodmanV0Provider) nodeConditions() []v1.NodeCondition {
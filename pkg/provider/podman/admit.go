@@ -0,0 +1,149 @@
+package podman
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	resourcehelper "k8s.io/kubernetes/pkg/api/v1/resource"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/podman"
+)
+
+// reasonHostPorts is the reason kubelet reports for pods rejected because of
+// host port conflicts
+const reasonHostPorts = "PodFitsHostPorts"
+
+// reasonUnsupportedVolume is the reason reported for pods of remote podman
+// hosts with volumes created on the host running the provider
+const reasonUnsupportedVolume = "UnsupportedVolume"
+
+// admission tracks pods accepted by the provider, so resources of pods being
+// created concurrently are accounted before their containers exist
+type admission struct {
+	mu     sync.Mutex
+	seeded bool
+	pods   map[string]*v1.Pod
+}
+
+func newAdmission() *admission {
+	return &admission{
+		pods: make(map[string]*v1.Pod),
+	}
+}
+
+// admit checks if the pod fits into node allocatable next to pods already
+// running on the node. Rejected pods get reason and message the same as
+// kubelet uses, like OutOfcpu
+func (p *PodmanV0Provider) admit(ctx context.Context, pod *v1.Pod) (reason, message string) {
+	// emptyDir and API backed volumes are written or mounted on the host
+	// running the provider, remote podman can't bind-mount them
+	if podman.IsSSHSocket(p.config.Socket) {
+		for _, volume := range pod.Spec.Volumes {
+			if converter.IsManagedVolume(volume) {
+				return reasonUnsupportedVolume, fmt.Sprintf("volume %s is not supported with podman of remote host, only hostPath volumes are", volume.Name)
+			}
+		}
+	}
+
+	a := p.admission
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.seeded {
+		// pods created before provider restart
+		list, err := p.c.List(ctx)
+		if err != nil {
+			log.G(ctx).Errorf("error while listing pods for admission: %v", err)
+		} else {
+			for i := range list.Items {
+				a.pods[converter.BuildKey(&list.Items[i])] = list.Items[i].DeepCopy()
+			}
+			a.seeded = true
+		}
+	}
+
+	key := converter.BuildKey(pod)
+	allocatable := p.allocatable()
+	var (
+		usedPods      int64
+		usedCPU       = resource.Quantity{}
+		usedMemory    = resource.Quantity{}
+		requests, _   = resourcehelper.PodRequestsAndLimits(pod)
+		requestCPU    = requests[v1.ResourceCPU]
+		requestMemory = requests[v1.ResourceMemory]
+		usedPorts     []hostPort
+	)
+	for podKey, admitted := range a.pods {
+		if podKey == key {
+			continue
+		}
+		// terminated and deleted pods don't use resources
+		current, err := p.resourceManager.GetPod(admitted.Name, admitted.Namespace)
+		if errors.IsNotFound(err) {
+			delete(a.pods, podKey)
+			continue
+		}
+		if err != nil || current.Status.Phase == v1.PodSucceeded || current.Status.Phase == v1.PodFailed {
+			continue
+		}
+		usedPods++
+		for _, port := range converter.HostPorts(admitted) {
+			usedPorts = append(usedPorts, hostPort{port: port, owner: admitted.Namespace + "/" + admitted.Name})
+		}
+		podRequests, _ := resourcehelper.PodRequestsAndLimits(admitted)
+		usedCPU.Add(podRequests[v1.ResourceCPU])
+		usedMemory.Add(podRequests[v1.ResourceMemory])
+	}
+
+	allocatablePods := allocatable[v1.ResourcePods]
+	if usedPods+1 > allocatablePods.Value() {
+		return outOf(v1.ResourcePods, 1, usedPods, allocatablePods.Value())
+	}
+	allocatableCPU := allocatable[v1.ResourceCPU]
+	if requestCPU.MilliValue() > 0 && requestCPU.MilliValue()+usedCPU.MilliValue() > allocatableCPU.MilliValue() {
+		return outOf(v1.ResourceCPU, requestCPU.MilliValue(), usedCPU.MilliValue(), allocatableCPU.MilliValue())
+	}
+	allocatableMemory := allocatable[v1.ResourceMemory]
+	if requestMemory.Value() > 0 && requestMemory.Value()+usedMemory.Value() > allocatableMemory.Value() {
+		return outOf(v1.ResourceMemory, requestMemory.Value(), usedMemory.Value(), allocatableMemory.Value())
+	}
+
+	for _, port := range converter.HostPorts(pod) {
+		for _, used := range usedPorts {
+			if converter.PortsConflict(port, used.port) {
+				hostIP := port.HostIP
+				if hostIP == "" {
+					hostIP = "0.0.0.0"
+				}
+				return reasonHostPorts, fmt.Sprintf("Predicate %s failed: host port %s:%d/%s is already used by pod %s",
+					reasonHostPorts, hostIP, port.HostPort, port.Protocol, used.owner)
+			}
+		}
+	}
+
+	a.pods[key] = pod.DeepCopy()
+	return "", ""
+}
+
+// hostPort is a host port used by the pod owner
+type hostPort struct {
+	port  v1.ContainerPort
+	owner string
+}
+
+// forgetAdmitted releases resources of the deleted pod
+func (p *PodmanV0Provider) forgetAdmitted(pod *v1.Pod) {
+	p.admission.mu.Lock()
+	defer p.admission.mu.Unlock()
+	delete(p.admission.pods, converter.BuildKey(pod))
+}
+
+func outOf(name v1.ResourceName, requested, used, capacity int64) (reason, message string) {
+	return fmt.Sprintf("OutOf%s", name), fmt.Sprintf("Node didn't have enough resource: %s, requested: %d, used: %d, capacity: %d", name, requested, used, capacity)
+}
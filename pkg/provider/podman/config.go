@@ -4,9 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"strconv"
+	"time"
 
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/virtual-kubelet/podman/pkg/podman"
 )
 
 // loadConfig loads the given json configuration files.
@@ -35,9 +42,54 @@ func loadConfig(providerConfig, nodeName string) (config PodmanConfig, err error
 		if config.Socket == "" {
 			config.Socket = defaultSocket
 		}
+		if config.VolumesDir == "" {
+			config.VolumesDir = defaultVolumesDir
+		}
+		if config.StateFile == "" {
+			config.StateFile = filepath.Join(defaultStateDir, nodeName+".db")
+		}
+		if config.AuthFile == "" {
+			config.AuthFile = defaultAuthFile
+		}
 		if config.DaemonSetDisabled == "" {
 			config.DaemonSetDisabled = defaultDaemonSetDisabled
 		}
+		if config.ReconcileInterval == "" {
+			config.ReconcileInterval = defaultReconcileInterval
+		}
+		if config.CreateWorkers == "" {
+			config.CreateWorkers = defaultCreateWorkers
+		}
+		if config.SyncWorkers == "" {
+			config.SyncWorkers = defaultSyncWorkers
+		}
+		if config.NativeHealthchecks == "" {
+			config.NativeHealthchecks = defaultNativeHealthchecks
+		}
+		if config.PoolSize == "" {
+			config.PoolSize = defaultPoolSize
+		}
+		if config.LongPoolSize == "" {
+			config.LongPoolSize = defaultLongPoolSize
+		}
+		if config.CallTimeout == "" {
+			config.CallTimeout = defaultCallTimeout
+		}
+		if config.SSHKeepalive == "" {
+			config.SSHKeepalive = defaultSSHKeepalive
+		}
+		if podman.IsSSHSocket(config.Socket) {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return config, err
+			}
+			if config.SSHIdentityFile == "" {
+				config.SSHIdentityFile = filepath.Join(home, ".ssh", "id_rsa")
+			}
+			if config.SSHKnownHostsFile == "" {
+				config.SSHKnownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+			}
+		}
 	}
 
 	if _, err = resource.ParseQuantity(config.CPU); err != nil {
@@ -49,9 +101,44 @@ func loadConfig(providerConfig, nodeName string) (config PodmanConfig, err error
 	if _, err = resource.ParseQuantity(config.Pods); err != nil {
 		return config, fmt.Errorf("Invalid pods value %v", config.Pods)
 	}
+	if config.InternalIP != "" && net.ParseIP(config.InternalIP) == nil {
+		return config, fmt.Errorf("Invalid internalIP value %v", config.InternalIP)
+	}
+	for name, value := range config.SystemReserved {
+		if name != string(v1.ResourceCPU) && name != string(v1.ResourceMemory) {
+			return config, fmt.Errorf("Invalid systemReserved resource %v", name)
+		}
+		if _, err = resource.ParseQuantity(value); err != nil {
+			return config, fmt.Errorf("Invalid systemReserved %v value %v", name, value)
+		}
+	}
 	if _, err = strconv.ParseBool(config.DaemonSetDisabled); err != nil {
 		return config, fmt.Errorf("Invalid daemonSetDisabled value %v", config.DaemonSetDisabled)
 	}
+	if interval, err := time.ParseDuration(config.ReconcileInterval); err != nil || interval <= 0 {
+		return config, fmt.Errorf("Invalid reconcileInterval value %v", config.ReconcileInterval)
+	}
+	if workers, err := strconv.Atoi(config.CreateWorkers); err != nil || workers <= 0 {
+		return config, fmt.Errorf("Invalid createWorkers value %v", config.CreateWorkers)
+	}
+	if workers, err := strconv.Atoi(config.SyncWorkers); err != nil || workers <= 0 {
+		return config, fmt.Errorf("Invalid syncWorkers value %v", config.SyncWorkers)
+	}
+	if _, err = strconv.ParseBool(config.NativeHealthchecks); err != nil {
+		return config, fmt.Errorf("Invalid nativeHealthchecks value %v", config.NativeHealthchecks)
+	}
+	if size, err := strconv.Atoi(config.PoolSize); err != nil || size <= 0 {
+		return config, fmt.Errorf("Invalid poolSize value %v", config.PoolSize)
+	}
+	if size, err := strconv.Atoi(config.LongPoolSize); err != nil || size <= 0 {
+		return config, fmt.Errorf("Invalid longPoolSize value %v", config.LongPoolSize)
+	}
+	if timeout, err := time.ParseDuration(config.CallTimeout); err != nil || timeout <= 0 {
+		return config, fmt.Errorf("Invalid callTimeout value %v", config.CallTimeout)
+	}
+	if keepalive, err := time.ParseDuration(config.SSHKeepalive); err != nil || keepalive <= 0 {
+		return config, fmt.Errorf("Invalid sshKeepalive value %v", config.SSHKeepalive)
+	}
 
 	return config, nil
 }
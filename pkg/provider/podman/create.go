@@ -3,12 +3,23 @@ package podman
 import (
 	"context"
 
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/podman"
+	"github.com/virtual-kubelet/podman/pkg/util/errors"
 	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
 	"github.com/virtual-kubelet/virtual-kubelet/log"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// CreatePod accepts a Pod definition and stores it in memory.
+// maxCreateRetries is how many times creation of a pod failing with podman
+// error is retried before the pod is reported failed, the same as
+// virtual-kubelet retries pods
+const maxCreateRetries = 20
+
+// CreatePod accepts a Pod definition and queues it for creation. Images are
+// pulled and containers started in the background and status changes are
+// reported through the notifier
 func (p *PodmanV0Provider) CreatePod(ctx context.Context, pod *v1.Pod) error {
 	// if DS is disabled, fail eary
 	if p.config.DaemonSetDisabled == "true" {
@@ -22,7 +33,7 @@ func (p *PodmanV0Provider) CreatePod(ctx context.Context, pod *v1.Pod) error {
 					}
 				}
 				pod.Status.Message = "DaemonSetDisabled is disabled on this node"
-				p.notifier(pod)
+				p.notify(pod)
 				return errdefs.InvalidInput("DaemonSetDisabled is disabled on this node")
 			}
 		}
@@ -30,16 +41,227 @@ func (p *PodmanV0Provider) CreatePod(ctx context.Context, pod *v1.Pod) error {
 	}
 
 	log.G(ctx).Infof("receive CreatePod %q", pod.Name)
-	err := p.c.Create(ctx, pod)
-	if err != nil {
-		return err
+	// rejected pod is not created and reported as failed only. Returning
+	// error would make virtual-kubelet overwrite the status with
+	// ProviderFailed and retry admission
+	if reason, message := p.admit(ctx, pod); reason != "" {
+		log.G(ctx).Infof("pod %s/%s rejected: %s", pod.Namespace, pod.Name, message)
+		pod.Status.Phase = v1.PodFailed
+		pod.Status.Reason = reason
+		pod.Status.Message = "Pod " + message
+		p.notify(pod)
+		return nil
 	}
 
-	pod, err = p.c.Get(ctx, pod)
-	if err != nil {
+	if err := p.volumes.Setup(pod); err != nil {
 		return err
 	}
 
-	p.notifier(pod)
+	// pod is reported as pending right away, images are pulled and
+	// containers created and started by create workers
+	key := converter.BuildKey(pod)
+	p.createMu.Lock()
+	if _, pending := p.creating[key]; pending {
+		p.createMu.Unlock()
+		return nil
+	}
+	pending := pod.DeepCopy()
+	pending.Status = p.pendingStatus(pod)
+	p.creating[key] = pending
+	p.createMu.Unlock()
+
+	p.notify(pending.DeepCopy())
+	p.createQueue.Add(key)
 	return nil
 }
+
+// runCreateWorker creates pods accepted by CreatePod until the queue is shut
+// down
+func (p *PodmanV0Provider) runCreateWorker() {
+	for {
+		key, quit := p.createQueue.Get()
+		if quit {
+			return
+		}
+		if p.createPod(key.(string)) {
+			p.createQueue.AddRateLimited(key)
+		} else {
+			p.createQueue.Forget(key)
+		}
+		p.createQueue.Done(key)
+	}
+}
+
+// createPod pulls images, creates and starts containers of the pending pod,
+// removing containers of the old spec first for updated pods, and notifies
+// about its status. It returns true if creation failed, or
+// reconcile is starting the pod, and should be retried. Failures of
+// unreachable podman are retried until it can be reached, other failures up
+// to maxCreateRetries times
+func (p *PodmanV0Provider) createPod(key string) bool {
+	ctx := context.Background()
+	p.createMu.Lock()
+	pod, pending := p.creating[key]
+	update := p.updating[key]
+	p.createMu.Unlock()
+	// deleted before it was created
+	if !pending {
+		return false
+	}
+
+	// keep reconcile from starting the pod meanwhile. Create or Start of
+	// reconcile already in flight is waited for by retrying
+	if _, running := p.starting.LoadOrStore(key, struct{}{}); running {
+		log.G(ctx).Debugf("pod %s/%s is being started, retrying creation", pod.Namespace, pod.Name)
+		return true
+	}
+	defer p.starting.Delete(key)
+
+	// missing images are reported in the pod status and pulled again by
+	// reconcile with back-off
+	var err error
+	if update {
+		err = p.c.Update(ctx, pod)
+	} else {
+		err = p.c.Create(ctx, pod)
+	}
+
+	p.createMu.Lock()
+	_, pending = p.creating[key]
+	// pod stays pending while creation is retried, Create continues where
+	// it stopped
+	transient := errors.IsTransient(err)
+	failed := err != nil && !transient && !podman.IsImagePullError(err)
+	if failed {
+		p.createFailures[key]++
+	}
+	retry := pending && (transient || failed && p.createFailures[key] <= maxCreateRetries)
+	if !retry {
+		delete(p.creating, key)
+		delete(p.updating, key)
+		delete(p.createFailures, key)
+	}
+	p.createMu.Unlock()
+	if retry {
+		if transient {
+			log.G(ctx).Infof("podman unavailable while creating pod %s/%s, retrying: %v", pod.Namespace, pod.Name, err)
+		} else {
+			log.G(ctx).Errorf("error while creating pod %s/%s, retrying: %v", pod.Namespace, pod.Name, err)
+		}
+		return true
+	}
+	if !pending {
+		log.G(ctx).Infof("pod %s/%s was deleted while being created", pod.Namespace, pod.Name)
+		if err := p.c.Delete(ctx, pod); err != nil {
+			log.G(ctx).Errorf("error while deleting pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+		return false
+	}
+	if failed {
+		log.G(ctx).Errorf("error while creating pod %s/%s, giving up: %v", pod.Namespace, pod.Name, err)
+		p.createFailed(pod, err)
+		return false
+	}
+
+	current, err := p.c.Get(ctx, pod)
+	if err != nil {
+		log.G(ctx).Errorf("error while getting pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return false
+	}
+	p.restarts.applyPod(current)
+	p.probes.sync(current)
+	p.probes.apply(current)
+	p.notify(current)
+	return false
+}
+
+// createFailed reports pod which couldn't be created the same way
+// virtual-kubelet does for provider errors
+func (p *PodmanV0Provider) createFailed(pod *v1.Pod, err error) {
+	failed := pod.DeepCopy()
+	failed.Status.Phase = v1.PodPending
+	if pod.Spec.RestartPolicy == v1.RestartPolicyNever {
+		failed.Status.Phase = v1.PodFailed
+	}
+	failed.Status.Reason = "ProviderFailed"
+	failed.Status.Message = err.Error()
+	p.notify(failed)
+}
+
+// getPending returns copy of the pod accepted by CreatePod which is not
+// created in podman yet
+func (p *PodmanV0Provider) getPending(key string) (*v1.Pod, bool) {
+	p.createMu.Lock()
+	defer p.createMu.Unlock()
+	pod, pending := p.creating[key]
+	if !pending {
+		return nil, false
+	}
+	return pod.DeepCopy(), true
+}
+
+// listPending returns copies of pods accepted by CreatePod which are not
+// created in podman yet and not in listed
+func (p *PodmanV0Provider) listPending(listed map[string]bool) []*v1.Pod {
+	p.createMu.Lock()
+	defer p.createMu.Unlock()
+	var pods []*v1.Pod
+	for key, pod := range p.creating {
+		if !listed[key] {
+			pods = append(pods, pod.DeepCopy())
+		}
+	}
+	return pods
+}
+
+// forgetPending drops the pod from pods waiting to be created. Create worker
+// removes it from podman if creation is already in progress
+func (p *PodmanV0Provider) forgetPending(key string) {
+	p.createMu.Lock()
+	defer p.createMu.Unlock()
+	delete(p.creating, key)
+	delete(p.updating, key)
+	delete(p.createFailures, key)
+}
+
+// pendingStatus returns status of the pod which containers are not created
+// yet
+func (p *PodmanV0Provider) pendingStatus(pod *v1.Pod) v1.PodStatus {
+	now := metav1.Now()
+	status := v1.PodStatus{
+		Phase:     v1.PodPending,
+		HostIP:    p.internalIP,
+		StartTime: &now,
+		QOSClass:  converter.GetPodQOS(pod),
+		Conditions: []v1.PodCondition{
+			{
+				Type:               v1.PodScheduled,
+				Status:             v1.ConditionTrue,
+				LastTransitionTime: now,
+			},
+		},
+	}
+	for _, c := range pod.Spec.InitContainers {
+		status.InitContainerStatuses = append(status.InitContainerStatuses, waitingStatus(c, "ContainerCreating"))
+	}
+	reason := "ContainerCreating"
+	if len(pod.Spec.InitContainers) > 0 {
+		reason = "PodInitializing"
+	}
+	for _, c := range pod.Spec.Containers {
+		status.ContainerStatuses = append(status.ContainerStatuses, waitingStatus(c, reason))
+	}
+	return status
+}
+
+func waitingStatus(c v1.Container, reason string) v1.ContainerStatus {
+	return v1.ContainerStatus{
+		Name:  c.Name,
+		Image: c.Image,
+		State: v1.ContainerState{
+			Waiting: &v1.ContainerStateWaiting{
+				Reason: reason,
+			},
+		},
+	}
+}
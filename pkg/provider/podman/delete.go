@@ -3,6 +3,8 @@ package podman
 import (
 	"context"
 
+	"github.com/virtual-kubelet/podman/pkg/converter"
+
 	"github.com/virtual-kubelet/virtual-kubelet/log"
 	v1 "k8s.io/api/core/v1"
 )
@@ -10,5 +12,13 @@ import (
 // DeletePod deletes the specified pod out of memory.
 func (p *PodmanV0Provider) DeletePod(ctx context.Context, pod *v1.Pod) (err error) {
 	log.G(ctx).Infof("receive DeletePod %s", pod.Namespace, pod.Name)
-	return p.c.Delete(ctx, pod)
+	p.forgetPending(converter.BuildKey(pod))
+	p.restarts.forget(converter.BuildKey(pod))
+	p.evicted.Delete(converter.BuildKey(pod))
+	p.forgetAdmitted(pod)
+	p.probes.remove(converter.BuildKey(pod))
+	if err := p.c.Delete(ctx, pod); err != nil {
+		return err
+	}
+	return p.volumes.Cleanup(pod)
 }
@@ -0,0 +1,76 @@
+package podman
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+)
+
+const reasonEvicted = "Evicted"
+
+// evict stops all containers of the pod and marks it failed. Evicted pods are
+// not restarted, their controllers are expected to replace them
+func (p *PodmanV0Provider) evict(ctx context.Context, pod *v1.Pod, message string) {
+	log.G(ctx).Infof("evicting pod %s/%s: %s", pod.Namespace, pod.Name, message)
+	p.evicted.Store(converter.BuildKey(pod), message)
+	if err := p.c.Stop(ctx, pod); err != nil {
+		log.G(ctx).Errorf("error while stopping evicted pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+}
+
+// checkEviction evicts the pod when its emptyDir volumes or containers use
+// more local storage than their limits allow. It returns true if the pod was
+// evicted
+func (p *PodmanV0Provider) checkEviction(ctx context.Context, pod, currentPod *v1.Pod) bool {
+	message, err := p.volumes.ExceededLimit(pod)
+	if err != nil {
+		log.G(ctx).Errorf("error while checking volumes of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+
+	if message == "" && hasEphemeralStorageLimit(pod) {
+		usage, err := p.c.DiskUsage(ctx, pod)
+		if err != nil {
+			log.G(ctx).Errorf("error while checking disk usage of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+		for _, c := range append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+			limit, ok := c.Resources.Limits[v1.ResourceEphemeralStorage]
+			if ok && usage[c.Name] > limit.Value() {
+				message = fmt.Sprintf("Container %s exceeded its local ephemeral storage limit %q.", c.Name, limit.String())
+				break
+			}
+		}
+	}
+
+	if message == "" {
+		return false
+	}
+	p.evict(ctx, pod, message)
+	p.applyEviction(currentPod)
+	return true
+}
+
+func hasEphemeralStorageLimit(pod *v1.Pod) bool {
+	for _, c := range append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		if _, ok := c.Resources.Limits[v1.ResourceEphemeralStorage]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEviction reports pod evicted by the provider as failed and returns
+// true if the pod was evicted
+func (p *PodmanV0Provider) applyEviction(pod *v1.Pod) bool {
+	message, ok := p.evicted.Load(converter.BuildKey(pod))
+	if !ok {
+		return false
+	}
+	pod.Status.Phase = v1.PodFailed
+	pod.Status.Reason = reasonEvicted
+	pod.Status.Message = message.(string)
+	return true
+}
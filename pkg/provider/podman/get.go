@@ -3,13 +3,14 @@ package podman
 import (
 	"context"
 	"io"
-	"io/ioutil"
-	"strings"
+	"time"
 
 	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/podman"
 
 	//"github.com/davecgh/go-spew/spew"
 
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
 	"github.com/virtual-kubelet/virtual-kubelet/log"
 	"github.com/virtual-kubelet/virtual-kubelet/node/api"
 	v1 "k8s.io/api/core/v1"
@@ -23,21 +24,42 @@ func (p *PodmanV0Provider) GetPod(ctx context.Context, namespace, name string) (
 	if err != nil {
 		return nil, err
 	}
-	return p.c.GetByName(ctx, podName)
+	pod, err = p.c.GetByName(ctx, podName)
+	if err != nil {
+		if pending, ok := p.getPending(podName); ok && errdefs.IsNotFound(err) {
+			return pending, nil
+		}
+		return nil, err
+	}
+	withoutInternalAnnotations(pod)
+	p.applyEviction(pod)
+	p.restarts.applyPod(pod)
+	p.probes.apply(pod)
+	return pod, nil
 }
 
 // GetContainerLogs retrieves the logs of a container by name from the provider.
-// TODO: To implement
+// Follow, Previous and SinceTime log options are not supported:
+// api.ContainerLogOpts of virtual-kubelet v1.1.0 carries none of them, so the
+// pod http server rejects requests setting them
 func (p *PodmanV0Provider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts api.ContainerLogOpts) (io.ReadCloser, error) {
 	log.G(ctx).Infof("receive GetContainerLogs %q", podName)
-	return ioutil.NopCloser(strings.NewReader("")), nil
+	logOpts := podman.ContainerLogOpts{
+		Tail:       opts.Tail,
+		LimitBytes: opts.LimitBytes,
+		Timestamps: opts.Timestamps,
+	}
+	if opts.Since > 0 {
+		logOpts.Since = time.Now().Add(-opts.Since)
+	}
+	return p.c.GetContainerLogs(ctx, namespace, podName, containerName, logOpts)
 }
 
 // RunInContainer executes a command in a container in the pod, copying data
 // between in/out/err and the container's stdin/stdout/stderr.
 func (p *PodmanV0Provider) RunInContainer(ctx context.Context, namespace, name, container string, cmd []string, attach api.AttachIO) error {
-	log.G(context.TODO()).Infof("receive ExecInContainer %q", container)
-	return nil
+	log.G(ctx).Infof("receive ExecInContainer %q", container)
+	return p.c.ExecInContainer(ctx, namespace, name, container, cmd, attach)
 }
 
 // GetPodStatus returns the status of a pod by name that is "running".
@@ -61,8 +83,11 @@ func (p *PodmanV0Provider) GetPods(ctx context.Context) ([]*v1.Pod, error) {
 		return nil, err
 	}
 	result := []*v1.Pod{}
-	for _, i := range list.Items {
-		result = append(result, &i)
+	listed := map[string]bool{}
+	for i := range list.Items {
+		pod := &list.Items[i]
+		listed[converter.BuildKey(pod)] = true
+		result = append(result, withoutInternalAnnotations(pod))
 	}
-	return result, nil
+	return append(result, p.listPending(listed)...), nil
 }
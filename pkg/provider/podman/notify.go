@@ -4,8 +4,23 @@ import (
 	"context"
 
 	v1 "k8s.io/api/core/v1"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
 )
 
 func (p *PodmanV0Provider) NotifyPods(ctx context.Context, notifier func(pod *v1.Pod)) {
 	p.notifier = notifier
 }
+
+// notify reports the pod to virtual-kubelet. All status updates go through
+// it, so annotations used by the provider only are never published
+func (p *PodmanV0Provider) notify(pod *v1.Pod) {
+	p.notifier(withoutInternalAnnotations(pod))
+}
+
+// withoutInternalAnnotations drops annotations set by the converter for the
+// provider itself. Unhealthy containers are acted on by reconcile only
+func withoutInternalAnnotations(pod *v1.Pod) *v1.Pod {
+	delete(pod.Annotations, converter.UnhealthyContainersAnnotation)
+	return pod
+}
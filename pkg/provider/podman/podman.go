@@ -2,20 +2,40 @@ package podman
 
 import (
 	"context"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/virtual-kubelet/podman/pkg/manager"
 	"github.com/virtual-kubelet/podman/pkg/podman"
+	"github.com/virtual-kubelet/podman/pkg/state"
+	"github.com/virtual-kubelet/podman/pkg/volume"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const (
 	// Provider configuration defaults.
-	defaultCPUCapacity       = "5"
-	defaultMemoryCapacity    = "2Gi"
-	defaultPodCapacity       = "10"
-	defaultSocket            = "unix:/run/podman/io.podman"
-	defaultDaemonSetDisabled = "true"
+	defaultCPUCapacity        = "5"
+	defaultMemoryCapacity     = "2Gi"
+	defaultPodCapacity        = "10"
+	defaultSocket             = "unix:/run/podman/io.podman"
+	defaultVolumesDir         = "/var/lib/vkubelet/pods"
+	defaultStateDir           = "/var/lib/vkubelet/state"
+	defaultAuthFile           = "/run/containers/0/auth.json"
+	defaultDaemonSetDisabled  = "true"
+	defaultCreateWorkers      = "2"
+	defaultSyncWorkers        = "4"
+	defaultReconcileInterval  = "1m"
+	defaultNativeHealthchecks = "false"
+	defaultPoolSize           = "4"
+	defaultLongPoolSize       = "4"
+	defaultCallTimeout        = "1m"
+	defaultSSHKeepalive       = "30s"
+
+	// maxWatchBackOff limits delay between restarts of podman event watch
+	maxWatchBackOff = 30 * time.Second
 )
 
 // PodmanV0Provider implements the virtual-kubelet provider interface and stores pods in memory.
@@ -29,6 +49,33 @@ type PodmanV0Provider struct {
 	daemonEndpointPort int32
 	c                  podman.Podman
 	resourceManager    *manager.ResourceManager
+	volumes            *volume.Manager
+	state              *state.Store
+	// starting tracks pods with podman Create or Start in flight
+	starting sync.Map
+	restarts *restartTracker
+	// evicted holds eviction messages of pods evicted by the provider
+	evicted   sync.Map
+	admission *admission
+	probes    *probeManager
+
+	// creating holds pods accepted by CreatePod and UpdatePod which are not
+	// created in podman yet, keyed by pod key. updating marks the updated
+	// ones, which are recreated. createFailures counts their failed
+	// creation attempts not caused by unreachable podman
+	createMu       sync.Mutex
+	creating       map[string]*v1.Pod
+	updating       map[string]bool
+	createFailures map[string]int
+	createQueue    workqueue.RateLimitingInterface
+
+	// syncQueue holds keys of pods which status is to be synced by sync
+	// workers. A pod is never synced by two workers at once
+	syncQueue workqueue.DelayingInterface
+
+	// stop is closed by Close to stop background workers
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 // PodmanProvider is like PodmanV0Provider, but implements the PodNotifier interface
@@ -42,58 +89,209 @@ type PodmanConfig struct {
 	Memory string `json:"memory,omitempty"`
 	Pods   string `json:"pods,omitempty"`
 
+	// Socket is the podman varlink address, unix:/run/podman/io.podman,
+	// or ssh://user@host[:port]/run/podman/io.podman for podman of remote
+	// host reached over SSH. libpod REST API of podman 2 and newer is used
+	// for unix:///run/podman/podman.sock addresses. Pods of remote hosts
+	// can use hostPath volumes only, other volumes and image pull secrets
+	// are written on the host running the provider
 	Socket string `json:"socket,omitempty"`
+	// SSHIdentityFile is the private key used for ssh:// sockets,
+	// ~/.ssh/id_rsa by default
+	SSHIdentityFile string `json:"sshIdentityFile,omitempty"`
+	// SSHKnownHostsFile holds host keys accepted for ssh:// sockets,
+	// ~/.ssh/known_hosts by default
+	SSHKnownHostsFile string `json:"sshKnownHostsFile,omitempty"`
+	// SSHKeepalive is the interval of keepalive requests of ssh://
+	// sockets. Connections not answering are closed and established again
+	SSHKeepalive string `json:"sshKeepalive,omitempty"`
+	// InternalIP overrides the node address passed by virtual-kubelet. It is
+	// reported as node InternalIP and as hostIP of pods. Nodes with ssh://
+	// socket default to the address of the remote host
+	InternalIP string `json:"internalIP,omitempty"`
+	// VolumesDir is the host directory where secret, configMap, projected
+	// and downwardAPI volumes of pods are written
+	VolumesDir string `json:"volumesDir,omitempty"`
+	// StateFile keeps pods created in podman together with their assigned
+	// resources and restart history, /var/lib/vkubelet/state/<node>.db by
+	// default. Every node needs its own file
+	StateFile string `json:"stateFile,omitempty"`
+	// AuthFile is the registry auth file read by the podman service,
+	// REGISTRY_AUTH_FILE of the service if it is set. Image pull secrets
+	// are written there only for the duration of the pull and pulls are
+	// serialized meanwhile
+	AuthFile string `json:"authFile,omitempty"`
 
 	DaemonSetDisabled string `json:"daemonSetDisabled,omitempty"`
+
+	// ReconcileInterval is how often status of all pods is polled from
+	// podman. Status changes are picked up from podman events as they
+	// happen, polling only catches up on missed events
+	ReconcileInterval string `json:"reconcileInterval,omitempty"`
+
+	// CreateWorkers is the number of pods pulled, created and started
+	// concurrently
+	CreateWorkers string `json:"createWorkers,omitempty"`
+	// SyncWorkers is the number of pods which status is synced
+	// concurrently, so a slow pod doesn't hold back status of others
+	SyncWorkers string `json:"syncWorkers,omitempty"`
+
+	// NativeHealthchecks runs exec liveness probes as podman healthchecks
+	// instead of from the provider, so they keep running while the
+	// provider is down. Unhealthy containers are restarted the same as
+	// ones failing liveness probes run by the provider
+	NativeHealthchecks string `json:"nativeHealthchecks,omitempty"`
+
+	// PoolSize is the number of concurrent short podman calls, such as
+	// status and stats, and of connections kept for them. It applies to
+	// both varlink and REST API
+	PoolSize string `json:"poolSize,omitempty"`
+	// LongPoolSize is the number of concurrent long running podman calls,
+	// such as image pulls, stops, waits for init containers and logs
+	LongPoolSize string `json:"longPoolSize,omitempty"`
+	// CallTimeout limits duration of short podman calls. Calls failing
+	// on broken connections are retried over new ones when it is safe
+	CallTimeout string `json:"callTimeout,omitempty"`
+
+	// SystemReserved is the amount of cpu and memory reserved for the
+	// system and not available to pods, like kubelet --system-reserved
+	SystemReserved map[string]string `json:"systemReserved,omitempty"`
 }
 
 // NewPodmanProviderPodmanConfig creates a new PodmanV0Provider. podman legacy provider does not implement the new asynchronous podnotifier interface
-func NewPodmanV0ProviderPodmanConfig(config PodmanConfig, nodeName, operatingSystem string, resourceManager *manager.ResourceManager) (*PodmanV0Provider, error) {
-	client, err := podman.New(context.Background(), nil)
-	if err != nil {
-		return nil, err
+func NewPodmanV0ProviderPodmanConfig(config PodmanConfig, nodeName, operatingSystem string, internalIP string, daemonEndpointPort int32, resourceManager *manager.ResourceManager, recorder record.EventRecorder) (*PodmanV0Provider, error) {
+	if config.InternalIP != "" {
+		internalIP = config.InternalIP
+	} else if podman.IsSSHSocket(config.Socket) {
+		// podman runs on the remote host, not on the host of vkubelet
+		ip, err := podman.SSHHostIP(config.Socket)
+		if err != nil {
+			return nil, err
+		}
+		internalIP = ip
 	}
 
 	provider := PodmanV0Provider{
-		nodeName:        nodeName,
-		operatingSystem: operatingSystem,
-		config:          config,
-		startTime:       time.Now(),
-		c:               client,
-		resourceManager: resourceManager,
+		nodeName:           nodeName,
+		operatingSystem:    operatingSystem,
+		config:             config,
+		startTime:          time.Now(),
+		internalIP:         internalIP,
+		daemonEndpointPort: daemonEndpointPort,
+		resourceManager:    resourceManager,
+		admission:          newAdmission(),
+		creating:           make(map[string]*v1.Pod),
+		updating:           make(map[string]bool),
+		createFailures:     make(map[string]int),
+		createQueue:        workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "podman-create"),
+		syncQueue:          workqueue.NewNamedDelayingQueue("podman-sync"),
+		stop:               make(chan struct{}),
 		// By default notifier is set to a function which is a no-op. In the event we've implemented the PodNotifier interface,
 		// it will be set, and then we'll call a real underlying implementation.
 		// This makes it easier in the sense we don't need to wrap each method.
 		notifier: func(pod *v1.Pod) {},
 	}
 
+	provider.probes = newProbeManager(&provider)
+	provider.volumes = volume.New(config.VolumesDir, resourceManager, provider.allocatable())
+
+	store, err := state.Open(config.StateFile)
+	if err != nil {
+		return nil, err
+	}
+	provider.state = store
+	provider.restarts, err = newRestartTracker(store)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	poolSize, _ := strconv.Atoi(config.PoolSize)
+	longPoolSize, _ := strconv.Atoi(config.LongPoolSize)
+	callTimeout, _ := time.ParseDuration(config.CallTimeout)
+	sshKeepalive, _ := time.ParseDuration(config.SSHKeepalive)
+	client, err := podman.New(context.Background(), &podman.Config{
+		Socket:             &config.Socket,
+		VolumesDir:         &config.VolumesDir,
+		AuthFile:           &config.AuthFile,
+		ResourceManager:    resourceManager,
+		NodeAllocatable:    provider.allocatable(),
+		HostIP:             internalIP,
+		NativeHealthchecks: config.NativeHealthchecks == "true",
+		PoolSize:           poolSize,
+		LongPoolSize:       longPoolSize,
+		CallTimeout:        callTimeout,
+		SSHIdentityFile:    config.SSHIdentityFile,
+		SSHKnownHostsFile:  config.SSHKnownHostsFile,
+		SSHKeepalive:       sshKeepalive,
+		Recorder:           recorder,
+		State:              store,
+	})
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	provider.c = client
+
+	workers, _ := strconv.Atoi(config.CreateWorkers)
+	if workers <= 0 {
+		workers, _ = strconv.Atoi(defaultCreateWorkers)
+	}
+	for i := 0; i < workers; i++ {
+		go provider.runCreateWorker()
+	}
+	workers, _ = strconv.Atoi(config.SyncWorkers)
+	if workers <= 0 {
+		workers, _ = strconv.Atoi(defaultSyncWorkers)
+	}
+	for i := 0; i < workers; i++ {
+		go provider.runSyncWorker()
+	}
+
+	go provider.watchEvents()
 	go provider.reconcile()
 	return &provider, nil
 }
 
+// Close stops background workers of the provider and closes podman
+// connections. Pods keep running in podman
+func (p *PodmanV0Provider) Close() error {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+	p.createQueue.ShutDown()
+	p.syncQueue.ShutDown()
+	p.probes.stopAll()
+	err := p.c.Close()
+	if serr := p.state.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
+
 // NewPodmanV0Provider creates a new PodmanV0Provider
-func NewPodmanV0Provider(providerConfig, nodeName, operatingSystem string, resourceManager *manager.ResourceManager) (*PodmanV0Provider, error) {
+func NewPodmanV0Provider(providerConfig, nodeName, operatingSystem string, internalIP string, daemonEndpointPort int32, resourceManager *manager.ResourceManager, recorder record.EventRecorder) (*PodmanV0Provider, error) {
 	config, err := loadConfig(providerConfig, nodeName)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewPodmanV0ProviderPodmanConfig(config, nodeName, operatingSystem, resourceManager)
+	return NewPodmanV0ProviderPodmanConfig(config, nodeName, operatingSystem, internalIP, daemonEndpointPort, resourceManager, recorder)
 }
 
 // NewPodmanProviderPodmanConfig creates a new PodmanProvider with the given config
-func NewPodmanProviderPodmanConfig(config PodmanConfig, nodeName, operatingSystem string, resourceManager *manager.ResourceManager) (*PodmanProvider, error) {
-	p, err := NewPodmanV0ProviderPodmanConfig(config, nodeName, operatingSystem, resourceManager)
+func NewPodmanProviderPodmanConfig(config PodmanConfig, nodeName, operatingSystem string, internalIP string, daemonEndpointPort int32, resourceManager *manager.ResourceManager, recorder record.EventRecorder) (*PodmanProvider, error) {
+	p, err := NewPodmanV0ProviderPodmanConfig(config, nodeName, operatingSystem, internalIP, daemonEndpointPort, resourceManager, recorder)
 
 	return &PodmanProvider{PodmanV0Provider: p}, err
 }
 
 // NewPodmanProvider creates a new PodmanProvider, which implements the PodNotifier interface
-func NewPodmanProvider(providerConfig, nodeName, operatingSystem string, resourceManager *manager.ResourceManager) (*PodmanProvider, error) {
+func NewPodmanProvider(providerConfig, nodeName, operatingSystem string, internalIP string, daemonEndpointPort int32, resourceManager *manager.ResourceManager, recorder record.EventRecorder) (*PodmanProvider, error) {
 	config, err := loadConfig(providerConfig, nodeName)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewPodmanProviderPodmanConfig(config, nodeName, operatingSystem, resourceManager)
+	return NewPodmanProviderPodmanConfig(config, nodeName, operatingSystem, internalIP, daemonEndpointPort, resourceManager, recorder)
 }
@@ -0,0 +1,497 @@
+package podman
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+)
+
+const (
+	// maxProbeOutput limits output of exec and HTTP probes kept for
+	// messages, same as in kubelet
+	maxProbeOutput = 10 * 1024
+)
+
+type probeType string
+
+const (
+	liveness  probeType = "Liveness"
+	readiness probeType = "Readiness"
+)
+
+// probeManager runs liveness and readiness probes of running containers.
+// Every probe has its own worker, started when the container starts and
+// stopped when it stops or restarts
+type probeManager struct {
+	p *PodmanV0Provider
+
+	mu      sync.Mutex
+	workers map[probeKey]*probeWorker
+	// ready holds readiness results keyed by "<pod key>/<container>"
+	ready map[string]bool
+	// unhealthy holds start time of containers stopped for failing podman
+	// healthcheck, keyed by "<pod key>/<container>"
+	unhealthy map[string]time.Time
+}
+
+type probeKey struct {
+	container string
+	probeType probeType
+}
+
+func newProbeManager(p *PodmanV0Provider) *probeManager {
+	return &probeManager{
+		p:         p,
+		workers:   make(map[probeKey]*probeWorker),
+		ready:     make(map[string]bool),
+		unhealthy: make(map[string]time.Time),
+	}
+}
+
+// sync starts probe workers for running containers of the pod and stops
+// workers of containers which are not running anymore
+func (m *probeManager) sync(pod *v1.Pod) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	podKey := converter.BuildKey(pod)
+	running := map[string]time.Time{}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running != nil {
+			running[status.Name] = status.State.Running.StartedAt.Time
+		}
+	}
+
+	for _, c := range pod.Spec.Containers {
+		key := podKey + "/" + c.Name
+		for probeType, probe := range map[probeType]*v1.Probe{liveness: c.LivenessProbe, readiness: c.ReadinessProbe} {
+			if probe == nil {
+				continue
+			}
+			// run by podman
+			if probeType == liveness && m.p.config.NativeHealthchecks == "true" && converter.HasNativeHealthcheck(probe) {
+				continue
+			}
+			wk := probeKey{container: key, probeType: probeType}
+			worker, exists := m.workers[wk]
+			startedAt, isRunning := running[c.Name]
+			if exists && (!isRunning || !worker.startedAt.Equal(startedAt)) {
+				worker.stop()
+				delete(m.workers, wk)
+				exists = false
+				if probeType == readiness {
+					delete(m.ready, key)
+				}
+			}
+			if exists || !isRunning {
+				continue
+			}
+			worker = &probeWorker{
+				m:         m,
+				pod:       pod.DeepCopy(),
+				container: c,
+				probeType: probeType,
+				probe:     probe,
+				startedAt: startedAt,
+				done:      make(chan struct{}),
+			}
+			m.workers[wk] = worker
+			go worker.run()
+		}
+	}
+}
+
+// remove stops all probe workers of the pod
+func (m *probeManager) remove(podKey string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for wk, worker := range m.workers {
+		if strings.HasPrefix(wk.container, podKey+"/") {
+			worker.stop()
+			delete(m.workers, wk)
+		}
+	}
+	for key := range m.ready {
+		if strings.HasPrefix(key, podKey+"/") {
+			delete(m.ready, key)
+		}
+	}
+	for key := range m.unhealthy {
+		if strings.HasPrefix(key, podKey+"/") {
+			delete(m.unhealthy, key)
+		}
+	}
+}
+
+// stopUnhealthy stops running containers which podman healthcheck reports
+// unhealthy, the same as liveness probe workers stop containers failing
+// liveness probes run by the provider. Containers are restarted according to
+// pod restartPolicy with restart back-off
+func (m *probeManager) stopUnhealthy(ctx context.Context, pod *v1.Pod) {
+	unhealthy := converter.UnhealthyContainers(pod)
+	if len(unhealthy) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	podKey := converter.BuildKey(pod)
+	for _, name := range unhealthy {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name != name || status.State.Running == nil {
+				continue
+			}
+			key := podKey + "/" + name
+			startedAt := status.State.Running.StartedAt.Time
+			// already being stopped
+			if stopped, ok := m.unhealthy[key]; ok && stopped.Equal(startedAt) {
+				continue
+			}
+			m.unhealthy[key] = startedAt
+			log.G(ctx).Infof("container %s failed liveness healthcheck, will be restarted", key)
+			go func(pod *v1.Pod, name string) {
+				if err := m.p.c.StopContainer(ctx, pod, name); err != nil {
+					log.G(ctx).Errorf("error while stopping container %s: %v", key, err)
+				}
+			}(pod.DeepCopy(), name)
+		}
+	}
+}
+
+// stopAll stops all probe workers
+func (m *probeManager) stopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for wk, worker := range m.workers {
+		worker.stop()
+		delete(m.workers, wk)
+	}
+}
+
+// apply sets readiness of containers with readiness probes from probe
+// results and updates pod Ready and ContainersReady conditions
+func (m *probeManager) apply(pod *v1.Pod) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	podKey := converter.BuildKey(pod)
+	probed := map[string]bool{}
+	for _, c := range pod.Spec.Containers {
+		if c.ReadinessProbe != nil {
+			probed[c.Name] = true
+		}
+	}
+
+	var notReady []string
+	for i := range pod.Status.ContainerStatuses {
+		status := &pod.Status.ContainerStatuses[i]
+		if probed[status.Name] {
+			// status already reflects podman healthcheck
+			status.Ready = status.Ready && m.ready[podKey+"/"+status.Name]
+		}
+		if !status.Ready {
+			notReady = append(notReady, status.Name)
+		}
+	}
+
+	for i := range pod.Status.Conditions {
+		condition := &pod.Status.Conditions[i]
+		if condition.Type != v1.PodReady && condition.Type != v1.ContainersReady {
+			continue
+		}
+		// conditions of not initialized pods are set by the converter
+		if condition.Reason == "ContainersNotInitialized" {
+			continue
+		}
+		if len(notReady) == 0 {
+			condition.Status = v1.ConditionTrue
+			condition.Reason = ""
+			condition.Message = ""
+			continue
+		}
+		condition.Status = v1.ConditionFalse
+		condition.Reason = "ContainersNotReady"
+		condition.Message = fmt.Sprintf("containers with unready status: %v", notReady)
+	}
+}
+
+// setReady records readiness result and returns true if it changed
+func (m *probeManager) setReady(key string, ready bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous, known := m.ready[key]
+	m.ready[key] = ready
+	return !known || previous != ready
+}
+
+// probeWorker periodically runs a single probe of a container
+type probeWorker struct {
+	m         *probeManager
+	pod       *v1.Pod
+	container v1.Container
+	probeType probeType
+	probe     *v1.Probe
+	startedAt time.Time
+
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func (w *probeWorker) stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+func (w *probeWorker) run() {
+	ctx := context.Background()
+	podKey := converter.BuildKey(w.pod)
+	key := podKey + "/" + w.container.Name
+
+	period := time.Duration(w.probe.PeriodSeconds) * time.Second
+	if period <= 0 {
+		period = 10 * time.Second
+	}
+	successThreshold := int(w.probe.SuccessThreshold)
+	if successThreshold <= 0 {
+		successThreshold = 1
+	}
+	failureThreshold := int(w.probe.FailureThreshold)
+	if failureThreshold <= 0 {
+		failureThreshold = 3
+	}
+
+	delay := time.Until(w.startedAt.Add(time.Duration(w.probe.InitialDelaySeconds) * time.Second))
+	if delay > 0 {
+		select {
+		case <-w.done:
+			return
+		case <-time.After(delay):
+		}
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	var successes, failures int
+	for {
+		ok, output, err := w.m.p.runProbe(ctx, w.pod, w.container, w.probe)
+		if err != nil {
+			log.G(ctx).Debugf("%s probe of container %s errored: %v", w.probeType, key, err)
+		}
+		if ok {
+			successes++
+			failures = 0
+		} else {
+			failures++
+			successes = 0
+			log.G(ctx).Infof("%s probe of container %s failed: %s", w.probeType, key, output)
+		}
+
+		switch w.probeType {
+		case readiness:
+			changed := false
+			if successes >= successThreshold {
+				changed = w.m.setReady(key, true)
+			} else if failures >= failureThreshold {
+				changed = w.m.setReady(key, false)
+			}
+			if changed {
+				w.m.p.syncQueue.Add(podKey)
+			}
+		case liveness:
+			if failures >= failureThreshold {
+				log.G(ctx).Infof("container %s failed liveness probe, will be restarted", key)
+				// container is restarted according to pod restartPolicy
+				// by the reconcile loop
+				if err := w.m.p.c.StopContainer(ctx, w.pod, w.container.Name); err != nil {
+					log.G(ctx).Errorf("error while stopping container %s: %v", key, err)
+				}
+				return
+			}
+		}
+
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runProbe runs the probe once and returns if it succeeded together with the
+// probe output
+func (p *PodmanV0Provider) runProbe(ctx context.Context, pod *v1.Pod, container v1.Container, probe *v1.Probe) (bool, string, error) {
+	timeout := time.Duration(probe.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch {
+	case probe.Exec != nil:
+		return p.execProbe(ctx, pod, container, probe.Exec)
+	case probe.HTTPGet != nil:
+		return p.httpProbe(ctx, pod, container, probe.HTTPGet, timeout)
+	case probe.TCPSocket != nil:
+		return p.tcpProbe(ctx, pod, container, probe.TCPSocket)
+	}
+	return false, "", fmt.Errorf("missing probe handler")
+}
+
+func (p *PodmanV0Provider) execProbe(ctx context.Context, pod *v1.Pod, container v1.Container, action *v1.ExecAction) (bool, string, error) {
+	output := &limitedBuffer{limit: maxProbeOutput}
+	err := p.c.ExecInContainer(ctx, pod.Namespace, pod.Name, container.Name, action.Command, &probeIO{output: output})
+	if err != nil {
+		if exitErr, ok := err.(utilexec.ExitError); ok {
+			return false, fmt.Sprintf("command %v exited with %d: %s", action.Command, exitErr.ExitStatus(), output.String()), nil
+		}
+		return false, err.Error(), err
+	}
+	return true, output.String(), nil
+}
+
+func (p *PodmanV0Provider) httpProbe(ctx context.Context, pod *v1.Pod, container v1.Container, action *v1.HTTPGetAction, timeout time.Duration) (bool, string, error) {
+	port, err := resolvePort(action.Port, container)
+	if err != nil {
+		return false, err.Error(), err
+	}
+	host := action.Host
+	if host == "" {
+		host = pod.Status.PodIP
+	}
+	scheme := strings.ToLower(string(action.Scheme))
+	if scheme == "" {
+		scheme = "http"
+	}
+	path := action.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	target := &url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(host, strconv.Itoa(port)),
+	}
+	if u, err := url.Parse(path); err == nil {
+		target.Path = u.Path
+		target.RawQuery = u.RawQuery
+	}
+
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return false, err.Error(), err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "kube-probe/podman")
+	for _, header := range action.HTTPHeaders {
+		if header.Name == "Host" {
+			req.Host = header.Value
+			continue
+		}
+		req.Header.Add(header.Name, header.Value)
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err.Error(), nil
+	}
+	defer resp.Body.Close()
+	body := &limitedBuffer{limit: maxProbeOutput}
+	io.Copy(body, resp.Body) //nolint:errcheck
+
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusBadRequest {
+		return true, body.String(), nil
+	}
+	return false, fmt.Sprintf("HTTP probe failed with statuscode: %d", resp.StatusCode), nil
+}
+
+func (p *PodmanV0Provider) tcpProbe(ctx context.Context, pod *v1.Pod, container v1.Container, action *v1.TCPSocketAction) (bool, string, error) {
+	port, err := resolvePort(action.Port, container)
+	if err != nil {
+		return false, err.Error(), err
+	}
+	host := action.Host
+	if host == "" {
+		host = pod.Status.PodIP
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return false, err.Error(), nil
+	}
+	conn.Close()
+	return true, "", nil
+}
+
+// resolvePort returns port number of numeric or named probe port
+func resolvePort(port intstr.IntOrString, container v1.Container) (int, error) {
+	if port.Type == intstr.Int {
+		return port.IntValue(), nil
+	}
+	for _, p := range container.Ports {
+		if p.Name == port.StrVal {
+			return int(p.ContainerPort), nil
+		}
+	}
+	if n, err := strconv.Atoi(port.StrVal); err == nil {
+		return n, nil
+	}
+	return 0, fmt.Errorf("couldn't find port %s in container %s", port.StrVal, container.Name)
+}
+
+// probeIO collects output of exec probes
+type probeIO struct {
+	output *limitedBuffer
+}
+
+func (p *probeIO) Stdin() io.Reader            { return nil }
+func (p *probeIO) Stdout() io.WriteCloser      { return p.output }
+func (p *probeIO) Stderr() io.WriteCloser      { return p.output }
+func (p *probeIO) TTY() bool                   { return false }
+func (p *probeIO) Resize() <-chan api.TermSize { return nil }
+
+// limitedBuffer keeps up to limit bytes written to it and drops the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(data []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		if len(data) > room {
+			b.Buffer.Write(data[:room]) //nolint:errcheck
+		} else {
+			b.Buffer.Write(data) //nolint:errcheck
+		}
+	}
+	return len(data), nil
+}
+
+func (b *limitedBuffer) Close() error {
+	return nil
+}
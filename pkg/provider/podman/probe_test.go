@@ -0,0 +1,319 @@
+package podman
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestResolvePort(t *testing.T) {
+	container := v1.Container{
+		Name: "nginx",
+		Ports: []v1.ContainerPort{
+			{Name: "http", ContainerPort: 80},
+			{Name: "metrics", ContainerPort: 9090},
+		},
+	}
+	for _, tc := range []struct {
+		port intstr.IntOrString
+		want int
+		err  bool
+	}{
+		{port: intstr.FromInt(8080), want: 8080},
+		{port: intstr.FromString("http"), want: 80},
+		{port: intstr.FromString("metrics"), want: 9090},
+		{port: intstr.FromString("8443"), want: 8443},
+		{port: intstr.FromString("admin"), err: true},
+	} {
+		port, err := resolvePort(tc.port, container)
+		if tc.err {
+			assert.Assert(t, err != nil, tc.port.String())
+			continue
+		}
+		assert.NilError(t, err, tc.port.String())
+		assert.Equal(t, port, tc.want, tc.port.String())
+	}
+}
+
+func TestHTTPProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/healthz":
+			if req.URL.Query().Get("verbose") == "1" {
+				w.Write([]byte("all good")) //nolint:errcheck
+			}
+		case "/headers":
+			if req.Host != "web.local" || req.Header.Get("X-Probe") != "1" || req.Header.Get("User-Agent") != "kube-probe/podman" {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		case "/not-modified":
+			w.WriteHeader(http.StatusNotModified)
+		case "/slow":
+			time.Sleep(1500 * time.Millisecond)
+		default:
+			code, _ := strconv.Atoi(req.URL.Path[1:])
+			w.WriteHeader(code)
+		}
+	}))
+	defer server.Close()
+	_, portString, err := net.SplitHostPort(server.Listener.Addr().String())
+	assert.NilError(t, err)
+	port, _ := strconv.Atoi(portString)
+
+	p := &PodmanV0Provider{}
+	pod := newTestPod("web", "nginx")
+	pod.Status.PodIP = "127.0.0.1"
+	container := pod.Spec.Containers[0]
+	container.Ports = []v1.ContainerPort{{Name: "http", ContainerPort: int32(port)}}
+
+	for _, tc := range []struct {
+		name    string
+		action  v1.HTTPGetAction
+		ok      bool
+		output  string
+		timeout int32
+	}{
+		{name: "ok", action: v1.HTTPGetAction{Path: "/healthz?verbose=1", Port: intstr.FromInt(port)}, ok: true, output: "all good"},
+		{name: "named port", action: v1.HTTPGetAction{Path: "healthz", Port: intstr.FromString("http")}, ok: true},
+		{name: "host and headers", action: v1.HTTPGetAction{
+			Path: "/headers",
+			Port: intstr.FromInt(port),
+			HTTPHeaders: []v1.HTTPHeader{
+				{Name: "Host", Value: "web.local"},
+				{Name: "X-Probe", Value: "1"},
+			},
+		}, ok: true},
+		{name: "no content", action: v1.HTTPGetAction{Path: "/204", Port: intstr.FromInt(port)}, ok: true},
+		{name: "redirect status", action: v1.HTTPGetAction{Path: "/not-modified", Port: intstr.FromInt(port)}, ok: true},
+		{name: "bad request", action: v1.HTTPGetAction{Path: "/400", Port: intstr.FromInt(port)}, output: "HTTP probe failed with statuscode: 400"},
+		{name: "server error", action: v1.HTTPGetAction{Path: "/503", Port: intstr.FromInt(port)}, output: "HTTP probe failed with statuscode: 503"},
+		{name: "timeout", action: v1.HTTPGetAction{Path: "/slow", Port: intstr.FromInt(port)}, timeout: 1},
+		{name: "explicit host", action: v1.HTTPGetAction{Host: "127.0.0.2", Path: "/healthz", Port: intstr.FromInt(port)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			probe := &v1.Probe{
+				Handler:        v1.Handler{HTTPGet: &tc.action},
+				TimeoutSeconds: tc.timeout,
+			}
+			ok, output, err := p.runProbe(context.Background(), pod, container, probe)
+			assert.NilError(t, err)
+			assert.Equal(t, ok, tc.ok, output)
+			if tc.output != "" {
+				assert.Equal(t, output, tc.output)
+			}
+		})
+	}
+
+	// unknown named port is an error of the probe itself
+	probe := &v1.Probe{Handler: v1.Handler{HTTPGet: &v1.HTTPGetAction{Port: intstr.FromString("admin")}}}
+	ok, _, err := p.runProbe(context.Background(), pod, container, probe)
+	assert.Assert(t, !ok)
+	assert.ErrorContains(t, err, "couldn't find port admin")
+}
+
+func TestTCPProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	p := &PodmanV0Provider{}
+	pod := newTestPod("web", "nginx")
+	pod.Status.PodIP = "127.0.0.1"
+	container := pod.Spec.Containers[0]
+	container.Ports = []v1.ContainerPort{{Name: "db", ContainerPort: int32(port)}}
+	probe := func(port intstr.IntOrString) *v1.Probe {
+		return &v1.Probe{Handler: v1.Handler{TCPSocket: &v1.TCPSocketAction{Port: port}}}
+	}
+
+	ok, _, err := p.runProbe(context.Background(), pod, container, probe(intstr.FromInt(port)))
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+	ok, _, err = p.runProbe(context.Background(), pod, container, probe(intstr.FromString("db")))
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+
+	listener.Close()
+	ok, output, err := p.runProbe(context.Background(), pod, container, probe(intstr.FromInt(port)))
+	assert.NilError(t, err)
+	assert.Assert(t, !ok)
+	assert.Assert(t, is.Contains(output, "connection refused"))
+
+	_, _, err = p.runProbe(context.Background(), pod, container, &v1.Probe{})
+	assert.ErrorContains(t, err, "missing probe handler")
+}
+
+func TestExecProbe(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.Close()
+	pod := newTestPod("web", "nginx")
+	assert.NilError(t, tp.createPod(t, pod))
+	tp.waitNotified(t, pod, phase(v1.PodRunning))
+	tp.server.SetExec(func(container string, cmd []string) (string, string, int) {
+		if cmd[0] == "check" {
+			return "healthy", "", 0
+		}
+		return "", "not ready", 1
+	})
+	container := pod.Spec.Containers[0]
+	probe := func(cmd ...string) *v1.Probe {
+		return &v1.Probe{Handler: v1.Handler{Exec: &v1.ExecAction{Command: cmd}}}
+	}
+
+	ok, output, err := tp.runProbe(context.Background(), pod, container, probe("check"))
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+	assert.Equal(t, output, "healthy")
+
+	ok, output, err = tp.runProbe(context.Background(), pod, container, probe("fail"))
+	assert.NilError(t, err)
+	assert.Assert(t, !ok)
+	assert.Equal(t, output, "command [fail] exited with 1: not ready")
+
+	// container which is not running can't be probed
+	assert.NilError(t, tp.server.Exit("default-web-nginx", 0))
+	ok, _, err = tp.runProbe(context.Background(), pod, container, probe("check"))
+	assert.Assert(t, !ok)
+	assert.Assert(t, err != nil)
+}
+
+// probeResults is exec function of the fake server returning results of
+// exec probes set by the test and counting them
+type probeResults struct {
+	mu    sync.Mutex
+	ok    bool
+	calls int
+}
+
+func (r *probeResults) exec(container string, cmd []string) (string, string, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	if r.ok {
+		return "", "", 0
+	}
+	return "", "failed", 1
+}
+
+func (r *probeResults) set(ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ok = ok
+}
+
+func (r *probeResults) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
+
+// startWorker runs probe worker of the first container of the running pod
+func startWorker(t *testing.T, tp *testProvider, pod *v1.Pod, probeType probeType, probe *v1.Probe) *probeWorker {
+	t.Helper()
+	current := tp.waitNotified(t, pod, phase(v1.PodRunning))
+	worker := &probeWorker{
+		m:         tp.probes,
+		pod:       current,
+		container: current.Spec.Containers[0],
+		probeType: probeType,
+		probe:     probe,
+		startedAt: time.Now(),
+		done:      make(chan struct{}),
+	}
+	go worker.run()
+	return worker
+}
+
+func TestProbeWorkerReadiness(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.Close()
+	pod := newTestPod("web", "nginx")
+	assert.NilError(t, tp.createPod(t, pod))
+	results := &probeResults{ok: true}
+	tp.server.SetExec(results.exec)
+
+	worker := startWorker(t, tp, pod, readiness, &v1.Probe{
+		Handler:             v1.Handler{Exec: &v1.ExecAction{Command: []string{"check"}}},
+		InitialDelaySeconds: 1,
+		PeriodSeconds:       1,
+		SuccessThreshold:    2,
+		FailureThreshold:    2,
+	})
+	defer worker.stop()
+	ready := func() (bool, bool) {
+		tp.probes.mu.Lock()
+		defer tp.probes.mu.Unlock()
+		ready, known := tp.probes.ready["default-web/nginx"]
+		return ready, known
+	}
+
+	// first probe runs after initial delay, readiness is set after
+	// successThreshold successes
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, results.count(), 0)
+	time.Sleep(time.Second)
+	assert.Equal(t, results.count(), 1)
+	_, known := ready()
+	assert.Assert(t, !known)
+	time.Sleep(time.Second)
+	isReady, _ := ready()
+	assert.Assert(t, isReady)
+
+	// single failure doesn't change readiness, failureThreshold does
+	results.set(false)
+	time.Sleep(time.Second)
+	isReady, _ = ready()
+	assert.Assert(t, isReady)
+	time.Sleep(time.Second)
+	isReady, _ = ready()
+	assert.Assert(t, !isReady)
+}
+
+func TestProbeWorkerLiveness(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.Close()
+	pod := newTestPod("web", "nginx")
+	pod.Spec.RestartPolicy = v1.RestartPolicyNever
+	assert.NilError(t, tp.createPod(t, pod))
+	results := &probeResults{}
+	tp.server.SetExec(results.exec)
+
+	worker := startWorker(t, tp, pod, liveness, &v1.Probe{
+		Handler:          v1.Handler{Exec: &v1.ExecAction{Command: []string{"check"}}},
+		PeriodSeconds:    1,
+		FailureThreshold: 2,
+	})
+	defer worker.stop()
+
+	// container is stopped after failureThreshold failures and the worker
+	// stops probing it
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, results.count(), 1)
+	status, _ := tp.server.ContainerStatus("default-web-nginx")
+	assert.Equal(t, status, "running")
+	time.Sleep(time.Second)
+	assert.Equal(t, results.count(), 2)
+	status, _ = tp.server.ContainerStatus("default-web-nginx")
+	assert.Equal(t, status, "exited")
+	assert.Equal(t, tp.server.Calls("StopContainer"), 1)
+	time.Sleep(time.Second)
+	assert.Equal(t, results.count(), 2)
+}
@@ -0,0 +1,508 @@
+package podman
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/iopodman"
+	"github.com/virtual-kubelet/podman/pkg/manager"
+	"github.com/virtual-kubelet/podman/pkg/podman/podmantest"
+)
+
+const testImage = "docker.io/library/busybox:latest"
+
+// testProvider is PodmanProvider running against fake podman server. Pods
+// added to the provider are listed by its resource manager, the same as pods
+// assigned to the node
+type testProvider struct {
+	*PodmanProvider
+	server     *podmantest.Server
+	indexer    cache.Indexer
+	rm         *manager.ResourceManager
+	dir        string
+	configPath string
+
+	mu       sync.Mutex
+	notified map[string]*v1.Pod
+	// history holds all notified statuses in order
+	history []*v1.Pod
+}
+
+// newTestProvider starts provider with test config changed by opts
+func newTestProvider(t *testing.T, opts ...func(*PodmanConfig)) *testProvider {
+	t.Helper()
+	server, err := podmantest.NewServer()
+	assert.NilError(t, err)
+	dir, err := ioutil.TempDir("", "podman-provider")
+	assert.NilError(t, err)
+
+	cfg := PodmanConfig{
+		CPU:               "2",
+		Memory:            "1Gi",
+		Socket:            server.Socket,
+		VolumesDir:        filepath.Join(dir, "pods"),
+		StateFile:         filepath.Join(dir, "state.db"),
+		AuthFile:          filepath.Join(dir, "auth.json"),
+		ReconcileInterval: "100ms",
+		CallTimeout:       "5s",
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	config, err := json.Marshal(map[string]PodmanConfig{"node": cfg})
+	assert.NilError(t, err)
+	configPath := filepath.Join(dir, "config.json")
+	assert.NilError(t, ioutil.WriteFile(configPath, config, 0600))
+
+	newIndexer := func() cache.Indexer {
+		return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	}
+	indexer := newIndexer()
+	rm, err := manager.NewResourceManager(
+		corev1listers.NewPodLister(indexer),
+		corev1listers.NewSecretLister(newIndexer()),
+		corev1listers.NewConfigMapLister(newIndexer()),
+		corev1listers.NewServiceLister(newIndexer()),
+		corev1listers.NewServiceAccountLister(newIndexer()),
+	)
+	assert.NilError(t, err)
+
+	tp := &testProvider{
+		server:     server,
+		indexer:    indexer,
+		rm:         rm,
+		dir:        dir,
+		configPath: configPath,
+	}
+	tp.start(t)
+	return tp
+}
+
+func (tp *testProvider) start(t *testing.T) {
+	t.Helper()
+	p, err := NewPodmanProvider(tp.configPath, "node", "Linux", "10.0.0.1", 10250, tp.rm, record.NewFakeRecorder(100))
+	assert.NilError(t, err)
+	tp.mu.Lock()
+	tp.notified = map[string]*v1.Pod{}
+	tp.history = nil
+	tp.mu.Unlock()
+	p.NotifyPods(context.Background(), func(pod *v1.Pod) {
+		tp.mu.Lock()
+		defer tp.mu.Unlock()
+		tp.notified[pod.Namespace+"/"+pod.Name] = pod.DeepCopy()
+		tp.history = append(tp.history, pod.DeepCopy())
+	})
+	tp.PodmanProvider = p
+}
+
+// restart replaces the provider with a new one, pods keep running in podman
+func (tp *testProvider) restart(t *testing.T) {
+	t.Helper()
+	assert.NilError(t, tp.PodmanProvider.Close())
+	tp.start(t)
+}
+
+func (tp *testProvider) Close() {
+	tp.PodmanProvider.Close()
+	tp.server.Close()
+	os.RemoveAll(tp.dir)
+}
+
+// createPod assigns the pod to the node and creates it
+func (tp *testProvider) createPod(t *testing.T, pod *v1.Pod) error {
+	t.Helper()
+	assert.NilError(t, tp.indexer.Add(pod))
+	return tp.CreatePod(context.Background(), pod)
+}
+
+// waitNotified waits until the last status notified for the pod satisfies
+// cond and returns it
+func (tp *testProvider) waitNotified(t *testing.T, pod *v1.Pod, cond func(*v1.Pod) bool) *v1.Pod {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		tp.mu.Lock()
+		notified := tp.notified[pod.Namespace+"/"+pod.Name]
+		tp.mu.Unlock()
+		if notified != nil && cond(notified) {
+			return notified
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pod %s/%s not notified in time, last notified %v", pod.Namespace, pod.Name, notified)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestPod(name string, containers ...string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID("uid-" + name),
+		},
+		Spec: v1.PodSpec{
+			NodeName:      "node",
+			RestartPolicy: v1.RestartPolicyAlways,
+		},
+	}
+	for _, c := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{
+			Name:    c,
+			Image:   testImage,
+			Command: []string{"sleep"},
+			Args:    []string{"3600"},
+		})
+	}
+	return pod
+}
+
+func phase(phase v1.PodPhase) func(*v1.Pod) bool {
+	return func(pod *v1.Pod) bool {
+		return pod.Status.Phase == phase
+	}
+}
+
+func TestProviderPodLifecycle(t *testing.T) {
+	ctx := context.Background()
+	tp := newTestProvider(t)
+	defer tp.Close()
+	pod := newTestPod("web", "nginx", "sidecar")
+
+	assert.NilError(t, tp.createPod(t, pod))
+	notified := tp.waitNotified(t, pod, phase(v1.PodRunning))
+	assert.Equal(t, notified.Status.HostIP, "10.0.0.1")
+	assert.Assert(t, notified.Status.PodIP != "")
+
+	current, err := tp.GetPod(ctx, "default", "web")
+	assert.NilError(t, err)
+	assert.Equal(t, current.Status.Phase, v1.PodRunning)
+	other := newTestPod("db", "postgres")
+	assert.NilError(t, tp.createPod(t, other))
+	tp.waitNotified(t, other, phase(v1.PodRunning))
+	pods, err := tp.GetPods(ctx)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(pods, 2))
+	assert.Assert(t, pods[0].Name != pods[1].Name, "pods must not alias each other")
+	assert.NilError(t, tp.DeletePod(ctx, other))
+	assert.NilError(t, tp.indexer.Delete(other))
+
+	tp.server.SetStats("default-web-nginx", iopodman.ContainerStats{Cpu: 50, Cpu_nano: 2000, Mem_usage: 1024})
+	summary, err := tp.GetStatsSummary(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, summary.Node.NodeName, "node")
+	assert.Assert(t, is.Len(summary.Pods, 1))
+	assert.Equal(t, summary.Pods[0].PodRef.Name, "web")
+
+	// exited container is restarted right away the first time, status is
+	// picked up from podman events
+	assert.NilError(t, tp.server.Exit("default-web-sidecar", 1))
+	tp.waitNotified(t, pod, func(pod *v1.Pod) bool {
+		for _, s := range pod.Status.ContainerStatuses {
+			if s.Name == "sidecar" {
+				return s.RestartCount == 1 && s.State.Running != nil
+			}
+		}
+		return false
+	})
+	status, _ := tp.server.ContainerStatus("default-web-sidecar")
+	assert.Equal(t, status, "running")
+
+	assert.NilError(t, tp.DeletePod(ctx, pod))
+	assert.NilError(t, tp.indexer.Delete(pod))
+	_, err = tp.GetPod(ctx, "default", "web")
+	assert.Assert(t, errdefs.IsNotFound(err), "expected not found, got %v", err)
+	assert.Assert(t, is.Len(tp.server.Pods(), 0))
+}
+
+func TestProviderRestartHistoryPersisted(t *testing.T) {
+	ctx := context.Background()
+	tp := newTestProvider(t)
+	defer tp.Close()
+	pod := newTestPod("web", "nginx")
+
+	assert.NilError(t, tp.createPod(t, pod))
+	tp.waitNotified(t, pod, phase(v1.PodRunning))
+	assert.NilError(t, tp.server.Exit("default-web-nginx", 1))
+	tp.waitNotified(t, pod, func(pod *v1.Pod) bool {
+		return pod.Status.ContainerStatuses[0].RestartCount == 1
+	})
+
+	tp.restart(t)
+	current, err := tp.GetPod(ctx, "default", "web")
+	assert.NilError(t, err)
+	assert.Equal(t, current.Status.Phase, v1.PodRunning)
+	// GetPod reports the same restart history as reconcile
+	assert.Equal(t, current.Status.ContainerStatuses[0].RestartCount, int32(1))
+	assert.Assert(t, current.Status.ContainerStatuses[0].LastTerminationState.Terminated != nil)
+	tp.waitNotified(t, pod, func(pod *v1.Pod) bool {
+		status := pod.Status.ContainerStatuses[0]
+		return status.RestartCount == 1 && status.LastTerminationState.Terminated != nil
+	})
+	// podman labels only identify the pod
+	create, ok := tp.server.Pod("default-web")
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, create.Labels, map[string]string{
+		"podman.virtual-kubelet.io/namespace": "default",
+		"podman.virtual-kubelet.io/name":      "web",
+		"podman.virtual-kubelet.io/uid":       "uid-web",
+	})
+}
+
+func TestProviderNativeLiveness(t *testing.T) {
+	tp := newTestProvider(t, func(cfg *PodmanConfig) {
+		cfg.NativeHealthchecks = "true"
+	})
+	defer tp.Close()
+	pod := newTestPod("web", "nginx")
+	pod.Spec.Containers[0].LivenessProbe = &v1.Probe{
+		Handler: v1.Handler{
+			Exec: &v1.ExecAction{Command: []string{"true"}},
+		},
+	}
+
+	assert.NilError(t, tp.createPod(t, pod))
+	tp.waitNotified(t, pod, phase(v1.PodRunning))
+	create, ok := tp.server.Container("default-web-nginx")
+	assert.Assert(t, ok)
+	assert.Assert(t, create.HealthcheckCommand != nil)
+
+	// container failing podman healthcheck is restarted, the same as one
+	// failing liveness probe run by the provider
+	assert.NilError(t, tp.server.SetHealth("default-web-nginx", "unhealthy"))
+	notified := tp.waitNotified(t, pod, func(pod *v1.Pod) bool {
+		status := pod.Status.ContainerStatuses[0]
+		return status.RestartCount == 1 && status.State.Running != nil
+	})
+	terminated := notified.Status.ContainerStatuses[0].LastTerminationState.Terminated
+	assert.Assert(t, terminated != nil)
+	assert.Equal(t, terminated.ExitCode, int32(143))
+	assert.Equal(t, tp.server.Calls("StopContainer"), 1)
+
+	// the annotation telling reconcile about unhealthy containers is never
+	// published
+	tp.mu.Lock()
+	history := tp.history
+	tp.mu.Unlock()
+	for _, pod := range history {
+		_, unhealthy := pod.Annotations[converter.UnhealthyContainersAnnotation]
+		assert.Assert(t, !unhealthy)
+	}
+	pods, err := tp.GetPods(context.Background())
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(pods, 1))
+	_, unhealthy := pods[0].Annotations[converter.UnhealthyContainersAnnotation]
+	assert.Assert(t, !unhealthy)
+}
+
+func TestProviderNotifyHidesAnnotations(t *testing.T) {
+	var notified *v1.Pod
+	p := &PodmanV0Provider{notifier: func(pod *v1.Pod) { notified = pod }}
+	pod := newTestPod("web", "nginx")
+	pod.Annotations = map[string]string{
+		converter.UnhealthyContainersAnnotation: "nginx",
+		converter.PublishedPortsAnnotation:      "8080",
+	}
+	p.notify(pod)
+	assert.DeepEqual(t, notified.Annotations, map[string]string{converter.PublishedPortsAnnotation: "8080"})
+}
+
+func TestProviderRestartPolicyNever(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.Close()
+	pod := newTestPod("job", "main")
+	pod.Spec.RestartPolicy = v1.RestartPolicyNever
+
+	assert.NilError(t, tp.createPod(t, pod))
+	tp.waitNotified(t, pod, phase(v1.PodRunning))
+
+	assert.NilError(t, tp.server.Exit("default-job-main", 2))
+	notified := tp.waitNotified(t, pod, phase(v1.PodFailed))
+	terminated := notified.Status.ContainerStatuses[0].State.Terminated
+	assert.Assert(t, terminated != nil)
+	assert.Equal(t, terminated.ExitCode, int32(2))
+	assert.Equal(t, terminated.Reason, "Error")
+}
+
+func TestProviderAdmission(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.Close()
+	pod := newTestPod("big", "main")
+	pod.Spec.Containers[0].Resources.Requests = v1.ResourceList{
+		v1.ResourceCPU: resource.MustParse("4"),
+	}
+
+	// rejection is reported in the status only, so virtual-kubelet keeps it
+	assert.NilError(t, tp.createPod(t, pod))
+	notified := tp.waitNotified(t, pod, phase(v1.PodFailed))
+	assert.Equal(t, notified.Status.Reason, "OutOfcpu")
+	assert.Assert(t, is.Len(tp.server.Pods(), 0))
+	_, err := tp.GetPod(context.Background(), "default", "big")
+	assert.Assert(t, errdefs.IsNotFound(err), "expected not found, got %v", err)
+}
+
+func TestProviderAdmissionRemoteVolumes(t *testing.T) {
+	p := &PodmanV0Provider{
+		config:    PodmanConfig{Socket: "ssh://root@edge-1/run/podman/io.podman"},
+		admission: newAdmission(),
+	}
+	pod := newTestPod("web", "nginx")
+	pod.Spec.Volumes = []v1.Volume{{
+		Name: "token",
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{SecretName: "default-token"},
+		},
+	}}
+
+	reason, message := p.admit(context.Background(), pod)
+	assert.Equal(t, reason, "UnsupportedVolume")
+	assert.Assert(t, is.Contains(message, "volume token"))
+}
+
+func TestProviderPodmanUnavailable(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.Close()
+	pod := newTestPod("web", "nginx")
+
+	// pod stays pending while podman can't be reached and is created once
+	// it can
+	tp.server.InjectFault("CreatePod", podmantest.Fault{Drop: true, Times: 1})
+	assert.NilError(t, tp.createPod(t, pod))
+	tp.waitNotified(t, pod, phase(v1.PodRunning))
+	assert.Assert(t, tp.server.Calls("CreatePod") >= 2)
+}
+
+func TestProviderCreateRetried(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.Close()
+	pod := newTestPod("web", "nginx")
+
+	// podman errors are retried with back-off, the same as virtual-kubelet
+	// retries failed pods
+	tp.server.InjectFault("CreatePod", podmantest.Fault{
+		Error: &iopodman.ErrorOccurred{Reason: "cgroup busy"},
+		Times: 2,
+	})
+	assert.NilError(t, tp.createPod(t, pod))
+	tp.waitNotified(t, pod, phase(v1.PodRunning))
+	assert.Equal(t, tp.server.Calls("CreatePod"), 3)
+}
+
+func TestProviderCreateWaitsForStart(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.Close()
+	pod := newTestPod("web", "nginx")
+
+	// create worker doesn't run podman Create while reconcile starts the
+	// pod, it retries once start is done
+	key := converter.BuildKey(pod)
+	tp.starting.Store(key, struct{}{})
+	assert.NilError(t, tp.createPod(t, pod))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, tp.server.Calls("CreatePod"), 0)
+
+	tp.starting.Delete(key)
+	tp.waitNotified(t, pod, phase(v1.PodRunning))
+	assert.Equal(t, tp.server.Calls("CreatePod"), 1)
+}
+
+func TestProviderUpdatePod(t *testing.T) {
+	ctx := context.Background()
+	tp := newTestProvider(t)
+	defer tp.Close()
+	pod := newTestPod("web", "nginx")
+
+	assert.NilError(t, tp.createPod(t, pod))
+	tp.waitNotified(t, pod, phase(v1.PodRunning))
+	assert.NilError(t, tp.server.Exit("default-web-nginx", 1))
+	tp.waitNotified(t, pod, func(pod *v1.Pod) bool {
+		return pod.Status.ContainerStatuses[0].RestartCount == 1
+	})
+
+	// update not fitting the node leaves the pod as it is
+	tooBig := pod.DeepCopy()
+	tooBig.Spec.Containers[0].Resources.Requests = v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}
+	err := tp.UpdatePod(ctx, tooBig)
+	assert.Assert(t, errdefs.IsInvalidInput(err), "expected invalid input, got %v", err)
+	assert.Equal(t, tp.server.Calls("RemovePod"), 0)
+
+	// pod is recreated by create workers and keeps restart history
+	updated := pod.DeepCopy()
+	updated.Spec.Containers[0].Args = []string{"7200"}
+	assert.NilError(t, tp.indexer.Update(updated))
+	assert.NilError(t, tp.UpdatePod(ctx, updated))
+	tp.waitNotified(t, pod, func(pod *v1.Pod) bool {
+		status := pod.Status.ContainerStatuses[0]
+		return pod.Spec.Containers[0].Args[0] == "7200" && status.State.Running != nil && status.RestartCount == 1
+	})
+	assert.Equal(t, tp.server.Calls("RemovePod"), 1)
+	create, ok := tp.server.Container("default-web-nginx")
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, create.Args[len(create.Args)-1:], []string{"7200"})
+
+	tp.restart(t)
+	current, err := tp.GetPod(ctx, "default", "web")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, current.Spec.Containers[0].Args, []string{"7200"})
+	assert.Equal(t, current.Status.ContainerStatuses[0].RestartCount, int32(1))
+}
+
+func TestProviderRestartKeepsProbeWorker(t *testing.T) {
+	tp := newTestProvider(t)
+	defer tp.Close()
+	pod := newTestPod("web", "nginx")
+	pod.Spec.Containers[0].ReadinessProbe = &v1.Probe{
+		Handler: v1.Handler{
+			Exec: &v1.ExecAction{Command: []string{"true"}},
+		},
+		PeriodSeconds: 1,
+	}
+
+	assert.NilError(t, tp.createPod(t, pod))
+	tp.waitNotified(t, pod, phase(v1.PodRunning))
+	assert.NilError(t, tp.server.Exit("default-web-nginx", 1))
+	notified := tp.waitNotified(t, pod, func(pod *v1.Pod) bool {
+		status := pod.Status.ContainerStatuses[0]
+		return status.RestartCount == 1 && status.State.Running != nil
+	})
+
+	// restarted container is reported with its start time from podman,
+	// so probe worker is started once for it and kept by following syncs
+	startedAt := notified.Status.ContainerStatuses[0].State.Running.StartedAt
+	tp.mu.Lock()
+	for _, pod := range tp.history {
+		status := pod.Status.ContainerStatuses[0]
+		if status.RestartCount == 1 && status.State.Running != nil {
+			assert.Assert(t, status.State.Running.StartedAt.Equal(&startedAt), "notified start time %s, podman %s", status.State.Running.StartedAt, startedAt)
+		}
+	}
+	tp.mu.Unlock()
+	worker := func() *probeWorker {
+		tp.probes.mu.Lock()
+		defer tp.probes.mu.Unlock()
+		return tp.probes.workers[probeKey{container: "default-web/nginx", probeType: readiness}]
+	}
+	started := worker()
+	assert.Assert(t, started != nil)
+	assert.Assert(t, started.startedAt.Equal(startedAt.Time))
+	time.Sleep(500 * time.Millisecond)
+	assert.Assert(t, worker() == started, "probe worker must not be replaced")
+}
@@ -4,29 +4,198 @@ import (
 	"context"
 	"time"
 
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/podman"
 	"github.com/virtual-kubelet/virtual-kubelet/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/flowcontrol"
 )
 
+// reconcile syncs status of all pods every reconcile interval. Status changes
+// are normally picked up from podman events, polling is a safety net for
+// missed events
 func (p *PodmanV0Provider) reconcile() error {
+	interval, err := time.ParseDuration(p.config.ReconcileInterval)
+	if err != nil || interval <= 0 {
+		interval, _ = time.ParseDuration(defaultReconcileInterval)
+	}
 	for {
 		ctx := context.Background()
-		time.Sleep(10 * time.Second)
+		select {
+		case <-time.After(interval):
+		case <-p.stop:
+			return nil
+		}
 		log.G(ctx).Infof("reconcile all pods status")
 		pods := p.resourceManager.GetPods()
+		p.restarts.gc()
+
+		for _, pod := range pods {
+			p.syncQueue.Add(converter.BuildKey(pod))
+		}
+	}
+}
+
+// runSyncWorker syncs pods queued for sync until the queue is shut down
+func (p *PodmanV0Provider) runSyncWorker() {
+	for {
+		key, quit := p.syncQueue.Get()
+		if quit {
+			return
+		}
+		p.syncPodByKey(context.Background(), key.(string))
+		p.syncQueue.Done(key)
+	}
+}
+
+// syncPod reads current status of the pod from podman, enforces restart
+// policy, eviction and probes and notifies about the status. It is run by
+// sync workers only, which never sync the same pod concurrently
+func (p *PodmanV0Provider) syncPod(ctx context.Context, pod *v1.Pod) {
+	updatePod := pod.DeepCopy()
+	currentPod, err := p.c.Get(ctx, updatePod)
+	if err != nil {
+		log.G(ctx).Debugf("error while reconcile pod %s/%s", pod.Namespace, pod.Name)
+		return
+	}
+	if !p.applyEviction(currentPod) && !p.checkEviction(ctx, pod, currentPod) {
+		// pick up changes of secrets and configMaps
+		if err := p.volumes.Setup(pod); err != nil {
+			log.G(ctx).Errorf("error while refreshing volumes of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+		p.enforceRestartPolicy(ctx, currentPod)
+		if waitingForImage(currentPod) {
+			p.createAsync(pod)
+		}
+		p.probes.sync(currentPod)
+	}
+	p.probes.apply(currentPod)
+
+	if ports, ok := currentPod.Annotations[converter.PublishedPortsAnnotation]; ok {
+		if updatePod.Annotations == nil {
+			updatePod.Annotations = map[string]string{}
+		}
+		updatePod.Annotations[converter.PublishedPortsAnnotation] = ports
+	}
+	updatePod.Status = currentPod.Status
+	p.notify(updatePod)
+}
+
+// syncPodByKey syncs the pod with the key if it is assigned to the node
+func (p *PodmanV0Provider) syncPodByKey(ctx context.Context, key string) {
+	for _, pod := range p.resourceManager.GetPods() {
+		if converter.BuildKey(pod) == key {
+			p.syncPod(ctx, pod)
+			return
+		}
+	}
+}
+
+// syncPodAfter queues sync of the pod once the delay passes. It is used to
+// act on expired back-off without waiting for the next reconcile
+func (p *PodmanV0Provider) syncPodAfter(key string, delay time.Duration) {
+	p.syncQueue.AddAfter(key, delay)
+}
 
-		if pods != nil {
-			for _, pod := range pods {
-				updatePod := pod.DeepCopy()
-				currentPod, err := p.c.Get(ctx, updatePod)
-				if err != nil {
-					log.G(ctx).Debugf("error while reconcile pod %s/%s", pod.Namespace, pod.Name)
-					continue
-				}
-				if updatePod != nil {
-					updatePod.Status = currentPod.Status
-					p.notifier(updatePod)
-				}
+// watchEvents syncs pods affected by podman container and pod events as they
+// happen. The watch is restarted when the event stream breaks
+func (p *PodmanV0Provider) watchEvents() {
+	backOff := flowcontrol.NewBackOff(time.Second, maxWatchBackOff)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-p.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		start := time.Now()
+		err := p.c.WatchEvents(ctx, func(event podman.Event) {
+			p.handleEvent(ctx, event)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > maxWatchBackOff {
+			backOff.Reset("watch")
+		}
+		backOff.Next("watch", time.Now())
+		log.G(ctx).Errorf("podman event watch stopped, restarting in %s: %v", backOff.Get("watch"), err)
+		select {
+		case <-time.After(backOff.Get("watch")):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *PodmanV0Provider) handleEvent(ctx context.Context, event podman.Event) {
+	switch event.Status {
+	case podman.EventStart, podman.EventDied, podman.EventOOM, podman.EventHealthStatus, podman.EventRemove:
+	default:
+		return
+	}
+
+	for _, pod := range p.resourceManager.GetPods() {
+		key := converter.BuildKey(pod)
+		if event.Type == podman.EventTypePod && event.Name == key {
+			p.syncQueue.Add(key)
+			return
+		}
+		if event.Type != podman.EventTypeContainer {
+			continue
+		}
+		for _, c := range append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+			if converter.BuildContainerName(key, c.Name) == event.Name {
+				log.G(ctx).Debugf("podman event %s of container %s", event.Status, event.Name)
+				p.syncQueue.Add(key)
+				return
 			}
 		}
 	}
 }
+
+// createAsync runs podman Create for the pod in the background to create
+// containers which images were not available before
+func (p *PodmanV0Provider) createAsync(pod *v1.Pod) {
+	key := converter.BuildKey(pod)
+	if _, running := p.starting.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	go func() {
+		defer p.starting.Delete(key)
+		ctx := context.Background()
+		if err := p.c.Create(ctx, pod); err != nil && !podman.IsImagePullError(err) {
+			log.G(ctx).Errorf("error while creating pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+	}()
+}
+
+// waitingForImage returns true if any container of the pod waits for its
+// image to be pulled
+func waitingForImage(pod *v1.Pod) bool {
+	for _, status := range append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...) {
+		if status.State.Waiting != nil && podman.IsImagePullReason(status.State.Waiting.Reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// startAsync runs podman Start for the pod in the background. Only one start
+// per pod is in flight, as Start blocks until init containers complete
+func (p *PodmanV0Provider) startAsync(pod *v1.Pod) {
+	key := converter.BuildKey(pod)
+	if _, running := p.starting.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	go func() {
+		defer p.starting.Delete(key)
+		ctx := context.Background()
+		if err := p.c.Start(ctx, pod); err != nil {
+			log.G(ctx).Errorf("error while starting pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+	}()
+}
@@ -0,0 +1,251 @@
+package podman
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/flowcontrol"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/state"
+)
+
+const (
+	// Container restart back-off, same as kubelet uses
+	initialRestartBackOff = 10 * time.Second
+	maxRestartBackOff     = 5 * time.Minute
+
+	reasonCrashLoopBackOff = "CrashLoopBackOff"
+)
+
+// restartTracker keeps restart back-off and restart history of the containers
+// restarted by the provider. Entries are keyed by "<pod key>/<container>".
+// Restart history is written through to the state store, so restart counts
+// survive provider restarts
+type restartTracker struct {
+	mu         sync.Mutex
+	backOff    *flowcontrol.Backoff
+	containers map[string]*containerRestarts
+	store      *state.Store
+}
+
+type containerRestarts struct {
+	count           int32
+	lastTermination *v1.ContainerStateTerminated
+}
+
+// newRestartTracker returns tracker with restart history loaded from the
+// state store
+func newRestartTracker(store *state.Store) (*restartTracker, error) {
+	r := &restartTracker{
+		backOff:    flowcontrol.NewBackOff(initialRestartBackOff, maxRestartBackOff),
+		containers: make(map[string]*containerRestarts),
+		store:      store,
+	}
+	pods, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		for name, restarts := range pod.Restarts {
+			r.containers[converter.BuildKey(pod.Pod)+"/"+name] = &containerRestarts{
+				count:           restarts.Count,
+				lastTermination: restarts.LastTermination,
+			}
+		}
+	}
+	return r, nil
+}
+
+// inBackOff returns true and current back-off duration when the container
+// which finished at finishedAt must not be restarted yet
+func (r *restartTracker) inBackOff(key string, finishedAt time.Time) (bool, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.backOff.IsInBackOffSince(key, finishedAt), r.backOff.Get(key)
+}
+
+// restarted records restart of the pod container and moves its back-off
+// forward
+func (r *restartTracker) restarted(ctx context.Context, pod *v1.Pod, container string, terminated *v1.ContainerStateTerminated) {
+	key := converter.BuildKey(pod) + "/" + container
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backOff.Next(key, terminated.FinishedAt.Time)
+	c, ok := r.containers[key]
+	if !ok {
+		c = &containerRestarts{}
+		r.containers[key] = c
+	}
+	c.count++
+	c.lastTermination = terminated.DeepCopy()
+
+	err := r.store.Update(pod.UID, func(s *state.Pod) {
+		if s.Restarts == nil {
+			s.Restarts = map[string]state.Restarts{}
+		}
+		s.Restarts[container] = state.Restarts{
+			Count:           c.count,
+			LastTermination: c.lastTermination.DeepCopy(),
+		}
+	})
+	if err != nil {
+		log.G(ctx).Errorf("error while storing restart history of container %s of pod %s/%s: %v", container, pod.Namespace, pod.Name, err)
+	}
+}
+
+// apply adds restart history to the container status
+func (r *restartTracker) apply(key string, status *v1.ContainerStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.containers[key]
+	if !ok {
+		return
+	}
+	status.RestartCount += c.count
+	if status.LastTerminationState.Terminated == nil && c.lastTermination != nil {
+		status.LastTerminationState.Terminated = c.lastTermination.DeepCopy()
+	}
+}
+
+// applyPod adds restart history to statuses of all containers of the pod
+func (r *restartTracker) applyPod(pod *v1.Pod) {
+	podKey := converter.BuildKey(pod)
+	for i := range pod.Status.InitContainerStatuses {
+		r.apply(podKey+"/"+pod.Status.InitContainerStatuses[i].Name, &pod.Status.InitContainerStatuses[i])
+	}
+	for i := range pod.Status.ContainerStatuses {
+		r.apply(podKey+"/"+pod.Status.ContainerStatuses[i].Name, &pod.Status.ContainerStatuses[i])
+	}
+}
+
+// forget drops restart history of all containers of the pod
+func (r *restartTracker) forget(podKey string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key := range r.containers {
+		if strings.HasPrefix(key, podKey+"/") {
+			delete(r.containers, key)
+			r.backOff.DeleteEntry(key)
+		}
+	}
+}
+
+func (r *restartTracker) gc() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backOff.GC()
+}
+
+// shouldRestart returns true if container which exited with exitCode must be
+// restarted under the pod restart policy
+func shouldRestart(policy v1.RestartPolicy, exitCode int32) bool {
+	switch policy {
+	case v1.RestartPolicyNever:
+		return false
+	case v1.RestartPolicyOnFailure:
+		return exitCode != 0
+	default:
+		return true
+	}
+}
+
+// enforceRestartPolicy restarts exited containers of the pod according to its
+// restartPolicy and updates pod status with restart counts and back-off state
+func (p *PodmanV0Provider) enforceRestartPolicy(ctx context.Context, pod *v1.Pod) {
+	podKey := converter.BuildKey(pod)
+	p.probes.stopUnhealthy(ctx, pod)
+
+	for i := range pod.Status.InitContainerStatuses {
+		status := &pod.Status.InitContainerStatuses[i]
+		key := podKey + "/" + status.Name
+		terminated := status.LastTerminationState.Terminated
+		if status.State.Waiting == nil || status.State.Waiting.Reason != reasonCrashLoopBackOff || terminated == nil {
+			p.restarts.apply(key, status)
+			continue
+		}
+
+		if backingOff, backOff := p.restarts.inBackOff(key, terminated.FinishedAt.Time); backingOff {
+			p.syncPodAfter(podKey, time.Until(terminated.FinishedAt.Add(backOff)))
+			status.State.Waiting.Message = backOffMessage(backOff, status.Name, pod)
+			p.restarts.apply(key, status)
+			continue
+		}
+		log.G(ctx).Infof("restarting init container %s of pod %s/%s", status.Name, pod.Namespace, pod.Name)
+		p.restarts.restarted(ctx, pod, status.Name, terminated)
+		p.restarts.apply(key, status)
+		p.startAsync(pod)
+	}
+
+	for i := range pod.Status.ContainerStatuses {
+		status := &pod.Status.ContainerStatuses[i]
+		key := podKey + "/" + status.Name
+		terminated := status.State.Terminated
+		if terminated == nil || !shouldRestart(pod.Spec.RestartPolicy, terminated.ExitCode) {
+			p.restarts.apply(key, status)
+			continue
+		}
+
+		// restarting container keeps pod running
+		if pod.Status.Phase == v1.PodFailed || pod.Status.Phase == v1.PodSucceeded {
+			pod.Status.Phase = v1.PodRunning
+		}
+
+		if backingOff, backOff := p.restarts.inBackOff(key, terminated.FinishedAt.Time); backingOff {
+			p.syncPodAfter(podKey, time.Until(terminated.FinishedAt.Add(backOff)))
+			status.LastTerminationState = status.State
+			status.State = v1.ContainerState{
+				Waiting: &v1.ContainerStateWaiting{
+					Reason:  reasonCrashLoopBackOff,
+					Message: backOffMessage(backOff, status.Name, pod),
+				},
+			}
+			status.Ready = false
+			p.restarts.apply(key, status)
+			continue
+		}
+
+		log.G(ctx).Infof("restarting container %s of pod %s/%s", status.Name, pod.Namespace, pod.Name)
+		if err := p.c.StartContainer(ctx, pod, status.Name); err != nil {
+			log.G(ctx).Errorf("error while restarting container %s of pod %s/%s: %v", status.Name, pod.Namespace, pod.Name, err)
+			p.restarts.apply(key, status)
+			continue
+		}
+		p.restarts.restarted(ctx, pod, status.Name, terminated)
+		status.State = p.restartedState(ctx, pod, status.Name)
+		status.LastTerminationState = v1.ContainerState{}
+		p.restarts.apply(key, status)
+	}
+}
+
+// restartedState returns state of the restarted container as podman reports
+// it. Probe workers are keyed on start time of the container, so it is never
+// made up: if podman can't be asked, the container is reported as being
+// created until the next sync
+func (p *PodmanV0Provider) restartedState(ctx context.Context, pod *v1.Pod, container string) v1.ContainerState {
+	current, err := p.c.Get(ctx, pod)
+	if err != nil {
+		log.G(ctx).Errorf("error while getting restarted container %s of pod %s/%s: %v", container, pod.Namespace, pod.Name, err)
+	} else {
+		for _, status := range current.Status.ContainerStatuses {
+			if status.Name == container && status.State.Running != nil {
+				return status.State
+			}
+		}
+	}
+	p.syncQueue.Add(converter.BuildKey(pod))
+	return v1.ContainerState{
+		Waiting: &v1.ContainerStateWaiting{
+			Reason: "ContainerCreating",
+		},
+	}
+}
+
+func backOffMessage(backOff time.Duration, container string, pod *v1.Pod) string {
+	return fmt.Sprintf("back-off %s restarting failed container=%s pod=%s_%s(%s)", backOff, container, pod.Name, pod.Namespace, pod.UID)
+}
@@ -0,0 +1,212 @@
+// Package state keeps provider state of pods on disk, so it survives provider
+// restarts without being stored in podman. Pods are keyed by their UID
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	bolt "go.etcd.io/bbolt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// openTimeout limits waiting for the lock of the state file held by another
+// provider
+const openTimeout = 10 * time.Second
+
+var podsBucket = []byte("pods")
+
+// Pod is the provider state of a pod
+type Pod struct {
+	// Pod is the kubernetes pod as it was created in podman. Pods are
+	// recreated on update, so its spec holds resources assigned to the
+	// containers. Container env is not stored, see encode
+	Pod *v1.Pod `json:"pod"`
+	// Restarts is restart history of containers restarted by the
+	// provider, keyed by container name
+	Restarts map[string]Restarts `json:"restarts,omitempty"`
+}
+
+// Restarts is restart history of a container
+type Restarts struct {
+	Count           int32                        `json:"count"`
+	LastTermination *v1.ContainerStateTerminated `json:"lastTermination,omitempty"`
+}
+
+// NewPod returns state of the pod without restart history
+func NewPod(pod *v1.Pod) *Pod {
+	return &Pod{Pod: pod.DeepCopy()}
+}
+
+// Store is pod state store backed by bolt database file
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the state file, creating it if it doesn't exist. The file is
+// locked until the store is closed
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(podsBucket)
+		if err != nil {
+			return err
+		}
+		return scrub(bucket)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the state file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Get returns state of the pod. Missing state is reported as NotFound error
+func (s *Store) Get(uid types.UID) (*Pod, error) {
+	var pod *Pod
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		pod, err = get(tx, uid)
+		return err
+	})
+	return pod, err
+}
+
+// Put stores state of the pod, replacing state stored before
+func (s *Store) Put(pod *Pod) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, pod)
+	})
+}
+
+// Update changes stored state of the pod with fn. Missing state is reported
+// as NotFound error
+func (s *Store) Update(uid types.UID, fn func(*Pod)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		pod, err := get(tx, uid)
+		if err != nil {
+			return err
+		}
+		fn(pod)
+		return put(tx, pod)
+	})
+}
+
+// Delete removes state of the pod. Deleting missing state is not an error
+func (s *Store) Delete(uid types.UID) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(podsBucket).Delete([]byte(uid))
+	})
+}
+
+// List returns state of all pods
+func (s *Store) List() ([]*Pod, error) {
+	var pods []*Pod
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(podsBucket).ForEach(func(k, data []byte) error {
+			pod := &Pod{}
+			if err := json.Unmarshal(data, pod); err != nil {
+				return err
+			}
+			pods = append(pods, pod)
+			return nil
+		})
+	})
+	return pods, err
+}
+
+func get(tx *bolt.Tx, uid types.UID) (*Pod, error) {
+	data := tx.Bucket(podsBucket).Get([]byte(uid))
+	if data == nil {
+		return nil, errdefs.NotFoundf("no state of pod %s", uid)
+	}
+	pod := &Pod{}
+	if err := json.Unmarshal(data, pod); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+func put(tx *bolt.Tx, pod *Pod) error {
+	data, err := encode(pod)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(podsBucket).Put([]byte(pod.Pod.UID), data)
+}
+
+// encode returns stored form of the pod, without env of its containers.
+// Virtual-kubelet passes pods with env resolved to literal values, secrets
+// included, and containers are created with env resolved from the pod in the
+// API server, so the state file doesn't need to hold it
+func encode(pod *Pod) ([]byte, error) {
+	stored := *pod
+	stored.Pod = withoutEnv(pod.Pod)
+	return json.Marshal(&stored)
+}
+
+// scrub removes container env from state written by older versions
+func scrub(bucket *bolt.Bucket) error {
+	var pods []*Pod
+	err := bucket.ForEach(func(k, data []byte) error {
+		pod := &Pod{}
+		if err := json.Unmarshal(data, pod); err != nil {
+			return err
+		}
+		if hasEnv(pod.Pod) {
+			pods = append(pods, pod)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		data, err := encode(pod)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte(pod.Pod.UID), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func withoutEnv(pod *v1.Pod) *v1.Pod {
+	if !hasEnv(pod) {
+		return pod
+	}
+	pod = pod.DeepCopy()
+	for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range containers {
+			containers[i].Env = nil
+			containers[i].EnvFrom = nil
+		}
+	}
+	return pod
+}
+
+func hasEnv(pod *v1.Pod) bool {
+	for _, c := range append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		if len(c.Env) > 0 || len(c.EnvFrom) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	bolt "go.etcd.io/bbolt"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vkubelet", "node.db")
+
+	s, err := Open(path)
+	assert.NilError(t, err)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-web"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Name: "nginx",
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{v1.ResourceMemory: resource.MustParse("128Mi")},
+				},
+			}},
+		},
+	}
+	assert.NilError(t, s.Put(NewPod(pod)))
+
+	_, err = s.Get("missing")
+	assert.Assert(t, errdefs.IsNotFound(err), "expected not found, got %v", err)
+	err = s.Update("missing", func(*Pod) {})
+	assert.Assert(t, errdefs.IsNotFound(err), "expected not found, got %v", err)
+
+	assert.NilError(t, s.Update("uid-web", func(p *Pod) {
+		p.Restarts = map[string]Restarts{"nginx": {Count: 2}}
+	}))
+	assert.NilError(t, s.Close())
+
+	// state survives reopening
+	s, err = Open(path)
+	assert.NilError(t, err)
+	defer s.Close()
+	stored, err := s.Get("uid-web")
+	assert.NilError(t, err)
+	assert.Equal(t, stored.Pod.Name, "web")
+	assert.Equal(t, stored.Restarts["nginx"].Count, int32(2))
+	memory := stored.Pod.Spec.Containers[0].Resources.Limits[v1.ResourceMemory]
+	assert.Equal(t, memory.Value(), int64(128<<20))
+
+	pods, err := s.List()
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(pods, 1))
+
+	assert.NilError(t, s.Delete("uid-web"))
+	assert.NilError(t, s.Delete("uid-web"))
+	pods, err = s.List()
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(pods, 0))
+}
+
+func TestStoreWithoutEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "node.db")
+
+	// env as virtual-kubelet passes it, resolved from the secret
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-web"},
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{
+				Name: "init",
+				Env:  []v1.EnvVar{{Name: "TOKEN", Value: "init-s3cret"}},
+			}},
+			Containers: []v1.Container{{
+				Name:    "nginx",
+				Image:   "nginx",
+				Env:     []v1.EnvVar{{Name: "PASSWORD", Value: "s3cret"}},
+				EnvFrom: []v1.EnvFromSource{},
+			}},
+		},
+	}
+	s, err := Open(path)
+	assert.NilError(t, err)
+	assert.NilError(t, s.Put(NewPod(pod)))
+	assert.Equal(t, pod.Spec.Containers[0].Env[0].Value, "s3cret")
+	stored, err := s.Get("uid-web")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(stored.Pod.Spec.InitContainers[0].Env, 0))
+	assert.Assert(t, is.Len(stored.Pod.Spec.Containers[0].Env, 0))
+	assert.Equal(t, stored.Pod.Spec.Containers[0].Image, "nginx")
+
+	assert.NilError(t, s.Update("uid-web", func(p *Pod) {
+		p.Pod = pod.DeepCopy()
+		p.Restarts = map[string]Restarts{"nginx": {Count: 1}}
+	}))
+	assert.NilError(t, s.Close())
+	data, err := ioutil.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(string(data), "s3cret"))
+
+	// state written with env by older versions is cleaned when opened
+	db, err := bolt.Open(path, 0600, nil)
+	assert.NilError(t, err)
+	raw, err := json.Marshal(&Pod{Pod: pod, Restarts: map[string]Restarts{"nginx": {Count: 1}}})
+	assert.NilError(t, err)
+	assert.NilError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(podsBucket).Put([]byte(pod.UID), raw)
+	}))
+	assert.NilError(t, db.Close())
+
+	s, err = Open(path)
+	assert.NilError(t, err)
+	defer s.Close()
+	stored, err = s.Get("uid-web")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(stored.Pod.Spec.InitContainers[0].Env, 0))
+	assert.Assert(t, is.Len(stored.Pod.Spec.Containers[0].Env, 0))
+	assert.Equal(t, stored.Restarts["nginx"].Count, int32(1))
+}
@@ -1,16 +1,69 @@
 package errors
 
 import (
+	"github.com/varlink/go/varlink"
 	"github.com/virtual-kubelet/podman/pkg/iopodman"
 	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
 )
 
 // VKError takes in varlink error and returns Virtual kubelet error
 func VKError(err error) error {
-	switch err {
-	case err.(*iopodman.PodNotFound):
-		return errdefs.NotFound("ImageNotFound")
+	switch e := err.(type) {
+	case *iopodman.PodNotFound:
+		return errdefs.NotFound("PodNotFound")
+	case *iopodman.ContainerNotFound:
+		return errdefs.NotFoundf("ContainerNotFound: %s", e.Id)
+	case *transientError:
+		// podman is unreachable, it doesn't mean the object is gone
+		return err
 	default:
 		return errdefs.AsNotFound(err)
 	}
 }
+
+// transientError is error of podman connection, which is likely to go away
+// when the call is retried
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+// Transient marks err as transient
+func Transient(err error) error {
+	if err == nil || IsTransient(err) {
+		return err
+	}
+	return &transientError{err: err}
+}
+
+// IsTransient returns true if err is transient error of podman connection,
+// such as broken connection or podman service being restarted
+func IsTransient(err error) bool {
+	_, ok := err.(*transientError)
+	return ok
+}
+
+// IsReply returns true if err was replied by podman. Connection is still
+// usable after such errors, unlike after errors of the connection itself
+func IsReply(err error) bool {
+	switch err.(type) {
+	case *varlink.Error,
+		*iopodman.ImageNotFound,
+		*iopodman.ContainerNotFound,
+		*iopodman.NoContainerRunning,
+		*iopodman.PodNotFound,
+		*iopodman.VolumeNotFound,
+		*iopodman.PodContainerError,
+		*iopodman.NoContainersInPod,
+		*iopodman.InvalidState,
+		*iopodman.ErrorOccurred,
+		*iopodman.RuntimeError,
+		*iopodman.WantsMoreRequired,
+		*iopodman.ErrCtrStopped:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package volume
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	dataDirName    = "..data"
+	newDataDirName = "..data_tmp"
+)
+
+// writeAtomic writes payload into dir using the same layout as kubelet
+// AtomicWriter. Files are written into a timestamped directory, "..data"
+// symlink points to it and visible paths are symlinks through "..data".
+// Replacing "..data" is a single rename, so containers never see partially
+// updated volume
+func writeAtomic(dir string, payload map[string]fileProjection) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	current, err := os.Readlink(filepath.Join(dir, dataDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if current != "" && !changed(filepath.Join(dir, current), payload) {
+		return nil
+	}
+
+	tsDir, err := ioutil.TempDir(dir, time.Now().UTC().Format("..2006_01_02_15_04_05."))
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tsDir, 0755); err != nil {
+		return err
+	}
+	for path, file := range payload {
+		fullPath := filepath.Join(tsDir, path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(fullPath, file.data, os.FileMode(file.mode)); err != nil {
+			return err
+		}
+		// mode passed to WriteFile is subject to umask
+		if err := os.Chmod(fullPath, os.FileMode(file.mode)); err != nil {
+			return err
+		}
+	}
+
+	newLink := filepath.Join(dir, newDataDirName)
+	if err := os.Remove(newLink); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(filepath.Base(tsDir), newLink); err != nil {
+		return err
+	}
+	if err := os.Rename(newLink, filepath.Join(dir, dataDirName)); err != nil {
+		return err
+	}
+
+	visible := map[string]bool{}
+	for path := range payload {
+		visible[strings.SplitN(path, string(filepath.Separator), 2)[0]] = true
+	}
+	for name := range visible {
+		link := filepath.Join(dir, name)
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+		if err := os.Symlink(filepath.Join(dataDirName, name), link); err != nil {
+			return err
+		}
+	}
+
+	// remove previous data and paths which are no longer projected
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case name == dataDirName || name == filepath.Base(tsDir):
+		case strings.HasPrefix(name, ".."):
+			if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		case !visible[name]:
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// changed returns true if the content of dataDir differs from payload
+func changed(dataDir string, payload map[string]fileProjection) bool {
+	count := 0
+	err := filepath.Walk(dataDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil || count != len(payload) {
+		return true
+	}
+
+	for path, file := range payload {
+		fullPath := filepath.Join(dataDir, path)
+		info, err := os.Stat(fullPath)
+		if err != nil || info.Mode().Perm() != os.FileMode(file.mode).Perm() {
+			return true
+		}
+		data, err := ioutil.ReadFile(fullPath)
+		if err != nil || !bytes.Equal(data, file.data) {
+			return true
+		}
+	}
+	return false
+}
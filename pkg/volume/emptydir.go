@@ -0,0 +1,48 @@
+package volume
+
+import (
+	"os"
+	"path/filepath"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// setupEmptyDir creates emptyDir volume directory. Memory backed volumes are
+// tmpfs mounted on the host, so they are shared by all containers of the pod
+// the same way as disk backed ones
+func setupEmptyDir(dir string, source *v1.EmptyDirVolumeSource) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	// world writable as containers may run as any user, same as in kubelet
+	if err := os.Chmod(dir, 0777); err != nil {
+		return err
+	}
+	if source.Medium != v1.StorageMediumMemory {
+		return nil
+	}
+
+	var size int64
+	if source.SizeLimit != nil {
+		size = source.SizeLimit.Value()
+	}
+	return mountTmpfs(dir, size)
+}
+
+// diskUsage returns size of all files under dir
+func diskUsage(dir string) (int64, error) {
+	var usage int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			usage += info.Size()
+		}
+		return nil
+	})
+	return usage, err
+}
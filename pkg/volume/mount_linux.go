@@ -0,0 +1,45 @@
+package volume
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+)
+
+// mountTmpfs mounts tmpfs of given size in bytes on dir unless it is already
+// mounted. Zero size uses the kernel default
+func mountTmpfs(dir string, size int64) error {
+	mounted, err := isMountPoint(dir)
+	if err != nil || mounted {
+		return err
+	}
+	options := "mode=0777"
+	if size > 0 {
+		options = fmt.Sprintf("%s,size=%d", options, size)
+	}
+	return syscall.Mount("tmpfs", dir, "tmpfs", 0, options)
+}
+
+// unmountTmpfs unmounts dir if it is a mount point
+func unmountTmpfs(dir string) error {
+	mounted, err := isMountPoint(dir)
+	if err != nil || !mounted {
+		return err
+	}
+	return syscall.Unmount(dir, 0)
+}
+
+// isMountPoint returns true if dir is on a different device than its parent
+func isMountPoint(dir string) (bool, error) {
+	var st, parent syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		if err == syscall.ENOENT {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := syscall.Stat(filepath.Dir(dir), &parent); err != nil {
+		return false, err
+	}
+	return st.Dev != parent.Dev, nil
+}
@@ -0,0 +1,54 @@
+package volume
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+)
+
+func TestSetupEmptyDirMemory(t *testing.T) {
+	root, err := ioutil.TempDir("", "volume")
+	assert.NilError(t, err)
+	defer os.RemoveAll(root)
+	m := newTestManager(t, root)
+	pod := newTestPod()
+	limit := resource.MustParse("1Mi")
+	pod.Spec.Volumes = []v1.Volume{{
+		Name:         "shm",
+		VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{Medium: v1.StorageMediumMemory, SizeLimit: &limit}},
+	}}
+	dir := converter.PodVolumePath(root, pod, "shm")
+
+	if err := m.Setup(pod); err != nil {
+		// mounting tmpfs needs CAP_SYS_ADMIN
+		if os.IsPermission(err) || strings.Contains(err.Error(), "operation not permitted") {
+			t.Skipf("can't mount tmpfs: %v", err)
+		}
+		assert.NilError(t, err)
+	}
+	mounted, err := isMountPoint(dir)
+	assert.NilError(t, err)
+	assert.Assert(t, mounted)
+	// set up again doesn't mount it twice
+	assert.NilError(t, m.Setup(pod))
+
+	// tmpfs is limited to sizeLimit, memory backed volumes are not evicted
+	err = ioutil.WriteFile(filepath.Join(dir, "big"), make([]byte, 2<<20), 0644)
+	assert.Assert(t, err != nil, "write over sizeLimit must fail")
+	message, err := m.ExceededLimit(pod)
+	assert.NilError(t, err)
+	assert.Equal(t, message, "")
+
+	assert.NilError(t, m.Cleanup(pod))
+	mounted, err = isMountPoint(dir)
+	assert.NilError(t, err)
+	assert.Assert(t, !mounted)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package volume
+
+import "fmt"
+
+func mountTmpfs(dir string, size int64) error {
+	return fmt.Errorf("memory backed emptyDir volumes are only supported on linux")
+}
+
+func unmountTmpfs(dir string) error {
+	return nil
+}
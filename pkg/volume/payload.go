@@ -0,0 +1,204 @@
+package volume
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/v1/resource"
+	podshelper "k8s.io/kubernetes/pkg/apis/core/pods"
+	"k8s.io/kubernetes/pkg/fieldpath"
+)
+
+// fileProjection is a single file of the volume
+type fileProjection struct {
+	data []byte
+	mode int32
+}
+
+func (m *Manager) secretPayload(pod *v1.Pod, source *v1.SecretVolumeSource) (map[string]fileProjection, error) {
+	optional := source.Optional != nil && *source.Optional
+	secret, err := m.rm.GetSecret(source.SecretName, pod.Namespace)
+	if err != nil {
+		if apierrors.IsNotFound(err) && optional {
+			return map[string]fileProjection{}, nil
+		}
+		return nil, fmt.Errorf("couldn't get secret %s/%s: %v", pod.Namespace, source.SecretName, err)
+	}
+	return makePayload(source.Items, secret.Data, modeOrDefault(source.DefaultMode, v1.SecretVolumeSourceDefaultMode), optional)
+}
+
+func (m *Manager) configMapPayload(pod *v1.Pod, source *v1.ConfigMapVolumeSource) (map[string]fileProjection, error) {
+	optional := source.Optional != nil && *source.Optional
+	configMap, err := m.rm.GetConfigMap(source.Name, pod.Namespace)
+	if err != nil {
+		if apierrors.IsNotFound(err) && optional {
+			return map[string]fileProjection{}, nil
+		}
+		return nil, fmt.Errorf("couldn't get configMap %s/%s: %v", pod.Namespace, source.Name, err)
+	}
+	return makePayload(source.Items, configMapData(configMap), modeOrDefault(source.DefaultMode, v1.ConfigMapVolumeSourceDefaultMode), optional)
+}
+
+func (m *Manager) projectedPayload(pod *v1.Pod, source *v1.ProjectedVolumeSource) (map[string]fileProjection, error) {
+	defaultMode := modeOrDefault(source.DefaultMode, v1.ProjectedVolumeSourceDefaultMode)
+	payload := map[string]fileProjection{}
+
+	for _, projection := range source.Sources {
+		var (
+			files map[string]fileProjection
+			err   error
+		)
+		switch {
+		case projection.Secret != nil:
+			optional := projection.Secret.Optional != nil && *projection.Secret.Optional
+			secret, getErr := m.rm.GetSecret(projection.Secret.Name, pod.Namespace)
+			if getErr != nil {
+				if apierrors.IsNotFound(getErr) && optional {
+					continue
+				}
+				return nil, fmt.Errorf("couldn't get secret %s/%s: %v", pod.Namespace, projection.Secret.Name, getErr)
+			}
+			files, err = makePayload(projection.Secret.Items, secret.Data, defaultMode, optional)
+		case projection.ConfigMap != nil:
+			optional := projection.ConfigMap.Optional != nil && *projection.ConfigMap.Optional
+			configMap, getErr := m.rm.GetConfigMap(projection.ConfigMap.Name, pod.Namespace)
+			if getErr != nil {
+				if apierrors.IsNotFound(getErr) && optional {
+					continue
+				}
+				return nil, fmt.Errorf("couldn't get configMap %s/%s: %v", pod.Namespace, projection.ConfigMap.Name, getErr)
+			}
+			files, err = makePayload(projection.ConfigMap.Items, configMapData(configMap), defaultMode, optional)
+		case projection.DownwardAPI != nil:
+			files, err = m.downwardAPIPayload(pod, projection.DownwardAPI.Items, defaultMode)
+		default:
+			// service account tokens require TokenRequest API which is
+			// not available to the provider
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for path, file := range files {
+			payload[path] = file
+		}
+	}
+	return payload, nil
+}
+
+// downwardAPIPayload renders pod fields and container resources selected by
+// items the same way kubelet does
+func (m *Manager) downwardAPIPayload(pod *v1.Pod, items []v1.DownwardAPIVolumeFile, defaultMode int32) (map[string]fileProjection, error) {
+	payload := make(map[string]fileProjection, len(items))
+	for _, item := range items {
+		if err := validatePath(item.Path); err != nil {
+			return nil, err
+		}
+
+		var value string
+		switch {
+		case item.FieldRef != nil:
+			apiVersion := item.FieldRef.APIVersion
+			if apiVersion == "" {
+				apiVersion = "v1"
+			}
+			path, _, err := podshelper.ConvertDownwardAPIFieldLabel(apiVersion, item.FieldRef.FieldPath, "")
+			if err != nil {
+				return nil, err
+			}
+			value, err = fieldpath.ExtractFieldPathAsString(pod, path)
+			if err != nil {
+				return nil, err
+			}
+		case item.ResourceFieldRef != nil:
+			var err error
+			value, err = resource.ExtractResourceValueByContainerNameAndNodeAllocatable(item.ResourceFieldRef, pod, item.ResourceFieldRef.ContainerName, m.allocatable)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			continue
+		}
+
+		payload[item.Path] = fileProjection{
+			data: []byte(value),
+			mode: modeOrDefault(item.Mode, defaultMode),
+		}
+	}
+	return payload, nil
+}
+
+// makePayload selects keys of the object data. Without items every key is
+// written with the default mode, otherwise only listed keys are written and
+// missing ones are an error unless the source is optional
+func makePayload(items []v1.KeyToPath, data map[string][]byte, defaultMode int32, optional bool) (map[string]fileProjection, error) {
+	payload := make(map[string]fileProjection, len(data))
+	if len(items) == 0 {
+		for key, value := range data {
+			if err := validatePath(key); err != nil {
+				return nil, err
+			}
+			payload[key] = fileProjection{data: value, mode: defaultMode}
+		}
+		return payload, nil
+	}
+
+	for _, item := range items {
+		value, ok := data[item.Key]
+		if !ok {
+			if optional {
+				continue
+			}
+			return nil, fmt.Errorf("references non-existent key %s", item.Key)
+		}
+		if err := validatePath(item.Path); err != nil {
+			return nil, err
+		}
+		payload[item.Path] = fileProjection{
+			data: value,
+			mode: modeOrDefault(item.Mode, defaultMode),
+		}
+	}
+	return payload, nil
+}
+
+func configMapData(configMap *v1.ConfigMap) map[string][]byte {
+	data := make(map[string][]byte, len(configMap.Data)+len(configMap.BinaryData))
+	for key, value := range configMap.Data {
+		data[key] = []byte(value)
+	}
+	for key, value := range configMap.BinaryData {
+		data[key] = value
+	}
+	return data
+}
+
+func modeOrDefault(mode *int32, defaultMode int32) int32 {
+	if mode != nil {
+		return *mode
+	}
+	return defaultMode
+}
+
+// validatePath rejects paths which would escape the volume directory or
+// collide with the atomic writer internals
+func validatePath(path string) error {
+	if path == "" {
+		return fmt.Errorf("empty path")
+	}
+	if filepath.IsAbs(path) {
+		return fmt.Errorf("path %s must be relative", path)
+	}
+	for _, part := range strings.Split(path, string(filepath.Separator)) {
+		if part == ".." {
+			return fmt.Errorf("path %s must not contain '..'", path)
+		}
+	}
+	if strings.HasPrefix(path, "..") {
+		return fmt.Errorf("path %s must not start with '..'", path)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package volume materializes emptyDir volumes and kubernetes volumes backed
+// by API objects (secret, configMap, projected and downwardAPI) in per-pod
+// directories on the podman host, from where they are bind-mounted into
+// containers
+package volume
+
+import (
+	"fmt"
+	"os"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/manager"
+)
+
+// Manager writes and removes pod volumes under root directory
+type Manager struct {
+	root string
+	rm   *manager.ResourceManager
+	// allocatable is used for resourceFieldRef of containers without
+	// limits, the same as kubelet uses node allocatable
+	allocatable v1.ResourceList
+}
+
+// New returns volume manager storing volumes under root. Resources of
+// containers without limits are reported as node allocatable
+func New(root string, rm *manager.ResourceManager, allocatable v1.ResourceList) *Manager {
+	return &Manager{
+		root:        root,
+		rm:          rm,
+		allocatable: allocatable,
+	}
+}
+
+// Setup creates emptyDir volumes and writes content of all API backed volumes
+// of the pod. It is safe to call repeatedly: emptyDir content is preserved,
+// API backed volumes are only rewritten when their content changed and the
+// switch to new content is atomic for running containers
+func (m *Manager) Setup(pod *v1.Pod) error {
+	for _, volume := range pod.Spec.Volumes {
+		if !converter.IsManagedVolume(volume) {
+			continue
+		}
+		dir := converter.PodVolumePath(m.root, pod, volume.Name)
+		if volume.EmptyDir != nil {
+			if err := setupEmptyDir(dir, volume.EmptyDir); err != nil {
+				return fmt.Errorf("volume %s: %v", volume.Name, err)
+			}
+			continue
+		}
+
+		payload, err := m.payload(pod, volume)
+		if err != nil {
+			return fmt.Errorf("volume %s: %v", volume.Name, err)
+		}
+		if err := writeAtomic(dir, payload); err != nil {
+			return fmt.Errorf("volume %s: %v", volume.Name, err)
+		}
+	}
+	return nil
+}
+
+// Cleanup removes all volumes of the pod from the host
+func (m *Manager) Cleanup(pod *v1.Pod) error {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir == nil || volume.EmptyDir.Medium != v1.StorageMediumMemory {
+			continue
+		}
+		if err := unmountTmpfs(converter.PodVolumePath(m.root, pod, volume.Name)); err != nil {
+			return fmt.Errorf("volume %s: %v", volume.Name, err)
+		}
+	}
+	return os.RemoveAll(converter.PodDir(m.root, pod))
+}
+
+// ExceededLimit returns eviction message if usage of any disk backed emptyDir
+// volume of the pod exceeds its sizeLimit. Memory backed volumes are limited
+// by tmpfs size
+func (m *Manager) ExceededLimit(pod *v1.Pod) (string, error) {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir == nil || volume.EmptyDir.SizeLimit == nil || volume.EmptyDir.Medium == v1.StorageMediumMemory {
+			continue
+		}
+		usage, err := diskUsage(converter.PodVolumePath(m.root, pod, volume.Name))
+		if err != nil {
+			return "", fmt.Errorf("volume %s: %v", volume.Name, err)
+		}
+		if usage > volume.EmptyDir.SizeLimit.Value() {
+			return fmt.Sprintf("Usage of EmptyDir volume %q exceeds the limit %q.", volume.Name, volume.EmptyDir.SizeLimit.String()), nil
+		}
+	}
+	return "", nil
+}
+
+func (m *Manager) payload(pod *v1.Pod, volume v1.Volume) (map[string]fileProjection, error) {
+	switch {
+	case volume.Secret != nil:
+		return m.secretPayload(pod, volume.Secret)
+	case volume.ConfigMap != nil:
+		return m.configMapPayload(pod, volume.ConfigMap)
+	case volume.Projected != nil:
+		return m.projectedPayload(pod, volume.Projected)
+	case volume.DownwardAPI != nil:
+		return m.downwardAPIPayload(pod, volume.DownwardAPI.Items, modeOrDefault(volume.DownwardAPI.DefaultMode, v1.DownwardAPIVolumeSourceDefaultMode))
+	}
+	return nil, fmt.Errorf("unsupported volume source")
+}
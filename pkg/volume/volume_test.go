@@ -0,0 +1,594 @@
+package volume
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/virtual-kubelet/podman/pkg/converter"
+	"github.com/virtual-kubelet/podman/pkg/manager"
+)
+
+var testAllocatable = v1.ResourceList{
+	v1.ResourceCPU:    resource.MustParse("2"),
+	v1.ResourceMemory: resource.MustParse("1Gi"),
+}
+
+// newTestManager returns manager storing volumes in root with secrets and
+// configMaps of objs available to it
+func newTestManager(t *testing.T, root string, objs ...interface{}) *Manager {
+	t.Helper()
+	newIndexer := func() cache.Indexer {
+		return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	}
+	secrets, configMaps := newIndexer(), newIndexer()
+	for _, obj := range objs {
+		switch obj.(type) {
+		case *v1.Secret:
+			assert.NilError(t, secrets.Add(obj))
+		case *v1.ConfigMap:
+			assert.NilError(t, configMaps.Add(obj))
+		}
+	}
+	rm, err := manager.NewResourceManager(
+		corev1listers.NewPodLister(newIndexer()),
+		corev1listers.NewSecretLister(secrets),
+		corev1listers.NewConfigMapLister(configMaps),
+		corev1listers.NewServiceLister(newIndexer()),
+		corev1listers.NewServiceAccountLister(newIndexer()),
+	)
+	assert.NilError(t, err)
+	return New(root, rm, testAllocatable)
+}
+
+func newTestPod() *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "default",
+			UID:       "uid-web",
+			Labels:    map[string]string{"app": "web"},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name: "limited",
+					Resources: v1.ResourceRequirements{
+						Limits: v1.ResourceList{
+							v1.ResourceCPU:    resource.MustParse("500m"),
+							v1.ResourceMemory: resource.MustParse("64Mi"),
+						},
+					},
+				},
+				{Name: "unlimited"},
+			},
+		},
+	}
+}
+
+// files returns content of payload files keyed by path
+func files(payload map[string]fileProjection) map[string]string {
+	files := make(map[string]string, len(payload))
+	for path, file := range payload {
+		files[path] = string(file.data)
+	}
+	return files
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestDownwardAPIPayload(t *testing.T) {
+	m := newTestManager(t, "")
+	pod := newTestPod()
+
+	for _, tc := range []struct {
+		name string
+		item v1.DownwardAPIVolumeFile
+		data string
+		mode int32
+		err  bool
+	}{
+		{
+			name: "field",
+			item: v1.DownwardAPIVolumeFile{Path: "name", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+			data: "web",
+			mode: 0644,
+		},
+		{
+			name: "labels",
+			item: v1.DownwardAPIVolumeFile{Path: "labels", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.labels"}},
+			data: `app="web"`,
+			mode: 0644,
+		},
+		{
+			name: "mode",
+			item: v1.DownwardAPIVolumeFile{Path: "uid", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.uid"}, Mode: int32Ptr(0400)},
+			data: "uid-web",
+			mode: 0400,
+		},
+		{
+			name: "limit",
+			item: v1.DownwardAPIVolumeFile{Path: "cpu", ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "limited", Resource: "limits.cpu", Divisor: resource.MustParse("1m")}},
+			data: "500",
+			mode: 0644,
+		},
+		{
+			name: "allocatable",
+			item: v1.DownwardAPIVolumeFile{Path: "memory", ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "unlimited", Resource: "limits.memory"}},
+			data: "1073741824",
+			mode: 0644,
+		},
+		{
+			name: "unknown container",
+			item: v1.DownwardAPIVolumeFile{Path: "cpu", ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: "missing", Resource: "limits.cpu"}},
+			err:  true,
+		},
+		{
+			name: "unknown field",
+			item: v1.DownwardAPIVolumeFile{Path: "node", FieldRef: &v1.ObjectFieldSelector{FieldPath: "spec.nodeName"}},
+			err:  true,
+		},
+		{
+			name: "path traversal",
+			item: v1.DownwardAPIVolumeFile{Path: "../name", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+			err:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			payload, err := m.downwardAPIPayload(pod, []v1.DownwardAPIVolumeFile{tc.item}, 0644)
+			if tc.err {
+				assert.Assert(t, err != nil)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, files(payload), map[string]string{tc.item.Path: tc.data})
+			assert.Equal(t, payload[tc.item.Path].mode, tc.mode)
+		})
+	}
+}
+
+func TestSecretPayload(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default"},
+		Data: map[string][]byte{
+			"user":     []byte("admin"),
+			"password": []byte("s3cret"),
+		},
+	}
+	m := newTestManager(t, "", secret)
+	pod := newTestPod()
+
+	for _, tc := range []struct {
+		name   string
+		source v1.SecretVolumeSource
+		files  map[string]string
+		modes  map[string]int32
+		err    bool
+	}{
+		{
+			name:   "all keys",
+			source: v1.SecretVolumeSource{SecretName: "creds"},
+			files:  map[string]string{"user": "admin", "password": "s3cret"},
+			modes:  map[string]int32{"user": 0644, "password": 0644},
+		},
+		{
+			name:   "default mode",
+			source: v1.SecretVolumeSource{SecretName: "creds", DefaultMode: int32Ptr(0400)},
+			files:  map[string]string{"user": "admin", "password": "s3cret"},
+			modes:  map[string]int32{"user": 0400, "password": 0400},
+		},
+		{
+			name: "items",
+			source: v1.SecretVolumeSource{
+				SecretName:  "creds",
+				DefaultMode: int32Ptr(0440),
+				Items: []v1.KeyToPath{
+					{Key: "user", Path: "config/user"},
+					{Key: "password", Path: "password", Mode: int32Ptr(0400)},
+				},
+			},
+			files: map[string]string{"config/user": "admin", "password": "s3cret"},
+			modes: map[string]int32{"config/user": 0440, "password": 0400},
+		},
+		{
+			name: "missing key",
+			source: v1.SecretVolumeSource{
+				SecretName: "creds",
+				Items:      []v1.KeyToPath{{Key: "token", Path: "token"}},
+			},
+			err: true,
+		},
+		{
+			name: "optional missing key",
+			source: v1.SecretVolumeSource{
+				SecretName: "creds",
+				Optional:   boolPtr(true),
+				Items:      []v1.KeyToPath{{Key: "token", Path: "token"}, {Key: "user", Path: "user"}},
+			},
+			files: map[string]string{"user": "admin"},
+			modes: map[string]int32{"user": 0644},
+		},
+		{
+			name:   "missing secret",
+			source: v1.SecretVolumeSource{SecretName: "other"},
+			err:    true,
+		},
+		{
+			name:   "optional missing secret",
+			source: v1.SecretVolumeSource{SecretName: "other", Optional: boolPtr(true)},
+			files:  map[string]string{},
+			modes:  map[string]int32{},
+		},
+		{
+			name: "path traversal",
+			source: v1.SecretVolumeSource{
+				SecretName: "creds",
+				Items:      []v1.KeyToPath{{Key: "user", Path: "../../etc/passwd"}},
+			},
+			err: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			source := tc.source
+			payload, err := m.secretPayload(pod, &source)
+			if tc.err {
+				assert.Assert(t, err != nil)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, files(payload), tc.files)
+			assert.DeepEqual(t, modes(payload), tc.modes)
+		})
+	}
+}
+
+func TestConfigMapPayload(t *testing.T) {
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "default"},
+		Data:       map[string]string{"app.conf": "debug=true"},
+		BinaryData: map[string][]byte{"logo.png": {0x89, 0x50}},
+	}
+	m := newTestManager(t, "", configMap)
+	pod := newTestPod()
+
+	for _, tc := range []struct {
+		name   string
+		source v1.ConfigMapVolumeSource
+		files  map[string]string
+		err    bool
+	}{
+		{
+			name:   "data and binary data",
+			source: v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "config"}},
+			files:  map[string]string{"app.conf": "debug=true", "logo.png": "\x89\x50"},
+		},
+		{
+			name: "items",
+			source: v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: "config"},
+				Items:                []v1.KeyToPath{{Key: "app.conf", Path: "etc/app.conf"}},
+			},
+			files: map[string]string{"etc/app.conf": "debug=true"},
+		},
+		{
+			name:   "missing configMap",
+			source: v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "other"}},
+			err:    true,
+		},
+		{
+			name:   "optional missing configMap",
+			source: v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "other"}, Optional: boolPtr(true)},
+			files:  map[string]string{},
+		},
+		{
+			name: "absolute path",
+			source: v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: "config"},
+				Items:                []v1.KeyToPath{{Key: "app.conf", Path: "/etc/app.conf"}},
+			},
+			err: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			source := tc.source
+			payload, err := m.configMapPayload(pod, &source)
+			if tc.err {
+				assert.Assert(t, err != nil)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, files(payload), tc.files)
+			for path, file := range payload {
+				assert.Equal(t, file.mode, v1.ConfigMapVolumeSourceDefaultMode, path)
+			}
+		})
+	}
+}
+
+func TestProjectedPayload(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("abc")},
+	}
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "ca", Namespace: "default"},
+		Data:       map[string]string{"ca.crt": "CERT"},
+	}
+	m := newTestManager(t, "", secret, configMap)
+	pod := newTestPod()
+
+	source := &v1.ProjectedVolumeSource{
+		DefaultMode: int32Ptr(0440),
+		Sources: []v1.VolumeProjection{
+			{Secret: &v1.SecretProjection{
+				LocalObjectReference: v1.LocalObjectReference{Name: "creds"},
+				Items:                []v1.KeyToPath{{Key: "token", Path: "token", Mode: int32Ptr(0400)}},
+			}},
+			{ConfigMap: &v1.ConfigMapProjection{
+				LocalObjectReference: v1.LocalObjectReference{Name: "ca"},
+			}},
+			{DownwardAPI: &v1.DownwardAPIProjection{
+				Items: []v1.DownwardAPIVolumeFile{{Path: "namespace", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.namespace"}}},
+			}},
+			{Secret: &v1.SecretProjection{
+				LocalObjectReference: v1.LocalObjectReference{Name: "missing"},
+				Optional:             boolPtr(true),
+			}},
+			{ServiceAccountToken: &v1.ServiceAccountTokenProjection{Path: "sa-token"}},
+		},
+	}
+	payload, err := m.projectedPayload(pod, source)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, files(payload), map[string]string{
+		"token":     "abc",
+		"ca.crt":    "CERT",
+		"namespace": "default",
+	})
+	assert.DeepEqual(t, modes(payload), map[string]int32{
+		"token":     0400,
+		"ca.crt":    0440,
+		"namespace": 0440,
+	})
+
+	source.Sources = append(source.Sources, v1.VolumeProjection{
+		ConfigMap: &v1.ConfigMapProjection{LocalObjectReference: v1.LocalObjectReference{Name: "missing"}},
+	})
+	_, err = m.projectedPayload(pod, source)
+	assert.Assert(t, err != nil)
+}
+
+func TestModeOrDefault(t *testing.T) {
+	assert.Equal(t, modeOrDefault(nil, 0644), int32(0644))
+	assert.Equal(t, modeOrDefault(int32Ptr(0400), 0644), int32(0400))
+	assert.Equal(t, modeOrDefault(int32Ptr(0), 0644), int32(0))
+}
+
+func TestValidatePath(t *testing.T) {
+	for _, tc := range []struct {
+		path  string
+		valid bool
+	}{
+		{path: "token", valid: true},
+		{path: "config/app.conf", valid: true},
+		{path: "..config", valid: false},
+		{path: "..data", valid: false},
+		{path: "config..d/file", valid: true},
+		{path: "", valid: false},
+		{path: "/etc/passwd", valid: false},
+		{path: "../token", valid: false},
+		{path: "config/../../token", valid: false},
+	} {
+		err := validatePath(tc.path)
+		assert.Equal(t, err == nil, tc.valid, "path %q: %v", tc.path, err)
+	}
+}
+
+func TestWriteAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volume")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	payload := map[string]fileProjection{
+		"user":        {data: []byte("admin"), mode: 0644},
+		"config/mode": {data: []byte("debug"), mode: 0400},
+	}
+	assert.NilError(t, writeAtomic(dir, payload))
+	assertVolume(t, dir, payload)
+	first, err := os.Readlink(filepath.Join(dir, dataDirName))
+	assert.NilError(t, err)
+	assert.Assert(t, !changed(filepath.Join(dir, first), payload))
+
+	// unchanged payload is not rewritten
+	assert.NilError(t, writeAtomic(dir, payload))
+	current, err := os.Readlink(filepath.Join(dir, dataDirName))
+	assert.NilError(t, err)
+	assert.Equal(t, current, first)
+
+	// changed payload is written into new directory which "..data" is
+	// switched to, old data and paths no longer projected are removed
+	updated := map[string]fileProjection{
+		"user":     {data: []byte("root"), mode: 0644},
+		"password": {data: []byte("s3cret"), mode: 0600},
+	}
+	assert.Assert(t, changed(filepath.Join(dir, first), updated))
+	assert.NilError(t, writeAtomic(dir, updated))
+	assertVolume(t, dir, updated)
+	current, err = os.Readlink(filepath.Join(dir, dataDirName))
+	assert.NilError(t, err)
+	assert.Assert(t, current != first)
+	_, err = os.Stat(filepath.Join(dir, first))
+	assert.Assert(t, os.IsNotExist(err), "old data must be removed, got %v", err)
+	_, err = os.Lstat(filepath.Join(dir, "config"))
+	assert.Assert(t, os.IsNotExist(err), "path no longer projected must be removed, got %v", err)
+	_, err = os.Lstat(filepath.Join(dir, newDataDirName))
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+func TestChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volume")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "user"), []byte("admin"), 0644))
+	assert.NilError(t, os.Chmod(filepath.Join(dir, "user"), 0644))
+
+	for _, tc := range []struct {
+		name    string
+		payload map[string]fileProjection
+		changed bool
+	}{
+		{name: "same", payload: map[string]fileProjection{"user": {data: []byte("admin"), mode: 0644}}},
+		{name: "data", payload: map[string]fileProjection{"user": {data: []byte("root"), mode: 0644}}, changed: true},
+		{name: "mode", payload: map[string]fileProjection{"user": {data: []byte("admin"), mode: 0400}}, changed: true},
+		{name: "added", payload: map[string]fileProjection{"user": {data: []byte("admin"), mode: 0644}, "password": {data: []byte("x"), mode: 0644}}, changed: true},
+		{name: "removed", payload: map[string]fileProjection{}, changed: true},
+		{name: "renamed", payload: map[string]fileProjection{"name": {data: []byte("admin"), mode: 0644}}, changed: true},
+	} {
+		assert.Equal(t, changed(dir, tc.payload), tc.changed, tc.name)
+	}
+	assert.Assert(t, changed(filepath.Join(dir, "missing"), map[string]fileProjection{}))
+}
+
+func TestSetup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volume")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default"},
+		Data:       map[string][]byte{"user": []byte("admin")},
+	}
+	m := newTestManager(t, dir, secret)
+	pod := newTestPod()
+	pod.Spec.Volumes = []v1.Volume{
+		{Name: "creds", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "creds"}}},
+		{Name: "host", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/tmp"}}},
+	}
+
+	assert.NilError(t, m.Setup(pod))
+	assertVolume(t, converter.PodVolumePath(dir, pod, "creds"), map[string]fileProjection{
+		"user": {data: []byte("admin"), mode: v1.SecretVolumeSourceDefaultMode},
+	})
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+		Name:         "missing",
+		VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "missing"}},
+	})
+	err = m.Setup(pod)
+	assert.ErrorContains(t, err, "volume missing")
+
+	assert.NilError(t, m.Cleanup(pod))
+	_, err = os.Stat(converter.PodDir(dir, pod))
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+// assertVolume checks visible paths of the volume in dir have content and
+// mode of payload
+func assertVolume(t *testing.T, dir string, payload map[string]fileProjection) {
+	t.Helper()
+	for path, file := range payload {
+		fullPath := filepath.Join(dir, path)
+		data, err := ioutil.ReadFile(fullPath)
+		assert.NilError(t, err)
+		assert.Equal(t, string(data), string(file.data), path)
+		info, err := os.Stat(fullPath)
+		assert.NilError(t, err)
+		assert.Equal(t, info.Mode().Perm(), os.FileMode(file.mode).Perm(), path)
+		link, err := os.Readlink(filepath.Join(dir, strings.SplitN(path, "/", 2)[0]))
+		assert.NilError(t, err)
+		assert.Assert(t, strings.HasPrefix(link, dataDirName+"/"), "%s links to %s", path, link)
+	}
+}
+
+// modes returns modes of payload files keyed by path
+func modes(payload map[string]fileProjection) map[string]int32 {
+	modes := make(map[string]int32, len(payload))
+	for path, file := range payload {
+		modes[path] = file.mode
+	}
+	return modes
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestSetupEmptyDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "volume")
+	assert.NilError(t, err)
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "cache")
+
+	assert.NilError(t, setupEmptyDir(dir, &v1.EmptyDirVolumeSource{}))
+	info, err := os.Stat(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0777))
+
+	// content is preserved when the volume is set up again
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "data"), []byte("cached"), 0644))
+	assert.NilError(t, setupEmptyDir(dir, &v1.EmptyDirVolumeSource{}))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "data"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "cached")
+}
+
+func TestDiskUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volume")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	usage, err := diskUsage(filepath.Join(dir, "missing"))
+	assert.NilError(t, err)
+	assert.Equal(t, usage, int64(0))
+
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "a", "b"), 0755))
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "one"), make([]byte, 100), 0644))
+	assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "a", "b", "two"), make([]byte, 50), 0644))
+	// symlinks are not followed nor counted
+	assert.NilError(t, os.Symlink(filepath.Join(dir, "one"), filepath.Join(dir, "link")))
+	usage, err = diskUsage(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, usage, int64(150))
+}
+
+func TestExceededLimit(t *testing.T) {
+	root, err := ioutil.TempDir("", "volume")
+	assert.NilError(t, err)
+	defer os.RemoveAll(root)
+	m := newTestManager(t, root)
+	pod := newTestPod()
+	limit := resource.MustParse("1Ki")
+	pod.Spec.Volumes = []v1.Volume{
+		{Name: "limited", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{SizeLimit: &limit}}},
+		{Name: "unlimited", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+	}
+	assert.NilError(t, m.Setup(pod))
+
+	for _, tc := range []struct {
+		name    string
+		volume  string
+		size    int
+		message string
+	}{
+		{name: "under limit", volume: "limited", size: 1000},
+		{name: "unlimited", volume: "unlimited", size: 4096},
+		{name: "over limit", volume: "limited", size: 1025, message: `Usage of EmptyDir volume "limited" exceeds the limit "1Ki".`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := converter.PodVolumePath(root, pod, tc.volume)
+			assert.NilError(t, ioutil.WriteFile(filepath.Join(dir, "data"), make([]byte, tc.size), 0644))
+			message, err := m.ExceededLimit(pod)
+			assert.NilError(t, err)
+			assert.Equal(t, message, tc.message)
+		})
+	}
+}